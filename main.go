@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
@@ -10,6 +11,8 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"unicode/utf8"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/spf13/cobra"
@@ -44,14 +47,25 @@ func info(file string) error {
 	m, err := module.Load(file)
 	if (err == nil) {
 		slog.Info("Info", "title", m.Title(), "num-patterns", m.NumPatterns())
+		tracker := m.Tracker()
+		slog.Info("Tracker",
+			"name", tracker.Name,
+			"version", tracker.Version,
+			"confidence", tracker.Confidence.String())
 		for idx, sample := range m.Samples() {
 			slog.Info("Sample",
 				"index", idx,
 				"name", sample.Name(),
 				"filename", sample.Filename())
 		}
+		for idx, instrument := range m.Instruments() {
+			slog.Info("Instrument",
+				"index", idx,
+				"name", instrument.Name(),
+				"filename", instrument.Filename())
+		}
 
-		if (m.Type() == module.PROTRACKER) {
+		if (m.Type() == module.PROTRACKER || m.Type() == module.SOUNDTRACKER) {
 			pt := m.(*module.ProTracker)
 			for idx, patternNum := range pt.SequenceTable() {
 				// If we've already moved past the song length in the sequence table, short circuit
@@ -68,6 +82,26 @@ func info(file string) error {
 			}
 		}
 
+		if (m.Type() == module.IMPULSETRACKER) {
+			it := m.(*module.ImpulseTracker)
+			if it.Message() != "" {
+				slog.Info("Message", "text", it.Message())
+			}
+		}
+
+		if (m.Type() == module.SCREAMTRACKER) {
+			st := m.(*module.ScreamTracker)
+			slog.Info("Channels", "num-channels", st.NumChannels(), "stereo", st.IsStereo())
+			channels := st.Channels()
+			for idx, channel := range channels[:st.NumChannels()] {
+				slog.Info("Channel",
+					"index", idx,
+					"type", stChannelTypeNames[channel.Type],
+					"enabled", channel.Enabled,
+					"pan", channel.Pan)
+			}
+		}
+
 	} else {
 		return err
 	}
@@ -91,7 +125,8 @@ func dumpAll(infile string, dir string) error {
 	}
 	samples := m.Samples()
 
-	destdir := filepath.Join(dir, filepath.Base(infile))
+	archive, member := module.SplitArchivePath(infile)
+	destdir := filepath.Join(dir, filepath.Base(archive), filepath.FromSlash(member))
 	if err := os.MkdirAll(destdir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
@@ -115,31 +150,60 @@ func dumpAll(infile string, dir string) error {
 }
 
 func scanModForDB(inFile string, dbconn *sql.DB) error {
+	slog.Info("Loading file", "file", inFile)
+	archive, member := module.SplitArchivePath(inFile)
+	if member != "" {
+		m, err := module.Load(inFile)
+		if err != nil {
+			return err
+		}
+		return insertModForDB(m, path.Base(archive)+"#"+member, dbconn)
+	}
 
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println(err)
+	entries, err := module.LoadArchive(inFile)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		filename := path.Base(inFile)
+		if entry.Path != "" {
+			filename += "#" + entry.Path
 		}
-	}()
-	slog.Info("Loading file", "file", inFile)
-	m, err := module.Load(inFile)
+		if entry.Err != nil {
+			slog.Warn("Failed to load module", "file", filename, "error", entry.Err)
+			continue
+		}
+		if err := insertModForDB(entry.Module, filename, dbconn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertModForDB(m module.Module, filename string, dbconn *sql.DB) error {
 	samples := m.Samples()
 
-	filename := path.Base(inFile)
-	insert, err := dbconn.Prepare("REPLACE INTO modfile(title, filename) VALUES (?,?)")
+	tracker := m.Tracker()
+	insert, err := dbconn.Prepare("REPLACE INTO modfile(title, filename, tracker, tracker_version, tracker_confidence) VALUES (?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
-	insert.Exec(m.Title(), filename)
+	_, err = insert.Exec(m.Title(), filename, tracker.Name, tracker.Version, tracker.Confidence.String())
 	insert.Close()
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %w", filename, err)
+	}
 
 	// Delete any existing sample records
 	delete, err := dbconn.Prepare("DELETE FROM sample WHERE modfile = ?")
 	if err != nil {
 		return err
 	}
-	delete.Exec(filename)
+	_, err = delete.Exec(filename)
 	delete.Close()
+	if err != nil {
+		return fmt.Errorf("failed to clear samples of %s: %w", filename, err)
+	}
 
 	// insert samples
 	for i, sample := range samples {
@@ -155,13 +219,46 @@ func scanModForDB(inFile string, dbconn *sql.DB) error {
 		if err != nil {
 			return err
 		}
-		sampleInsert.Exec(sample.Name(), sample.Filename(), sChecksum, filename, int(i+1), len(sample.Data()))
+		_, err = sampleInsert.Exec(sample.Name(), sample.Filename(), sChecksum, filename, int(i+1), len(sample.Data()))
 		sampleInsert.Close()
+		if err != nil {
+			return fmt.Errorf("failed to record sample %d of %s: %w", i+1, filename, err)
+		}
 	}
 
 	return nil
 }
 
+// trackerColumns are the modfile columns added since the first schema.sql
+var trackerColumns = []struct {
+	name       string
+	definition string
+}{
+	{"tracker", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"tracker_version", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"tracker_confidence", "VARCHAR(16) NOT NULL DEFAULT ''"},
+}
+
+// migrateDB adds any tracker columns missing from a database created with
+// an older schema.sql
+func migrateDB(dbconn *sql.DB) error {
+	for _, column := range trackerColumns {
+		var count int
+		err := dbconn.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'modfile' AND COLUMN_NAME = ?", column.name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check the modfile table: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+		slog.Info("Adding column to modfile table", "column", column.name)
+		if _, err := dbconn.Exec(fmt.Sprintf("ALTER TABLE modfile ADD COLUMN %s %s", column.name, column.definition)); err != nil {
+			return fmt.Errorf("failed to add %s to the modfile table: %w", column.name, err)
+		}
+	}
+	return nil
+}
+
 func createDB(inPath string, dbConfig DBConfig) error {
 	if !checkExists(inPath) {
 		return fmt.Errorf("input path does not exist: %s", inPath)
@@ -175,6 +272,9 @@ func createDB(inPath string, dbConfig DBConfig) error {
 	if err := dbconn.Ping(); err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if err := migrateDB(dbconn); err != nil {
+		return err
+	}
 
 	var filesToScan []string
 	if info, err := os.Stat(inPath); err == nil && info.IsDir() {
@@ -198,8 +298,55 @@ func createDB(inPath string, dbConfig DBConfig) error {
 	return nil
 }
 
+func decrunch(infile string, output string) error {
+	if !checkExists(infile) {
+		return fmt.Errorf("input file does not exist: %s", infile)
+	}
+
+	slog.Info("Decrunching", "file", infile)
+	data, err := module.ReadFile(infile)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	plain, err := module.DecrunchPowerPacker(data)
+	if err != nil {
+		return fmt.Errorf("failed to decrunch: %w", err)
+	}
+
+	if err := os.WriteFile(output, plain, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", output, err)
+	}
+	slog.Info("Wrote", "num-bytes", len(plain), "out-file", output)
+	return nil
+}
+
+func extract(infile string, output string) error {
+	if !checkExists(infile) {
+		return fmt.Errorf("input file does not exist: %s", infile)
+	}
+
+	slog.Info("Extracting", "file", infile)
+	data, err := module.ReadFile(infile)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	music, err := module.ExtractUMX(data)
+	if err != nil {
+		return fmt.Errorf("failed to extract: %w", err)
+	}
+
+	if err := os.WriteFile(output, music, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", output, err)
+	}
+	slog.Info("Wrote", "num-bytes", len(music), "out-file", output)
+	return nil
+}
+
+// checkExists reports whether path exists, ignoring any "#member" suffix
+// addressing a module inside an archive
 func checkExists(path string) bool {
-	_, err := os.Stat(path)
+	file, _ := module.SplitArchivePath(path)
+	_, err := os.Stat(file)
 	if err != nil {
 		return false
 	}
@@ -215,7 +362,9 @@ func stripRegex(in string) string {
 type PatternExportNote struct {
 	Note       string `json:"note"`
 	Period     int    `json:"period"`
+	Key        int    `json:"key,omitempty"` // Note number (1 = C-0) or special key, omitted for MOD
 	Instrument int    `json:"instrument"`
+	Volume     *int   `json:"volume,omitempty"` // Volume column byte, omitted when empty
 	Effect     int    `json:"effect"`
 	Parameter  int    `json:"parameter"`
 }
@@ -229,6 +378,8 @@ type PatternExport struct {
 	PatternNumber int                 `json:"pattern_number"`
 	NumChannels   int                 `json:"num_channels"`
 	NumRows       int                 `json:"num_rows"`
+	HeaderLength  uint32              `json:"header_length,omitempty"` // XM pattern header length, omitted when 9
+	HeaderExtra   string              `json:"header_extra,omitempty"`  // Base64 encoded bytes padding the XM pattern header past 9
 	Rows          []PatternExportRow  `json:"rows"`
 }
 
@@ -247,6 +398,7 @@ type SampleExport struct {
 type XMSampleExport struct {
 	Number       int    `json:"number"`
 	Name         string `json:"name"`
+	NameBytes    string `json:"name_bytes,omitempty"` // Base64 encoded name field, when the name alone doesn't give its bytes
 	Length       uint32 `json:"length"`
 	LoopStart    uint32 `json:"loop_start"`
 	LoopEnd      uint32 `json:"loop_end"`
@@ -256,17 +408,94 @@ type XMSampleExport struct {
 	Panning      uint8  `json:"panning"`
 	RelativeNote uint8  `json:"relative_note"`
 	DataType     uint8  `json:"data_type"`
+	HeaderExtra  string `json:"header_extra,omitempty"` // Base64 encoded bytes padding the sample header past 40
 	Data         string `json:"data"` // Base64 encoded
 }
 
+type EnvelopePointExport struct {
+	Tick  uint16 `json:"tick"`
+	Value uint16 `json:"value"`
+}
+
+type EnvelopeExport struct {
+	Points       []EnvelopePointExport `json:"points"`
+	UnusedPoints []EnvelopePointExport `json:"unused_points,omitempty"` // Slots after the points, omitted when all zero
+	Sustain      uint8                 `json:"sustain"`
+	LoopStart    uint8                 `json:"loop_start"`
+	LoopEnd      uint8                 `json:"loop_end"`
+	Flags        uint8                 `json:"flags"`
+}
+
+type VibratoExport struct {
+	Type  uint8 `json:"type"`
+	Sweep uint8 `json:"sweep"`
+	Depth uint8 `json:"depth"`
+	Rate  uint8 `json:"rate"`
+}
+
 type InstrumentExport struct {
-	Number  int              `json:"number"`
-	Name    string           `json:"name"`
-	Samples []XMSampleExport `json:"samples"`
+	Number           int              `json:"number"`
+	Name             string           `json:"name"`
+	NameBytes        string           `json:"name_bytes,omitempty"` // Base64 encoded name field, when the name alone doesn't give its bytes
+	Type             uint8            `json:"type"`
+	HeaderExtra      string           `json:"header_extra,omitempty"`       // Base64 encoded reserved header bytes
+	SampleHeaderSize uint32           `json:"sample_header_size,omitempty"` // Omitted for instruments without samples
+	Keymap           []int            `json:"keymap,omitempty"`             // Sample number for each of the 96 notes
+	VolumeEnvelope   EnvelopeExport   `json:"volume_envelope"`
+	PanningEnvelope  EnvelopeExport   `json:"panning_envelope"`
+	Vibrato          VibratoExport    `json:"vibrato"`
+	Fadeout          uint16           `json:"fadeout"`
+	Samples          []XMSampleExport `json:"samples"`
+}
+
+type ITEnvelopeNodeExport struct {
+	Tick  uint16 `json:"tick"`
+	Value int8   `json:"value"`
+}
+
+type ITEnvelopeExport struct {
+	Nodes        []ITEnvelopeNodeExport `json:"nodes"`
+	Flags        uint8                  `json:"flags"`
+	LoopStart    uint8                  `json:"loop_start"`
+	LoopEnd      uint8                  `json:"loop_end"`
+	SustainStart uint8                  `json:"sustain_start"`
+	SustainEnd   uint8                  `json:"sustain_end"`
+}
+
+type ITKeyboardExport struct {
+	Note   uint8 `json:"note"`
+	Sample uint8 `json:"sample"`
+}
+
+type ITInstrumentExport struct {
+	Number               int                `json:"number"`
+	Name                 string             `json:"name"`
+	Filename             string             `json:"filename"`
+	NNA                  uint8              `json:"nna"`
+	DuplicateCheckType   uint8              `json:"duplicate_check_type"`
+	DuplicateCheckAction uint8              `json:"duplicate_check_action"`
+	Fadeout              uint16             `json:"fadeout"`
+	PitchPanSeparation   int8               `json:"pitch_pan_separation"`
+	PitchPanCenter       uint8              `json:"pitch_pan_center"`
+	GlobalVolume         uint8              `json:"global_volume"`
+	DefaultPan           *uint8             `json:"default_pan,omitempty"` // Omitted when the instrument doesn't set a pan
+	RandomVolume         uint8              `json:"random_volume"`
+	RandomPan            uint8              `json:"random_pan"`
+	Keyboard             []ITKeyboardExport `json:"keyboard"`
+	VolumeEnvelope       ITEnvelopeExport   `json:"volume_envelope"`
+	PanningEnvelope      ITEnvelopeExport   `json:"panning_envelope"`
+	PitchEnvelope        ITEnvelopeExport   `json:"pitch_envelope"`
+}
+
+type ChannelExport struct {
+	Type    string `json:"type"` // "left", "right", "adlib-melody", "adlib-drum" or "unused"
+	Index   int    `json:"index"`
+	Enabled bool   `json:"enabled"`
+	Pan     uint8  `json:"pan"` // 0 (left) to 15 (right)
 }
 
 type ModulePatternExport struct {
-	Format          string             `json:"format,omitempty"`       // "protracker" or "fasttracker"
+	Format          string             `json:"format,omitempty"`       // "protracker", "soundtracker", "fasttracker", "screamtracker" or "impulsetracker"
 	Title           string             `json:"title"`
 	SongLength      int                `json:"song_length"`
 	RestartPosition int                `json:"restart_position"`
@@ -274,13 +503,177 @@ type ModulePatternExport struct {
 	PatternOrder    []int              `json:"pattern_order"`
 	Samples         []SampleExport     `json:"samples"`
 	Patterns        []PatternExport    `json:"patterns"`
+	// MOD-specific fields
+	Tag string `json:"tag,omitempty"`
 	// XM-specific fields (omitted for MOD files)
-	Author      string              `json:"author,omitempty"`
-	Version     uint16              `json:"version,omitempty"`
-	Flags       uint16              `json:"flags,omitempty"`
-	Tempo       uint16              `json:"tempo,omitempty"`
-	BPM         uint16              `json:"bpm,omitempty"`
-	Instruments []InstrumentExport  `json:"instruments,omitempty"`
+	TitleBytes  string             `json:"title_bytes,omitempty"` // Base64 encoded title field, when the title alone doesn't give its bytes
+	Author      string             `json:"author,omitempty"`
+	AuthorBytes string             `json:"author_bytes,omitempty"`
+	Version     uint16             `json:"version,omitempty"`
+	HeaderSize  uint32             `json:"header_size,omitempty"`
+	HeaderExtra string             `json:"header_extra,omitempty"` // Base64 encoded bytes after the order table
+	OrderTable  string             `json:"order_table,omitempty"`  // Base64 encoded order table, when it holds entries past the song
+	Flags       uint16             `json:"flags,omitempty"`
+	Tempo       uint16             `json:"tempo,omitempty"`
+	BPM         uint16             `json:"bpm,omitempty"`
+	Instruments []InstrumentExport `json:"instruments,omitempty"`
+	// S3M-specific fields
+	Channels []ChannelExport `json:"channels,omitempty"`
+	// IT-specific fields
+	ITInstruments []ITInstrumentExport `json:"it_instruments,omitempty"`
+	Message       string               `json:"message,omitempty"`
+}
+
+var stChannelTypeNames = map[module.STChannelType]string{
+	module.ST_CHANNEL_UNUSED:       "unused",
+	module.ST_CHANNEL_LEFT:         "left",
+	module.ST_CHANNEL_RIGHT:        "right",
+	module.ST_CHANNEL_ADLIB_MELODY: "adlib-melody",
+	module.ST_CHANNEL_ADLIB_DRUM:   "adlib-drum",
+}
+
+// exportPattern converts a pattern into its JSON representation
+func exportPattern(patNum int, pattern module.Pattern) PatternExport {
+	patternExport := PatternExport{
+		PatternNumber: patNum,
+		NumChannels:   pattern.NumChannels(),
+		NumRows:       pattern.NumRows(),
+		Rows:          make([]PatternExportRow, 0),
+	}
+	if pattern.HeaderLength() != 9 {
+		patternExport.HeaderLength = pattern.HeaderLength()
+	}
+	patternExport.HeaderExtra = base64.StdEncoding.EncodeToString(pattern.HeaderExtra())
+
+	for rowIdx := 0; rowIdx < pattern.NumRows(); rowIdx++ {
+		row, err := pattern.GetRow(rowIdx)
+		if err != nil {
+			continue
+		}
+
+		notes := row.Notes()
+		channels := make([]PatternExportNote, pattern.NumChannels())
+		for chanIdx := 0; chanIdx < pattern.NumChannels(); chanIdx++ {
+			note := notes[chanIdx]
+			noteStr := "---"
+			if note.Period() > 0 || note.Key() > 0 {
+				if str, err := note.ToString(); err == nil {
+					noteStr = str
+				}
+			}
+
+			var volume *int
+			if note.HasVolume() {
+				v := note.Volume()
+				volume = &v
+			}
+
+			channels[chanIdx] = PatternExportNote{
+				Note:       noteStr,
+				Period:     note.Period(),
+				Key:        note.Key(),
+				Instrument: note.Instrument(),
+				Volume:     volume,
+				Effect:     note.Effect(),
+				Parameter:  note.Parameter(),
+			}
+		}
+
+		patternExport.Rows = append(patternExport.Rows, PatternExportRow{
+			RowNumber: rowIdx,
+			Channels:  channels,
+		})
+	}
+
+	return patternExport
+}
+
+// exportEnvelope converts an XM envelope into its JSON representation
+func exportEnvelope(envelope module.FTEnvelope) EnvelopeExport {
+	points := make([]EnvelopePointExport, len(envelope.Points))
+	for i, point := range envelope.Points {
+		points[i] = EnvelopePointExport{Tick: point.Tick, Value: point.Value}
+	}
+	var unused []EnvelopePointExport
+	for _, point := range envelope.Unused {
+		if point.Tick != 0 || point.Value != 0 {
+			unused = make([]EnvelopePointExport, len(envelope.Unused))
+			for i, point := range envelope.Unused {
+				unused[i] = EnvelopePointExport{Tick: point.Tick, Value: point.Value}
+			}
+			break
+		}
+	}
+	return EnvelopeExport{
+		Points:       points,
+		UnusedPoints: unused,
+		Sustain:      envelope.Sustain,
+		LoopStart:    envelope.LoopStart,
+		LoopEnd:      envelope.LoopEnd,
+		Flags:        envelope.Flags,
+	}
+}
+
+// exportRawName returns a name field's bytes base64 encoded when the name
+// alone wouldn't give them back, as when there's text after a null or bytes
+// that aren't valid UTF-8, or "" when it would
+func exportRawName(name string, raw []byte) string {
+	padded := make([]byte, len(raw))
+	copy(padded, name)
+	if utf8.ValidString(name) && bytes.Equal(padded, raw) {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// importRawName returns a name field's bytes if the export kept them, or the
+// name otherwise
+func importRawName(name string, raw string) (string, error) {
+	if raw == "" {
+		return name, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// loopWords converts a loop from start to end, counted in frames of
+// frameSize bytes, to a repeat offset and length in words. A loop that ends
+// before it starts has no length.
+func loopWords(start uint32, end uint32, frameSize int) (int, int) {
+	length := 0
+	if end > start {
+		length = int(end-start) * frameSize / 2
+	}
+	return int(start) * frameSize / 2, length
+}
+
+// allZero reports whether every byte of data is zero
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// exportITEnvelope converts an IT envelope into its JSON representation
+func exportITEnvelope(envelope module.ITEnvelope) ITEnvelopeExport {
+	nodes := make([]ITEnvelopeNodeExport, len(envelope.Nodes))
+	for i, node := range envelope.Nodes {
+		nodes[i] = ITEnvelopeNodeExport{Tick: node.Tick, Value: node.Value}
+	}
+	return ITEnvelopeExport{
+		Nodes:        nodes,
+		Flags:        envelope.Flags,
+		LoopStart:    envelope.LoopStart,
+		LoopEnd:      envelope.LoopEnd,
+		SustainStart: envelope.SustainStart,
+		SustainEnd:   envelope.SustainEnd,
+	}
 }
 
 func dumpPatterns(infile string, output string) error {
@@ -298,8 +691,12 @@ func dumpPatterns(infile string, output string) error {
 
 	// Handle different module formats
 	switch m.Type() {
-	case module.PROTRACKER:
+	case module.PROTRACKER, module.SOUNDTRACKER:
 		pt := m.(*module.ProTracker)
+		format := "protracker"
+		if m.Type() == module.SOUNDTRACKER {
+			format = "soundtracker"
+		}
 
 		// Build pattern order from sequence table
 		patternOrder := make([]int, pt.SongLength())
@@ -332,51 +729,11 @@ func dumpPatterns(infile string, output string) error {
 				slog.Warn("Failed to get pattern", "pattern", patNum, "error", err)
 				continue
 			}
-
-			patternExport := PatternExport{
-				PatternNumber: patNum,
-				NumChannels:   pattern.NumChannels(),
-				NumRows:       pattern.NumRows(),
-				Rows:          make([]PatternExportRow, 0),
-			}
-
-			for rowIdx := 0; rowIdx < pattern.NumRows(); rowIdx++ {
-				row, err := pattern.GetRow(rowIdx)
-				if err != nil {
-					continue
-				}
-
-				notes := row.Notes()
-				channels := make([]PatternExportNote, pattern.NumChannels())
-				for chanIdx := 0; chanIdx < pattern.NumChannels(); chanIdx++ {
-					note := notes[chanIdx]
-					noteStr := "---"
-					if note.Period() > 0 {
-						if str, err := note.ToString(); err == nil {
-							noteStr = str
-						}
-					}
-
-					channels[chanIdx] = PatternExportNote{
-						Note:       noteStr,
-						Period:     note.Period(),
-						Instrument: note.Instrument(),
-						Effect:     note.Effect(),
-						Parameter:  note.Parameter(),
-					}
-				}
-
-				patternExport.Rows = append(patternExport.Rows, PatternExportRow{
-					RowNumber: rowIdx,
-					Channels:  channels,
-				})
-			}
-
-			patterns = append(patterns, patternExport)
+			patterns = append(patterns, exportPattern(patNum, pattern))
 		}
 
 		export = ModulePatternExport{
-			Format:          "protracker",
+			Format:          format,
 			Title:           pt.Title(),
 			SongLength:      int(pt.SongLength()),
 			RestartPosition: pt.RestartPos(),
@@ -384,6 +741,7 @@ func dumpPatterns(infile string, output string) error {
 			PatternOrder:    patternOrder,
 			Samples:         samples,
 			Patterns:        patterns,
+			Tag:             pt.Tag(),
 		}
 
 	case module.FASTTRACKER:
@@ -394,6 +752,11 @@ func dumpPatterns(infile string, output string) error {
 		for i := 0; i < int(ft.PatternSize()); i++ {
 			patternOrder[i] = int(ft.OrderTable()[i])
 		}
+		// Entries past the song are only kept when there are any
+		orderTable := ""
+		if !allZero(ft.OrderTable()[ft.PatternSize():]) {
+			orderTable = base64.StdEncoding.EncodeToString(ft.OrderTable())
+		}
 
 		// Export samples (flattened for backward compatibility)
 		samples := make([]SampleExport, 0)
@@ -421,6 +784,7 @@ func dumpPatterns(infile string, output string) error {
 				xmSample := XMSampleExport{
 					Number:       sIdx + 1,
 					Name:         sample.Name(),
+					NameBytes:    exportRawName(sample.Name(), sample.RawName()),
 					Length:       sample.Length(),
 					LoopStart:    sample.LoopStart(),
 					LoopEnd:      sample.LoopEnd(),
@@ -430,39 +794,201 @@ func dumpPatterns(infile string, output string) error {
 					Panning:      sample.Panning(),
 					RelativeNote: sample.RelativeNote(),
 					DataType:     sample.DataType(),
+					HeaderExtra:  base64.StdEncoding.EncodeToString(sample.HeaderExtra()),
 					Data:         base64.StdEncoding.EncodeToString(sample.Data()),
 				}
 				instSamples = append(instSamples, xmSample)
 			}
 
+			keymap := make([]int, 0)
+			if len(inst.Samples()) > 0 {
+				for _, sampleNum := range inst.Keymap() {
+					keymap = append(keymap, int(sampleNum))
+				}
+			}
+			vibrato := inst.Vibrato()
+
 			instExport := InstrumentExport{
-				Number:  idx + 1,
-				Name:    inst.Name(),
+				Number:           idx + 1,
+				Name:             inst.Name(),
+				NameBytes:        exportRawName(inst.Name(), inst.RawName()),
+				Type:             inst.Type(),
+				HeaderExtra:      base64.StdEncoding.EncodeToString(inst.HeaderExtra()),
+				SampleHeaderSize: inst.SampleHeaderSize(),
+				Keymap:           keymap,
+				VolumeEnvelope:   exportEnvelope(inst.VolumeEnvelope()),
+				PanningEnvelope:  exportEnvelope(inst.PanningEnvelope()),
+				Vibrato: VibratoExport{
+					Type:  vibrato.Type,
+					Sweep: vibrato.Sweep,
+					Depth: vibrato.Depth,
+					Rate:  vibrato.Rate,
+				},
+				Fadeout: inst.Fadeout(),
 				Samples: instSamples,
 			}
 			instruments = append(instruments, instExport)
 		}
 
-		// Note: XM patterns aren't currently parsed, so patterns will be empty
+		// Export all patterns
 		patterns := make([]PatternExport, 0)
+		for patNum, pattern := range ft.Patterns() {
+			patterns = append(patterns, exportPattern(patNum, pattern))
+		}
 
 		export = ModulePatternExport{
 			Format:          "fasttracker",
 			Title:           ft.Title(),
+			TitleBytes:      exportRawName(ft.Title(), ft.RawTitle()),
 			SongLength:      int(ft.PatternSize()),
 			RestartPosition: int(ft.RestartPosition()),
-			NumChannels:     0, // TODO: Parse from XM header
+			NumChannels:     ft.NumChannels(),
 			PatternOrder:    patternOrder,
 			Samples:         samples,
 			Patterns:        patterns,
-			Author:          ft.Author(),
+			Author:          ft.TrackerName(),
+			AuthorBytes:     exportRawName(ft.TrackerName(), ft.RawTrackerName()),
 			Version:         ft.Version(),
+			HeaderSize:      ft.HeaderSize(),
+			HeaderExtra:     base64.StdEncoding.EncodeToString(ft.HeaderExtra()),
+			OrderTable:      orderTable,
 			Flags:           ft.Flags(),
 			Tempo:           ft.Tempo(),
 			BPM:             ft.BPM(),
 			Instruments:     instruments,
 		}
 
+	case module.SCREAMTRACKER:
+		st := m.(*module.ScreamTracker)
+
+		patternOrder := make([]int, len(st.OrderList()))
+		for i, patNum := range st.OrderList() {
+			patternOrder[i] = int(patNum)
+		}
+
+		// Export samples, with loop points converted to words as for XM
+		samples := make([]SampleExport, 0)
+		for _, sample := range st.Samples() {
+			stSample := sample.(module.STSample)
+			repeatOffset, repeatLength := loopWords(stSample.LoopStart(), stSample.LoopEnd(), 1)
+			sampleExport := SampleExport{
+				Number:       len(samples) + 1,
+				Name:         stSample.Name(),
+				Length:       int(stSample.Length()),
+				Volume:       int(stSample.Volume()),
+				RepeatOffset: repeatOffset,
+				RepeatLength: repeatLength,
+				Data:         base64.StdEncoding.EncodeToString(stSample.Data()),
+			}
+			samples = append(samples, sampleExport)
+		}
+
+		patterns := make([]PatternExport, 0)
+		for patNum, pattern := range st.Patterns() {
+			patterns = append(patterns, exportPattern(patNum, pattern))
+		}
+
+		channels := make([]ChannelExport, 0)
+		for _, channel := range st.Channels() {
+			channels = append(channels, ChannelExport{
+				Type:    stChannelTypeNames[channel.Type],
+				Index:   channel.Index,
+				Enabled: channel.Enabled,
+				Pan:     channel.Pan,
+			})
+		}
+
+		export = ModulePatternExport{
+			Format:       "screamtracker",
+			Title:        st.Title(),
+			SongLength:   len(patternOrder),
+			NumChannels:  st.NumChannels(),
+			PatternOrder: patternOrder,
+			Samples:      samples,
+			Patterns:     patterns,
+			Tempo:        uint16(st.Speed()),
+			BPM:          uint16(st.Tempo()),
+			Channels:     channels,
+		}
+
+	case module.IMPULSETRACKER:
+		it := m.(*module.ImpulseTracker)
+
+		patternOrder := make([]int, len(it.Orders()))
+		for i, patNum := range it.Orders() {
+			patternOrder[i] = int(patNum)
+		}
+
+		// Export samples, with loop points converted to words as for XM
+		samples := make([]SampleExport, 0)
+		for _, sample := range it.Samples() {
+			itSample := sample.(module.ITSample)
+			// IT lengths and loops count frames rather than bytes
+			frameSize := itSample.BytesPerFrame()
+			repeatOffset, repeatLength := loopWords(itSample.LoopStart(), itSample.LoopEnd(), frameSize)
+			sampleExport := SampleExport{
+				Number:       len(samples) + 1,
+				Name:         itSample.Name(),
+				Length:       int(itSample.Length()) * frameSize,
+				Volume:       int(itSample.Volume()),
+				RepeatOffset: repeatOffset,
+				RepeatLength: repeatLength,
+				Data:         base64.StdEncoding.EncodeToString(itSample.Data()),
+			}
+			samples = append(samples, sampleExport)
+		}
+
+		patterns := make([]PatternExport, 0)
+		for patNum, pattern := range it.Patterns() {
+			patterns = append(patterns, exportPattern(patNum, pattern))
+		}
+
+		instruments := make([]ITInstrumentExport, 0)
+		for idx, inst := range it.ITInstruments() {
+			keyboard := make([]ITKeyboardExport, 0)
+			for _, entry := range inst.Keyboard() {
+				keyboard = append(keyboard, ITKeyboardExport{Note: entry.Note, Sample: entry.Sample})
+			}
+			var defaultPan *uint8
+			if pan, enabled := inst.DefaultPan(); enabled {
+				defaultPan = &pan
+			}
+
+			instruments = append(instruments, ITInstrumentExport{
+				Number:               idx + 1,
+				Name:                 inst.Name(),
+				Filename:             inst.Filename(),
+				NNA:                  inst.NNA(),
+				DuplicateCheckType:   inst.DuplicateCheckType(),
+				DuplicateCheckAction: inst.DuplicateCheckAction(),
+				Fadeout:              inst.Fadeout(),
+				PitchPanSeparation:   inst.PitchPanSeparation(),
+				PitchPanCenter:       inst.PitchPanCenter(),
+				GlobalVolume:         inst.GlobalVolume(),
+				DefaultPan:           defaultPan,
+				RandomVolume:         inst.RandomVolume(),
+				RandomPan:            inst.RandomPan(),
+				Keyboard:             keyboard,
+				VolumeEnvelope:       exportITEnvelope(inst.VolumeEnvelope()),
+				PanningEnvelope:      exportITEnvelope(inst.PanningEnvelope()),
+				PitchEnvelope:        exportITEnvelope(inst.PitchEnvelope()),
+			})
+		}
+
+		export = ModulePatternExport{
+			Format:        "impulsetracker",
+			Title:         it.Title(),
+			SongLength:    len(patternOrder),
+			NumChannels:   it.NumChannels(),
+			PatternOrder:  patternOrder,
+			Samples:       samples,
+			Patterns:      patterns,
+			Tempo:         uint16(it.Speed()),
+			BPM:           uint16(it.Tempo()),
+			ITInstruments: instruments,
+			Message:       it.Message(),
+		}
+
 	default:
 		return fmt.Errorf("unsupported module format: %v", m.Type())
 	}
@@ -502,6 +1028,15 @@ func importPatterns(jsonFile string, output string) error {
 		return fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
+	// Files written before the format field was added are MODs
+	switch export.Format {
+	case "fasttracker":
+		return importXM(export, output)
+	case "", "protracker", "soundtracker":
+	default:
+		return fmt.Errorf("unsupported format for import: %s", export.Format)
+	}
+
 	slog.Info("Building MOD file", "title", export.Title)
 
 	// Build the MOD file binary
@@ -524,8 +1059,14 @@ func importPatterns(jsonFile string, output string) error {
 	copy(title, []byte(export.Title))
 	modData = append(modData, title...)
 
-	// 2. Write sample metadata (31 samples × 30 bytes each)
-	for i := 0; i < 31; i++ {
+	// Soundtracker modules only have 15 samples and no magic number
+	numSamples := 31
+	if export.Format == "soundtracker" {
+		numSamples = 15
+	}
+
+	// 2. Write sample metadata (numSamples × 30 bytes each)
+	for i := 0; i < numSamples; i++ {
 		sampleMeta := make([]byte, 30)
 		if i < len(export.Samples) {
 			sample := export.Samples[i]
@@ -556,17 +1097,39 @@ func importPatterns(jsonFile string, output string) error {
 	// 4. Write restart position (1 byte) - legacy, usually 127
 	modData = append(modData, byte(export.RestartPosition))
 
+	// Keep the original magic number if it matches the channel count
+	tag := module.ModTag(export.NumChannels)
+	if len(export.Tag) == 4 && module.ModTagChannels(export.Tag) == export.NumChannels {
+		tag = export.Tag
+	}
+	// Startrekker's FLT8 stores each 8 channel pattern as two 4 channel
+	// patterns, and the order table refers to the first of each pair
+	flt8 := numSamples == 31 && tag == "FLT8"
+
 	// 5. Write pattern order table (128 bytes)
 	patternOrder := make([]byte, 128)
 	for i := 0; i < len(export.PatternOrder) && i < 128; i++ {
 		patternOrder[i] = byte(export.PatternOrder[i])
+		if flt8 {
+			patternOrder[i] *= 2
+		}
 	}
 	modData = append(modData, patternOrder...)
 
-	// 6. Write magic number "M.K." for 4-channel MOD
-	modData = append(modData, []byte("M.K.")...)
+	// 6. Write magic number
+	if numSamples == 31 {
+		modData = append(modData, []byte(tag)...)
+		if strings.HasPrefix(tag, "FA0") {
+			// Digital Tracker's unused bytes after the tag
+			modData = append(modData, 0x00, 0x40, 0x00, 0x00)
+		}
+	}
 
-	// 7. Write pattern data
+	// 7. Write pattern data, one block of channels at a time
+	channelBlocks := [][2]int{{0, export.NumChannels}}
+	if flt8 {
+		channelBlocks = [][2]int{{0, 4}, {4, 8}}
+	}
 	for _, pattern := range export.Patterns {
 		// Each pattern MUST be exactly 64 rows × numChannels × 4 bytes
 		rowMap := make(map[int]PatternExportRow)
@@ -574,34 +1137,36 @@ func importPatterns(jsonFile string, output string) error {
 			rowMap[row.RowNumber] = row
 		}
 
-		for rowIdx := 0; rowIdx < 64; rowIdx++ {
-			row, exists := rowMap[rowIdx]
+		for _, block := range channelBlocks {
+			for rowIdx := 0; rowIdx < 64; rowIdx++ {
+				row, exists := rowMap[rowIdx]
 
-			for chanIdx := 0; chanIdx < export.NumChannels; chanIdx++ {
-				// Encode note as 4 bytes
-				noteBytes := make([]byte, 4)
+				for chanIdx := block[0]; chanIdx < block[1]; chanIdx++ {
+					// Encode note as 4 bytes
+					noteBytes := make([]byte, 4)
 
-				if exists && chanIdx < len(row.Channels) {
-					channel := row.Channels[chanIdx]
+					if exists && chanIdx < len(row.Channels) {
+						channel := row.Channels[chanIdx]
 
-					// Byte 0: upper 4 bits of sample + upper 4 bits of period
-					// Byte 1: lower 8 bits of period
-					// Byte 2: lower 4 bits of sample + effect
-					// Byte 3: effect parameter
+						// Byte 0: upper 4 bits of sample + upper 4 bits of period
+						// Byte 1: lower 8 bits of period
+						// Byte 2: lower 4 bits of sample + effect
+						// Byte 3: effect parameter
 
-					period := uint16(channel.Period)
-					instrument := byte(channel.Instrument)
-					effect := byte(channel.Effect)
-					parameter := byte(channel.Parameter)
+						period := uint16(channel.Period)
+						instrument := byte(channel.Instrument)
+						effect := byte(channel.Effect)
+						parameter := byte(channel.Parameter)
 
-					noteBytes[0] = (instrument & 0xF0) | byte((period>>8)&0x0F)
-					noteBytes[1] = byte(period & 0xFF)
-					noteBytes[2] = ((instrument & 0x0F) << 4) | (effect & 0x0F)
-					noteBytes[3] = parameter
-				}
-				// else: noteBytes remains all zeros (empty note)
+						noteBytes[0] = (instrument & 0xF0) | byte((period>>8)&0x0F)
+						noteBytes[1] = byte(period & 0xFF)
+						noteBytes[2] = ((instrument & 0x0F) << 4) | (effect & 0x0F)
+						noteBytes[3] = parameter
+					}
+					// else: noteBytes remains all zeros (empty note)
 
-				modData = append(modData, noteBytes...)
+					modData = append(modData, noteBytes...)
+				}
 			}
 		}
 	}
@@ -622,11 +1187,178 @@ func importPatterns(jsonFile string, output string) error {
 	return nil
 }
 
+// importXM rebuilds a FastTracker XM file from its JSON representation
+func importXM(export ModulePatternExport, output string) error {
+	slog.Info("Building XM file", "title", export.Title)
+
+	ft := module.NewFastTracker(export.NumChannels)
+	title, err := importRawName(export.Title, export.TitleBytes)
+	if err != nil {
+		return fmt.Errorf("failed to decode title: %w", err)
+	}
+	ft.SetTitle(title)
+	author, err := importRawName(export.Author, export.AuthorBytes)
+	if err != nil {
+		return fmt.Errorf("failed to decode author: %w", err)
+	}
+	ft.SetTrackerName(author)
+	ft.SetVersion(export.Version)
+	if export.HeaderSize != 0 {
+		ft.SetHeaderSize(export.HeaderSize)
+	}
+	headerExtra, err := base64.StdEncoding.DecodeString(export.HeaderExtra)
+	if err != nil {
+		return fmt.Errorf("failed to decode header: %w", err)
+	}
+	ft.SetHeaderExtra(headerExtra)
+	ft.SetRestartPosition(uint16(export.RestartPosition))
+	ft.SetFlags(export.Flags)
+	ft.SetTempo(export.Tempo)
+	ft.SetBPM(export.BPM)
+
+	orders := make([]byte, len(export.PatternOrder))
+	for i, patNum := range export.PatternOrder {
+		orders[i] = byte(patNum)
+	}
+	if err := ft.SetOrderTable(orders); err != nil {
+		return err
+	}
+	if export.OrderTable != "" {
+		orderTable, err := base64.StdEncoding.DecodeString(export.OrderTable)
+		if err != nil {
+			return fmt.Errorf("failed to decode order table: %w", err)
+		}
+		if err := ft.SetOrderTable(orderTable); err != nil {
+			return err
+		}
+		if err := ft.SetPatternSize(uint16(len(orders))); err != nil {
+			return err
+		}
+	}
+
+	for _, pattern := range export.Patterns {
+		// Missing rows are left empty
+		p := module.NewPattern(export.NumChannels, pattern.NumRows)
+		p.SetHeaderLength(pattern.HeaderLength)
+		patternExtra, err := base64.StdEncoding.DecodeString(pattern.HeaderExtra)
+		if err != nil {
+			return fmt.Errorf("failed to decode pattern %d header: %w", pattern.PatternNumber, err)
+		}
+		p.SetHeaderExtra(patternExtra)
+		for _, row := range pattern.Rows {
+			notes := make([]module.Note, export.NumChannels)
+			for chanIdx := 0; chanIdx < export.NumChannels && chanIdx < len(row.Channels); chanIdx++ {
+				channel := row.Channels[chanIdx]
+				volume := 0
+				if channel.Volume != nil {
+					volume = *channel.Volume
+				}
+				notes[chanIdx] = module.NewXMNote(channel.Key, channel.Instrument, volume, channel.Effect, channel.Parameter)
+			}
+			if err := p.SetRow(row.RowNumber, module.NewRow(notes)); err != nil {
+				return fmt.Errorf("pattern %d: %w", pattern.PatternNumber, err)
+			}
+		}
+		if err := ft.AddPattern(p); err != nil {
+			return fmt.Errorf("pattern %d: %w", pattern.PatternNumber, err)
+		}
+	}
+
+	for _, inst := range export.Instruments {
+		name, err := importRawName(inst.Name, inst.NameBytes)
+		if err != nil {
+			return fmt.Errorf("failed to decode instrument %d name: %w", inst.Number, err)
+		}
+		instrument := module.NewFTInstrument(name)
+		instrument.SetType(inst.Type)
+		instrument.SetSampleHeaderSize(inst.SampleHeaderSize)
+		if len(inst.Keymap) == 96 {
+			var keymap [96]uint8
+			for i, sampleNum := range inst.Keymap {
+				keymap[i] = uint8(sampleNum)
+			}
+			instrument.SetKeymap(keymap)
+		}
+		instrument.SetVolumeEnvelope(importEnvelope(inst.VolumeEnvelope))
+		instrument.SetPanningEnvelope(importEnvelope(inst.PanningEnvelope))
+		instrument.SetVibrato(module.FTVibrato{
+			Type:  inst.Vibrato.Type,
+			Sweep: inst.Vibrato.Sweep,
+			Depth: inst.Vibrato.Depth,
+			Rate:  inst.Vibrato.Rate,
+		})
+		instrument.SetFadeout(inst.Fadeout)
+		extra, err := base64.StdEncoding.DecodeString(inst.HeaderExtra)
+		if err != nil {
+			return fmt.Errorf("failed to decode instrument %d header: %w", inst.Number, err)
+		}
+		instrument.SetHeaderExtra(extra)
+
+		for _, sample := range inst.Samples {
+			sampleData, err := base64.StdEncoding.DecodeString(sample.Data)
+			if err != nil {
+				return fmt.Errorf("failed to decode instrument %d sample %d data: %w", inst.Number, sample.Number, err)
+			}
+			sampleName, err := importRawName(sample.Name, sample.NameBytes)
+			if err != nil {
+				return fmt.Errorf("failed to decode instrument %d sample %d name: %w", inst.Number, sample.Number, err)
+			}
+			s := module.NewFTSample(sampleName, sampleData)
+			s.SetLoop(sample.LoopStart, sample.LoopEnd)
+			s.SetVolume(sample.Volume)
+			s.SetFinetune(sample.Finetune)
+			s.SetSampleType(sample.SampleType)
+			s.SetPanning(sample.Panning)
+			s.SetRelativeNote(sample.RelativeNote)
+			s.SetDataType(sample.DataType)
+			sampleExtra, err := base64.StdEncoding.DecodeString(sample.HeaderExtra)
+			if err != nil {
+				return fmt.Errorf("failed to decode instrument %d sample %d header: %w", inst.Number, sample.Number, err)
+			}
+			s.SetHeaderExtra(sampleExtra)
+			instrument.AddSample(s)
+		}
+		ft.AddInstrument(instrument)
+	}
+
+	xmData, err := ft.Save()
+	if err != nil {
+		return fmt.Errorf("failed to encode XM: %w", err)
+	}
+
+	if err := os.WriteFile(output, xmData, 0644); err != nil {
+		return fmt.Errorf("failed to write XM file: %w", err)
+	}
+
+	slog.Info("XM file created", "output", output, "size", len(xmData))
+	return nil
+}
+
+// importEnvelope converts an envelope from its JSON representation
+func importEnvelope(envelope EnvelopeExport) module.FTEnvelope {
+	points := make([]module.FTEnvelopePoint, len(envelope.Points))
+	for i, point := range envelope.Points {
+		points[i] = module.FTEnvelopePoint{Tick: point.Tick, Value: point.Value}
+	}
+	unused := make([]module.FTEnvelopePoint, len(envelope.UnusedPoints))
+	for i, point := range envelope.UnusedPoints {
+		unused[i] = module.FTEnvelopePoint{Tick: point.Tick, Value: point.Value}
+	}
+	return module.FTEnvelope{
+		Points:    points,
+		Unused:    unused,
+		Sustain:   envelope.Sustain,
+		LoopStart: envelope.LoopStart,
+		LoopEnd:   envelope.LoopEnd,
+		Flags:     envelope.Flags,
+	}
+}
+
 func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "go-mod",
 		Short: "A tool for working with MOD music files",
-		Long:  "go-mod provides utilities for analyzing and extracting data from ProTracker, FastTracker, and other MOD format music files.",
+		Long:  "go-mod provides utilities for analyzing and extracting data from ProTracker, FastTracker, and other MOD format music files. Modules may be gzip, bzip2 or MMCMP compressed or inside an Unreal package, and modules inside a zip, tar or LHA archive can be addressed as pack.zip#path/in/zip.xm.",
 	}
 
 	// Info command
@@ -670,9 +1402,9 @@ func main() {
 
 	// Import patterns command
 	var importPatternsCmd = &cobra.Command{
-		Use:   "import-patterns [json-file] [output-mod]",
-		Short: "Recreate a MOD file from JSON format",
-		Long:  "Import pattern and sample data from JSON and recreate the original MOD file.",
+		Use:   "import-patterns [json-file] [output-file]",
+		Short: "Recreate a MOD or XM file from JSON format",
+		Long:  "Import pattern and sample data from JSON and recreate the original MOD or XM file, depending on the JSON format field.",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return importPatterns(args[0], args[1])
@@ -683,7 +1415,7 @@ func main() {
 	var dbCmd = &cobra.Command{
 		Use:   "create-sample-db [path]",
 		Short: "Create sample database from MOD file(s)",
-		Long:  "Scan a MOD file or directory of MOD files and store sample information in a database.",
+		Long:  "Scan a MOD file or directory of MOD files and store sample information in a database. The database needs the tables in schema.sql; tracker columns missing from older databases are added.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dbHost, _ := cmd.Flags().GetString("db-host")
@@ -710,7 +1442,29 @@ func main() {
 	dbCmd.MarkFlagRequired("db-name")
 	dbCmd.MarkFlagRequired("db-user")
 
-	rootCmd.AddCommand(infoCmd, dumpCmd, dumpPatternsCmd, importPatternsCmd, dbCmd)
+	// Decrunch command
+	var decrunchCmd = &cobra.Command{
+		Use:   "decrunch [file] [output-file]",
+		Short: "Unpack a PowerPacker crunched file",
+		Long:  "Unpack a PowerPacker (PP20) crunched file, as found on Amiga disks, and write out the plain file. Crunched modules are also unpacked automatically by the other commands.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return decrunch(args[0], args[1])
+		},
+	}
+
+	// Extract command
+	var extractCmd = &cobra.Command{
+		Use:   "extract [file] [output-file]",
+		Short: "Extract the module from an Unreal package",
+		Long:  "Extract the IT, S3M, XM or MOD file embedded in an Unreal package (.umx), as found in Unreal, Unreal Tournament and Deus Ex, and write it out as it's stored. Packages are also loaded automatically by the other commands.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return extract(args[0], args[1])
+		},
+	}
+
+	rootCmd.AddCommand(infoCmd, dumpCmd, dumpPatternsCmd, importPatternsCmd, dbCmd, decrunchCmd, extractCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		slog.Error("Command failed", "error", err)
@@ -0,0 +1,341 @@
+package module
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrMultipleModules is returned when loading a single module from an archive
+// that holds several. Address one with a path like "pack.zip#song.xm".
+var ErrMultipleModules = errors.New("archive holds more than one module")
+
+// ArchiveEntry is a module found in an archive. Path is the entry's path
+// inside the archive, or empty if the file wasn't an archive. Err holds any
+// error loading the module.
+type ArchiveEntry struct {
+	Path string
+	Module Module
+	Err error
+}
+
+// LoadArchive loads every module in a zip, tar or LHA archive, skipping
+// entries that aren't modules. Archives and modules may also be gzip or bzip2
+// compressed. A plain module file gives a single entry.
+func LoadArchive(path string) ([]ArchiveEntry, error) {
+	return DefaultLoadOptions().LoadArchive(path)
+}
+
+// LoadArchive loads every module in a zip, skipping entries that aren't modules
+func (o LoadOptions) LoadArchive(path string) ([]ArchiveEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return o.loadEntries(f, info.Size(), path, "", false)
+}
+
+// SplitArchivePath splits a path like "pack.zip#path/in/zip.xm" into the
+// archive and the member inside it. A path that exists as it is, or has no
+// '#' following an existing file, is returned with an empty member.
+func SplitArchivePath(path string) (string, string) {
+	if _, err := os.Stat(path); err == nil {
+		return path, ""
+	}
+	for i := 0; i < len(path); i++ {
+		if (path[i] != '#') {
+			continue
+		}
+		if info, err := os.Stat(path[:i]); err == nil && !info.IsDir() {
+			return path[:i], path[i+1:]
+		}
+	}
+	return path, ""
+}
+
+// ReadFile reads a file, unpacking it if it's gzip or bzip2 compressed. A
+// member of an archive can be read with a path like "pack.zip#song.xm".
+func ReadFile(path string) ([]byte, error) {
+	return DefaultLoadOptions().ReadFile(path)
+}
+
+// ReadFile reads a file, or a member of an archive, unpacking it if it's
+// gzip or bzip2 compressed
+func (o LoadOptions) ReadFile(path string) ([]byte, error) {
+	o = o.withDefaults()
+	file, member := SplitArchivePath(path)
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	members, data, err := o.readMembers(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if (member == "" && members != nil) {
+		return nil, errors.New(fmt.Sprintf("%s is an archive; read a member as %s#name", file, file))
+	}
+	if (member == "") {
+		return data, nil
+	}
+	if (members == nil) {
+		return nil, errors.New(fmt.Sprintf("%s is not an archive", file))
+	}
+	for _, m := range members {
+		if (m.name == member && !m.dir) {
+			data, err := m.read()
+			if err != nil {
+				return nil, err
+			}
+			return decompress(data, o)
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+}
+
+// archiveMember is a file inside an archive, read on demand
+type archiveMember struct {
+	name string
+	dir bool
+	read func() ([]byte, error)
+}
+
+// loadEntries loads every module in r, which may be an archive or a single
+// module, possibly gzip or bzip2 compressed, as may be the archive's members.
+// If member isn't empty only that entry is loaded, and it's an error if it
+// doesn't exist or isn't a module. If single is set it stops at the second
+// module, as that's enough to know there's more than one. Entries share a
+// single MaxSize between them.
+func (o LoadOptions) loadEntries(r io.ReaderAt, size int64, name string, member string, single bool) ([]ArchiveEntry, error) {
+	o = o.withDefaults()
+	members, data, err := o.readMembers(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if (members == nil) {
+		if (member != "") {
+			return nil, errors.New(fmt.Sprintf("%s is not an archive", name))
+		}
+		m, err := loadData(data, name, o)
+		if errors.Is(err, ErrUnknownFormat) {
+			return nil, err
+		}
+		return []ArchiveEntry{{Module: m, Err: err}}, nil
+	}
+
+	if err := checkLimit("MaxEntries", int64(len(members)), int64(o.MaxEntries)); err != nil {
+		return nil, err
+	}
+	entries := make([]ArchiveEntry, 0)
+	total := int64(0)
+	for _, file := range members {
+		if (single && len(entries) > 1) {
+			break
+		}
+		if (file.dir || (member != "" && file.name != member)) {
+			continue
+		}
+		// members can be compressed too, like a .mod.gz in a zip
+		data, err := file.read()
+		if (err == nil) {
+			data, err = decompress(data, o)
+		}
+		if errors.Is(err, ErrLimitExceeded) || (err != nil && member != "") {
+			return nil, err
+		}
+		if err != nil {
+			// it may not be a module, but there's no telling without its data
+			entries = append(entries, ArchiveEntry{Path: file.name, Err: err})
+			continue
+		}
+		// each entry is checked against MaxSize as it's read, but an archive
+		// can hold a great many of them
+		total += int64(len(data))
+		if err := checkLimit("MaxSize", total, o.MaxSize); err != nil {
+			return nil, err
+		}
+		m, err := loadData(data, memberPath(name, file.name), o)
+		if errors.Is(err, ErrUnknownFormat) && member == "" {
+			// readmes, .diz files and the like
+			continue
+		}
+		if (member != "" && m == nil) {
+			return nil, err
+		}
+		entries = append(entries, ArchiveEntry{Path: file.name, Module: m, Err: err})
+	}
+	if (member != "" && len(entries) == 0) {
+		return nil, fmt.Errorf("%s: %w", memberPath(name, member), fs.ErrNotExist)
+	}
+	return entries, nil
+}
+
+// readMembers lists the files in r if it's an archive. Otherwise members is
+// nil and data holds the file, unpacked if it was gzip or bzip2 compressed.
+func (o LoadOptions) readMembers(r io.ReaderAt, size int64) ([]archiveMember, []byte, error) {
+	if err := checkLimit("MaxCompressedSize", size, o.MaxCompressedSize); err != nil {
+		return nil, nil, err
+	}
+	if zr, err := zip.NewReader(r, size); err == nil {
+		return zipMembers(zr, o), nil, nil
+	}
+	data, err := readAllLimited(io.NewSectionReader(r, 0, size), "MaxSize", o.MaxSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err = decompress(data, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := archiveMembers(data, o)
+	return members, data, err
+}
+
+// decompress unwraps gzip and bzip2 compressed data, returning anything
+// else as it is
+func decompress(data []byte, options LoadOptions) ([]byte, error) {
+	// a .tar.gz.gz is odd but harmless; any deeper is more likely a bomb
+	for depth := 0; depth < 4; depth++ {
+		var r io.Reader
+		switch {
+		case isGzip(data):
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			r = zr
+		case isBzip2(data):
+			r = bzip2.NewReader(bytes.NewReader(data))
+		default:
+			return data, nil
+		}
+		var err error
+		data, err = readAllLimited(r, "MaxSize", options.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func isGzip(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0x1f && data[1] == 0x8b && data[2] == 8
+}
+
+func isBzip2(data []byte) bool {
+	// "BZh" and the block size, then the magic of the first block or of the
+	// end of the stream
+	if (len(data) < 10 || string(data[0:3]) != "BZh" || data[3] < '1' || data[3] > '9') {
+		return false
+	}
+	magic := string(data[4:10])
+	return magic == "\x31\x41\x59\x26\x53\x59" || magic == "\x17\x72\x45\x38\x50\x90"
+}
+
+func isTar(data []byte) bool {
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// archiveMembers lists the files in a zip, tar or LHA archive already in
+// memory, or returns nil if data isn't one
+func archiveMembers(data []byte, options LoadOptions) ([]archiveMember, error) {
+	switch {
+	case isTar(data):
+		return tarMembers(data, options)
+	case isLHA(data):
+		return lhaMembers(data, options)
+	}
+	if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		return zipMembers(zr, options), nil
+	}
+	return nil, nil
+}
+
+func zipMembers(zr *zip.Reader, options LoadOptions) []archiveMember {
+	members := make([]archiveMember, len(zr.File))
+	for i, file := range zr.File {
+		file := file
+		members[i] = archiveMember{
+			name: file.Name,
+			dir: file.FileInfo().IsDir(),
+			read: func() ([]byte, error) { return readZipEntry(file, options.MaxSize) },
+		}
+	}
+	return members
+}
+
+// tarMembers reads every regular file and directory in a tar. A tar can only
+// be read in order, so the data is read up front.
+func tarMembers(data []byte, options LoadOptions) ([]archiveMember, error) {
+	tr := tar.NewReader(bytes.NewReader(data))
+	members := make([]archiveMember, 0)
+	for {
+		header, err := tr.Next()
+		if (err == io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		mode := header.FileInfo().Mode()
+		if (mode.IsDir()) {
+			members = append(members, archiveMember{name: header.Name, dir: true})
+			continue
+		}
+		if (!mode.IsRegular()) {
+			// links and devices
+			continue
+		}
+		if err := checkLimit("MaxSize", header.Size, options.MaxSize); err != nil {
+			return nil, err
+		}
+		contents, err := readAllLimited(tr, "MaxSize", options.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, archiveMember{
+			name: header.Name,
+			read: func() ([]byte, error) { return contents, nil },
+		})
+	}
+	return members, nil
+}
+
+// readZipEntry reads a zip entry, checking its size both before and while reading
+func readZipEntry(file *zip.File, max int64) ([]byte, error) {
+	if err := checkLimit("MaxSize", int64(file.UncompressedSize64), max); err != nil {
+		return nil, err
+	}
+	fc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fc.Close()
+	return readAllLimited(fc, "MaxSize", max)
+}
+
+// memberPath gives the path that addresses member within archive
+func memberPath(archive string, member string) string {
+	if (archive == "") {
+		return member
+	}
+	return archive + "#" + strings.TrimPrefix(member, "/")
+}
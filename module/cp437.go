@@ -0,0 +1,33 @@
+package module
+
+import "strings"
+
+// cp437High maps the upper half of code page 437, as used by DOS trackers, to Unicode
+var cp437High = []rune(
+	"ÇüéâäàåçêëèïîìÄÅ" +
+	"ÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+	"áíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+	"└┴┬├─┼╞╟╚╔╩╦╠═╬╧" +
+	"╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩" +
+	"≡±≥≤⌠⌡÷≈°∙·√ⁿ²■ ")
+
+// decodeCP437 converts CP437 text to a string, stopping at the first null and
+// turning CR line endings into newlines
+func decodeCP437(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		switch {
+		case b == 0:
+			return sb.String()
+		case b == '\r':
+			sb.WriteByte('\n')
+		case b >= 0x80:
+			sb.WriteRune(cp437High[b - 0x80])
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,62 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTruncated is wrapped by a FormatError when a field runs past the end of the data
+var ErrTruncated = errors.New("unexpected end of data")
+
+// ErrInvalidValue is wrapped by a FormatError when a field holds a value that can't be loaded
+var ErrInvalidValue = errors.New("invalid value")
+
+// FormatError describes a problem with one field of a module. Field names
+// follow the loader's own naming, e.g. "sample[3].data".
+type FormatError struct {
+	Format string
+	Field string
+	Offset int
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d: %v", e.Format, e.Field, e.Offset, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// reader does bounds-checked reads of module data, reporting problems as
+// FormatErrors for its format
+type reader struct {
+	format string
+	data []byte
+}
+
+// check makes sure length bytes are available at offset
+func (r reader) check(field string, offset int, length int) error {
+	if (offset < 0 || length < 0 || offset > len(r.data) || length > len(r.data) - offset) {
+		return &FormatError{Format: r.format, Field: field, Offset: offset, Err: ErrTruncated}
+	}
+	return nil
+}
+
+// slice returns the length bytes at offset
+func (r reader) slice(field string, offset int, length int) ([]byte, error) {
+	if err := r.check(field, offset, length); err != nil {
+		return nil, err
+	}
+	return r.data[offset:offset+length], nil
+}
+
+// invalid reports a field whose value can't be loaded
+func (r reader) invalid(field string, offset int, value interface{}) error {
+	return &FormatError{Format: r.format, Field: field, Offset: offset, Err: fmt.Errorf("%w %v", ErrInvalidValue, value)}
+}
+
+// wrap reports err as a problem with a field
+func (r reader) wrap(field string, offset int, err error) error {
+	return &FormatError{Format: r.format, Field: field, Offset: offset, Err: err}
+}
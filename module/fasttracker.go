@@ -1,86 +1,238 @@
 package module
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
 type FastTracker struct {
 	title string
-	author string
+	trackerName string
 	version uint16
+	headerSize uint32
+	headerExtra []byte
 	patternSize uint16
 	restartPos uint16
 	flags uint16
 	tempo uint16
 	bpm uint16
+	numChannels uint16
 	orderTable []byte
 	instruments []FTInstrument
 	patterns []Pattern
+	loadState
 	Module
 }
 
+// NewFastTracker creates an empty XM with FastTracker's default header values
+func NewFastTracker(numChannels int) *FastTracker {
+	return &FastTracker{
+		version: 0x0104,
+		headerSize: 276,
+		tempo: 6,
+		bpm: 125,
+		numChannels: uint16(numChannels),
+		orderTable: make([]byte, 256),
+	}
+}
+
+func init() {
+	Register(Format{
+		Name: "fasttracker",
+		Extensions: []string{".xm"},
+		Detect: detectFastTracker,
+		New: func() Module { return &FastTracker{} },
+	})
+}
+
+// detectFastTracker looks for the "Extended Module: " signature and the 0x1A
+// byte that follows the title
+func detectFastTracker(data []byte) int {
+	if (len(data) < 64 || string(data[0:17]) != "Extended Module: ") {
+		return SCORE_NONE
+	}
+	if (data[37] != 0x1a) {
+		return SCORE_STRONG
+	}
+	return SCORE_CERTAIN
+}
+
 func (m *FastTracker) Type() FileFormat {
 	return FASTTRACKER
 }
 
 func (m *FastTracker) Load(data []byte) (error) {
-	m.title = filterNulls(string(data[17:37]))
-	m.author = filterNulls(string(data[38:58]))
+	r := reader{format: "fasttracker", data: data}
+	limits := m.limits()
+	if err := r.check("header", 0, 80); err != nil {
+		return err
+	}
+	// names are kept as stored, with anything after the first null, so they
+	// save back unchanged
+	m.title = string(data[17:37])
+	m.trackerName = string(data[38:58])
 	m.version = binary.LittleEndian.Uint16(data[58:60])
-	//headerSize := binary.LittleEndian.Uint32(data[60:64])
+	m.headerSize = binary.LittleEndian.Uint32(data[60:64])
 	m.patternSize = binary.LittleEndian.Uint16(data[64:66])
 	m.restartPos = binary.LittleEndian.Uint16(data[66:68])
-	//numChannels := binary.LittleEndian.Uint16(data[68:70])
+	m.numChannels = binary.LittleEndian.Uint16(data[68:70])
 	numPatterns := binary.LittleEndian.Uint16(data[70:72])
 	numInstruments := binary.LittleEndian.Uint16(data[72:74])
 	m.flags = binary.LittleEndian.Uint16(data[74:76])
 	m.tempo = binary.LittleEndian.Uint16(data[76:78])
 	m.bpm = binary.LittleEndian.Uint16(data[78:80])
-	m.orderTable = data[80:336]
+	orderTable, err := r.slice("orderTable", 80, 256)
+	if err != nil {
+		return err
+	}
+	m.orderTable = orderTable
+	// Keep anything past the order table that pads the header out to its size
+	if (60 + int(m.headerSize) > 336) {
+		headerExtra, err := r.slice("header", 336, 60 + int(m.headerSize) - 336)
+		if err != nil {
+			return err
+		}
+		m.headerExtra = headerExtra
+	}
+
+	if (m.numChannels == 0 || m.numChannels > 127) {
+		return r.invalid("numChannels", 68, m.numChannels)
+	}
+	if err := checkLimit("MaxChannels", int64(m.numChannels), int64(limits.MaxChannels)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxPatterns", int64(numPatterns), int64(limits.MaxPatterns)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxSamples", int64(numInstruments), int64(limits.MaxSamples)); err != nil {
+		return err
+	}
 
-	offset := 336
+	// The header size is counted from its own field at offset 60
+	offset := 60 + int(m.headerSize)
+	m.patterns = make([]Pattern, 0, numPatterns)
 	for i := 0; i < int(numPatterns); i++ {
-		//hdrLength := binary.LittleEndian.Uint32(data[offset:offset+4])
-		offset += 5		// skip packing type
-		//numPatternRows := binary.LittleEndian.Uint16(data[offset:offset+2])
-		offset += 2
-		patternDataSize := binary.LittleEndian.Uint16(data[offset:offset+2])
-		offset += 2
-		offset += int(patternDataSize)
+		if err := r.check(fmt.Sprintf("pattern[%d].header", i), offset, 9); err != nil {
+			return err
+		}
+		hdrLength := int(binary.LittleEndian.Uint32(data[offset:offset+4]))
+		// skip packing type, always 0
+		numRows := int(binary.LittleEndian.Uint16(data[offset+5:offset+7]))
+		packedSize := int(binary.LittleEndian.Uint16(data[offset+7:offset+9]))
+
+		if (numRows < 1 || numRows > 256) {
+			return r.invalid(fmt.Sprintf("pattern[%d].numRows", i), offset+5, numRows)
+		}
+		// Keep whatever pads the header out past its 9 bytes
+		var headerExtra []byte
+		if (hdrLength > 9) {
+			extra, err := r.slice(fmt.Sprintf("pattern[%d].header", i), offset + 9, hdrLength - 9)
+			if err != nil {
+				return err
+			}
+			headerExtra = extra
+		}
+		offset += hdrLength
+		field := fmt.Sprintf("pattern[%d].data", i)
+		packed, err := r.slice(field, offset, packedSize)
+		if err != nil {
+			return err
+		}
+
+		pattern, err := m.loadPattern(packed, numRows)
+		if err != nil {
+			return r.wrap(field, offset, err)
+		}
+		pattern.headerLength = uint32(hdrLength)
+		pattern.headerExtra = headerExtra
+		m.patterns = append(m.patterns, pattern)
+		offset += packedSize
 	}
 
+	totalSamples := 0
 	for i := 0; i < int(numInstruments); i++ {
 
 		instrument := FTInstrument{}
 
 		instOffset := offset
+		field := fmt.Sprintf("instrument[%d].header", i)
+		if err := r.check(field, instOffset, 29); err != nil {
+			return err
+		}
 		instHeaderSize := binary.LittleEndian.Uint32(data[instOffset:instOffset+4])
 		instOffset += 4
 		instrument.name = string(data[instOffset:instOffset+22])
-		instOffset += 23	// skip type
+		instOffset += 22
+		instrument.instType = data[instOffset]
+		instOffset += 1
 		instNumSamples :=  binary.LittleEndian.Uint16(data[instOffset:instOffset+2])
 		instOffset += 2
 
-		sampleSizes := make([]int, 0)
-		for j := 0; j < int(instNumSamples); j++ {
-			sampleHeaderSize := binary.LittleEndian.Uint32(data[instOffset:instOffset+4])
-			sampleSizes = append(sampleSizes, int(sampleHeaderSize))
-			instOffset += 96	// skip keymap assignments
-			instOffset += 48	// skip volume envelope points
-			instOffset += 48	// skip panning envelope points
+		// The extended header is only present for instruments with samples
+		if (instNumSamples > 0 && instHeaderSize >= 241) {
+			if err := r.check(field, offset, 241); err != nil {
+				return err
+			}
+			instrument.sampleHeaderSize = binary.LittleEndian.Uint32(data[instOffset:instOffset+4])
+			instOffset += 4
+			for j := 0; j < 96; j++ {
+				instrument.keymap[j] = data[instOffset+j]
+			}
+			instOffset += 96
+			volumePoints := data[instOffset:instOffset+48]
+			instOffset += 48
+			panningPoints := data[instOffset:instOffset+48]
+			instOffset += 48
+
+			numVolumePoints := data[instOffset]
+			numPanningPoints := data[instOffset+1]
+			instrument.volumeEnvelope = loadFTEnvelope(volumePoints, numVolumePoints,
+				data[instOffset+2], data[instOffset+3], data[instOffset+4], data[instOffset+8])
+			instrument.panningEnvelope = loadFTEnvelope(panningPoints, numPanningPoints,
+				data[instOffset+5], data[instOffset+6], data[instOffset+7], data[instOffset+9])
+			instOffset += 10
+
+			instrument.vibrato = FTVibrato{
+				Type: data[instOffset],
+				Sweep: data[instOffset+1],
+				Depth: data[instOffset+2],
+				Rate: data[instOffset+3],
+			}
+			instOffset += 4
+			instrument.fadeout = binary.LittleEndian.Uint16(data[instOffset:instOffset+2])
+			instOffset += 2
 		}
 
-		instOffset += 38
+		// Keep whatever reserved bytes pad the header out to its stated size
+		if (offset + int(instHeaderSize) > instOffset) {
+			headerExtra, err := r.slice(field, instOffset, offset + int(instHeaderSize) - instOffset)
+			if err != nil {
+				return err
+			}
+			instrument.headerExtra = headerExtra
+		}
 
 		offset += int(instHeaderSize)
 
 		// read sample datas
+		totalSamples += int(instNumSamples)
+		if err := checkLimit("MaxSamples", int64(totalSamples), int64(limits.MaxSamples)); err != nil {
+			return err
+		}
+		// Sample headers are 40 bytes, but step through them by their stated size
+		sampleHeaderSize := 40
+		if (instrument.sampleHeaderSize > 40) {
+			sampleHeaderSize = int(instrument.sampleHeaderSize)
+		}
 		for j := 0; j < int(instNumSamples); j++ {
 			sample := FTSample{}
 
 			sampleOffset := offset
+			field := fmt.Sprintf("instrument[%d].sample[%d].header", i, j)
+			if err := r.check(field, sampleOffset, sampleHeaderSize); err != nil {
+				return err
+			}
 			sample.length = binary.LittleEndian.Uint32(data[sampleOffset : sampleOffset+4])
 			sampleOffset += 4
 			sample.loopStart = binary.LittleEndian.Uint32(data[sampleOffset : sampleOffset+4])
@@ -101,22 +253,25 @@ func (m *FastTracker) Load(data []byte) (error) {
 			sampleOffset += 1
 			sample.name = string(data[sampleOffset : sampleOffset+22])
 			sampleOffset += 22
+			if (sampleHeaderSize > 40) {
+				sample.headerExtra = data[sampleOffset : offset+sampleHeaderSize]
+			}
 
 			instrument.samples = append(instrument.samples, sample)
-			offset = sampleOffset
+			offset += sampleHeaderSize
 		}
 
 		for j := 0; j < int(instNumSamples); j++ {
-			sampleOffset := offset
+			codedSampleData, err := r.slice(fmt.Sprintf("instrument[%d].sample[%d].data", i, j), offset, int(instrument.samples[j].length))
+			if err != nil {
+				return err
+			}
 			if ((1 << 4) & instrument.samples[j].sampleType) == 0 {
-				codedSampleData := data[sampleOffset:sampleOffset+int(instrument.samples[j].length)]
 				instrument.samples[j].data = decode8Bit(codedSampleData)
-				offset += int(instrument.samples[j].length)
 			} else {
-				codedSampleData := data[sampleOffset:sampleOffset+int(instrument.samples[j].length)]
-				instrument.samples[j].data = decode16Bit(codedSampleData, instrument.samples[j].length)
-				offset += int(instrument.samples[j].length)
+				instrument.samples[j].data = decode16Bit(codedSampleData)
 			}
+			offset += int(instrument.samples[j].length)
 		}
 
 		m.instruments = append(m.instruments, instrument)
@@ -126,6 +281,53 @@ func (m *FastTracker) Load(data []byte) (error) {
 
 }
 
+// loadFTEnvelope decodes an XM envelope from its 48 byte block of 12 (tick, value) points.
+// The slots past the used points are kept too, as FastTracker leaves old points in them.
+func loadFTEnvelope(data []byte, numPoints uint8, sustain uint8, loopStart uint8, loopEnd uint8, flags uint8) FTEnvelope {
+	if (numPoints > 12) {
+		numPoints = 12
+	}
+	points := make([]FTEnvelopePoint, 12)
+	for i := range points {
+		points[i] = FTEnvelopePoint{
+			Tick: binary.LittleEndian.Uint16(data[i*4:i*4+2]),
+			Value: binary.LittleEndian.Uint16(data[i*4+2:i*4+4]),
+		}
+	}
+	return FTEnvelope{
+		Sustain: sustain,
+		LoopStart: loopStart,
+		LoopEnd: loopEnd,
+		Flags: flags,
+		Points: points[:numPoints],
+		Unused: points[numPoints:],
+	}
+}
+
+// loadPattern decodes packed XM pattern data. A pattern with no packed data is
+// stored by FastTracker as completely empty, so just fill it with blank rows.
+func (m *FastTracker) loadPattern(data []byte, numRows int) (Pattern, error) {
+	pattern := Pattern{rows:make([]Row, numRows), numChannels:int8(m.numChannels)}
+	pos := 0
+	for j := 0; j < numRows; j++ {
+		row := Row{notes:make([]Note, m.numChannels)}
+		for k := 0; k < pattern.NumChannels() && len(data) > 0; k++ {
+			if (pos >= len(data)) {
+				return pattern, errors.New(fmt.Sprintf("ran out of data at row %d, channel %d", j, k))
+			}
+			n := Note{}
+			size, err := n.LoadXM(data[pos:])
+			if err != nil {
+				return pattern, err
+			}
+			row.notes[k] = n
+			pos += size
+		}
+		pattern.SetRow(j, row)
+	}
+	return pattern, nil
+}
+
 func decode8Bit(data []byte) []byte {
 
 	r := make([]byte, len(data))
@@ -137,20 +339,40 @@ func decode8Bit(data []byte) []byte {
 	return r
 }
 
-func decode16Bit(data []byte, sampleLength uint32) []byte {
+func decode16Bit(data []byte) []byte {
 	r := make([]byte, len(data))
 	old := int16(0)
-	for _, v := range data {
-		old += int16(v)
+	for i := 0; i+1 < len(data); i += 2 {
+		old += int16(binary.LittleEndian.Uint16(data[i:i+2]))
+		binary.LittleEndian.PutUint16(r[i:i+2], uint16(old))
+	}
+	// an odd trailing byte isn't part of any sample value, so keep it as-is
+	if len(data) % 2 != 0 {
+		r[len(r)-1] = data[len(data)-1]
+	}
+	return r
+}
 
-		buf := new(bytes.Buffer)
-		err := binary.Write(buf, binary.LittleEndian, old)
-		if err != nil {
-			fmt.Println("binary.Write failed:", err)
-		}
+func encode8Bit(data []byte) []byte {
+	r := make([]byte, len(data))
+	old := int8(0)
+	for i, v := range data {
+		r[i] = byte(int8(v) - old)
+		old = int8(v)
+	}
+	return r
+}
 
-		r = append(r, buf.Bytes()...)
-		//r[i] = byte(old)
+func encode16Bit(data []byte) []byte {
+	r := make([]byte, len(data))
+	old := int16(0)
+	for i := 0; i+1 < len(data); i += 2 {
+		v := int16(binary.LittleEndian.Uint16(data[i:i+2]))
+		binary.LittleEndian.PutUint16(r[i:i+2], uint16(v - old))
+		old = v
+	}
+	if len(data) % 2 != 0 {
+		r[len(r)-1] = data[len(data)-1]
 	}
 	return r
 }
@@ -160,7 +382,17 @@ func (m *FastTracker) Play() {
 }
 
 func (m *FastTracker) Title() (string) {
-	return m.title
+	return filterNulls(m.title)
+}
+
+// RawTitle returns the 20 byte title field as stored
+func (m *FastTracker) RawTitle() []byte {
+	return padString(m.title, 20)
+}
+
+// Filename returns the name the module was loaded from, if any
+func (m *FastTracker) Filename() string {
+	return m.filename
 }
 
 func (m *FastTracker) Instruments() []Instrument {
@@ -185,14 +417,57 @@ func (m *FastTracker) NumPatterns() int {
 	return len(m.patterns)
 }
 
+func (m *FastTracker) Patterns() []Pattern {
+	return m.patterns
+}
+
+func (m *FastTracker) GetPattern(patternNumber int) (Pattern,error) {
+	if (patternNumber < 0 || patternNumber >= len(m.patterns)) {
+		return Pattern{},errors.New("Pattern index out of range.")
+	}
+	return m.patterns[patternNumber], nil
+}
+
+func (m *FastTracker) NumChannels() int {
+	return int(m.numChannels)
+}
+
+// TrackerName returns the raw tracker name stored in the header
+func (m *FastTracker) TrackerName() string {
+	return filterNulls(m.trackerName)
+}
+
+// RawTrackerName returns the 20 byte tracker name field as stored
+func (m *FastTracker) RawTrackerName() []byte {
+	return padString(m.trackerName, 20)
+}
+
+// Author returns the tracker name field, which older code mistook for the author.
+//
+// Deprecated: use TrackerName or Tracker.
 func (m *FastTracker) Author() string {
-	return m.author
+	return filterNulls(m.trackerName)
+}
+
+// Tracker identifies the program that saved the module
+func (m *FastTracker) Tracker() TrackerInfo {
+	return fingerprintXM(m.trackerName, m.version)
 }
 
 func (m *FastTracker) Version() uint16 {
 	return m.version
 }
 
+// HeaderSize returns the size of the header, counted from its own field at offset 60
+func (m *FastTracker) HeaderSize() uint32 {
+	return m.headerSize
+}
+
+// HeaderExtra returns any bytes padding the header out past the order table
+func (m *FastTracker) HeaderExtra() []byte {
+	return m.headerExtra
+}
+
 func (m *FastTracker) PatternSize() uint16 {
 	return m.patternSize
 }
@@ -221,3 +496,80 @@ func (m *FastTracker) FTInstruments() []FTInstrument {
 	return m.instruments
 }
 
+
+func (m *FastTracker) SetTitle(title string) {
+	m.title = title
+}
+
+func (m *FastTracker) SetTrackerName(trackerName string) {
+	m.trackerName = trackerName
+}
+
+// Deprecated: use SetTrackerName.
+func (m *FastTracker) SetAuthor(author string) {
+	m.trackerName = author
+}
+
+func (m *FastTracker) SetVersion(version uint16) {
+	m.version = version
+}
+
+func (m *FastTracker) SetHeaderSize(headerSize uint32) {
+	m.headerSize = headerSize
+}
+
+func (m *FastTracker) SetHeaderExtra(extra []byte) {
+	m.headerExtra = extra
+}
+
+func (m *FastTracker) SetRestartPosition(restartPos uint16) {
+	m.restartPos = restartPos
+}
+
+func (m *FastTracker) SetFlags(flags uint16) {
+	m.flags = flags
+}
+
+func (m *FastTracker) SetTempo(tempo uint16) {
+	m.tempo = tempo
+}
+
+func (m *FastTracker) SetBPM(bpm uint16) {
+	m.bpm = bpm
+}
+
+// SetOrderTable sets the song's pattern order, which also defines the song length
+func (m *FastTracker) SetOrderTable(orders []byte) error {
+	if (len(orders) > 256) {
+		return errors.New("Order table exceeds 256 entries")
+	}
+	m.patternSize = uint16(len(orders))
+	m.orderTable = make([]byte, 256)
+	copy(m.orderTable, orders)
+	return nil
+}
+
+// SetPatternSize sets the song length separately from the order table, so
+// entries past the end of the song can be kept
+func (m *FastTracker) SetPatternSize(patternSize uint16) error {
+	if (patternSize > 256) {
+		return errors.New("Song length exceeds 256 entries")
+	}
+	m.patternSize = patternSize
+	return nil
+}
+
+func (m *FastTracker) AddPattern(pattern Pattern) error {
+	if (pattern.NumChannels() != m.NumChannels()) {
+		return errors.New(fmt.Sprintf("Pattern has %d channels, expected %d", pattern.NumChannels(), m.NumChannels()))
+	}
+	if (pattern.NumRows() < 1 || pattern.NumRows() > 256) {
+		return errors.New(fmt.Sprintf("Invalid number of rows %d", pattern.NumRows()))
+	}
+	m.patterns = append(m.patterns, pattern)
+	return nil
+}
+
+func (m *FastTracker) AddInstrument(instrument FTInstrument) {
+	m.instruments = append(m.instruments, instrument)
+}
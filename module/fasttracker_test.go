@@ -0,0 +1,373 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// buildTestXM returns a minimal XM file with no instruments and the given
+// patterns, each supplied as its number of rows and packed data.
+func buildTestXM(numChannels int, rows []int, packed [][]byte) []byte {
+	data := make([]byte, 336)
+	copy(data[0:17], "Extended Module: ")
+	copy(data[17:37], "test song")
+	data[37] = 0x1a
+	copy(data[38:58], "FastTracker v2.00")
+	binary.LittleEndian.PutUint16(data[58:60], 0x0104)
+	binary.LittleEndian.PutUint32(data[60:64], 276)
+	binary.LittleEndian.PutUint16(data[64:66], uint16(len(rows)))
+	binary.LittleEndian.PutUint16(data[68:70], uint16(numChannels))
+	binary.LittleEndian.PutUint16(data[70:72], uint16(len(rows)))
+	binary.LittleEndian.PutUint16(data[76:78], 6)
+	binary.LittleEndian.PutUint16(data[78:80], 125)
+	for i := range rows {
+		data[80+i] = byte(i)
+	}
+
+	for i, numRows := range rows {
+		hdr := make([]byte, 9)
+		binary.LittleEndian.PutUint32(hdr[0:4], 9)
+		binary.LittleEndian.PutUint16(hdr[5:7], uint16(numRows))
+		binary.LittleEndian.PutUint16(hdr[7:9], uint16(len(packed[i])))
+		data = append(data, hdr...)
+		data = append(data, packed[i]...)
+	}
+	return data
+}
+
+func TestFastTrackerLoadPatterns(t *testing.T) {
+	packed := []byte{
+		// row 0: full C-4 on channel 0, instrument only on channel 1
+		49, 1, 0x40, 0x0f, 0x06, 0x82, 2,
+		// row 1: key off on channel 0, effect parameter only on channel 1
+		0x81, xmKeyOff, 0x90, 0x20,
+	}
+	data := buildTestXM(2, []int{2, 128}, [][]byte{packed, {}})
+
+	m := &FastTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading XM: %v", err)
+	}
+	if m.NumChannels() != 2 || m.NumPatterns() != 2 {
+		t.Fatalf("Expected 2 channels and 2 patterns, got %d,%d", m.NumChannels(), m.NumPatterns())
+	}
+
+	pattern, _ := m.GetPattern(0)
+	row, _ := pattern.GetRow(0)
+	n := row.Notes()[0]
+	if n.Key() != 49 || n.Instrument() != 1 || n.Volume() != 0x40 || n.Effect() != 0x0f || n.Parameter() != 6 {
+		t.Errorf("Expected 49,1,64,15,6 got %d,%d,%d,%d,%d", n.Key(), n.Instrument(), n.Volume(), n.Effect(), n.Parameter())
+	}
+	if s, _ := n.ToString(); s != "C-4" {
+		t.Errorf("Expected C-4, got %s", s)
+	}
+	n = row.Notes()[1]
+	if n.Key() != 0 || n.Instrument() != 2 {
+		t.Errorf("Expected 0,2 got %d,%d", n.Key(), n.Instrument())
+	}
+
+	row, _ = pattern.GetRow(1)
+	if s, _ := row.Notes()[0].ToString(); s != "===" {
+		t.Errorf("Expected key off, got %s", s)
+	}
+	n = row.Notes()[1]
+	if n.Effect() != 0 || n.Parameter() != 0x20 {
+		t.Errorf("Expected 0,32 got %d,%d", n.Effect(), n.Parameter())
+	}
+
+	empty, _ := m.GetPattern(1)
+	if empty.NumRows() != 128 || empty.NumChannels() != 2 {
+		t.Errorf("Expected empty 128 row pattern, got %d rows", empty.NumRows())
+	}
+}
+
+func TestFastTrackerTruncatedPattern(t *testing.T) {
+	data := buildTestXM(2, []int{2}, [][]byte{{0x83, 49}})
+	m := &FastTracker{}
+	if err := m.Load(data); err == nil {
+		t.Errorf("Expected error for truncated pattern data")
+	}
+}
+
+func TestFastTrackerSaveRoundTrip(t *testing.T) {
+	m := NewFastTracker(4)
+	m.SetTitle("round trip")
+	m.SetTrackerName("FastTracker v2.00")
+	m.SetOrderTable([]byte{0, 1, 0})
+
+	p := NewPattern(4, 64)
+	p.SetRow(0, NewRow([]Note{NewXMNote(49, 1, 0x40, 0x0f, 6), NewXMNote(KeyOff, 0, 0, 0, 0), {}, NewXMNote(0, 0, 0, 0x0c, 0x20)}))
+	m.AddPattern(p)
+	m.AddPattern(NewPattern(4, 32))
+
+	instrument := NewFTInstrument("lead")
+	instrument.SetKeymap([96]uint8{0, 0, 1, 1})
+	instrument.SetVolumeEnvelope(FTEnvelope{Points: []FTEnvelopePoint{{0, 64}, {10, 32}, {20, 0}}, Sustain: 1, Flags: FT_ENVELOPE_ON | FT_ENVELOPE_SUSTAIN})
+	instrument.SetVibrato(FTVibrato{Type: FT_VIBRATO_SQUARE, Sweep: 1, Depth: 2, Rate: 3})
+	instrument.SetFadeout(256)
+	instrument.SetHeaderExtra(make([]byte, 22))
+	s8 := NewFTSample("8 bit", []byte{0, 10, 20, 127, 128, 255})
+	s8.SetLoop(2, 4)
+	s8.SetSampleType(1)
+	instrument.AddSample(s8)
+	s16 := NewFTSample("16 bit", []byte{0x00, 0x00, 0xff, 0x7f, 0x00, 0x80, 0x34, 0x12})
+	s16.SetSampleType(1 << 4)
+	instrument.AddSample(s16)
+	m.AddInstrument(instrument)
+	m.AddInstrument(NewFTInstrument("empty"))
+
+	data, err := m.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error saving XM: %v", err)
+	}
+
+	loaded := &FastTracker{}
+	if err := loaded.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading saved XM: %v", err)
+	}
+	if loaded.NumPatterns() != 2 || len(loaded.FTInstruments()) != 2 || loaded.PatternSize() != 3 {
+		t.Fatalf("Expected 2 patterns, 2 instruments, 3 orders, got %d,%d,%d", loaded.NumPatterns(), len(loaded.FTInstruments()), loaded.PatternSize())
+	}
+	inst := loaded.FTInstruments()[0]
+	if len(inst.VolumeEnvelope().Points) != 3 || inst.Fadeout() != 256 || inst.Vibrato().Rate != 3 || inst.Keymap()[2] != 1 {
+		t.Errorf("Instrument settings did not survive round trip")
+	}
+	if string(inst.Samples()[1].Data()) != string(s16.Data()) {
+		t.Errorf("Expected 16-bit sample data %v, got %v", s16.Data(), inst.Samples()[1].Data())
+	}
+
+	resaved, err := loaded.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error resaving XM: %v", err)
+	}
+	if string(resaved) != string(data) {
+		t.Errorf("Round trip produced different bytes")
+	}
+}
+
+// buildTestXMInstrument returns an instrument as FastTracker 2 writes it: a
+// 263 byte header, the sample headers, then the delta encoded sample data.
+// Envelope slots past the used points keep whatever an earlier edit left.
+func buildTestXMInstrument(name string, samples ...[]byte) []byte {
+	hdr := make([]byte, 263)
+	binary.LittleEndian.PutUint32(hdr[0:4], 263)
+	copy(hdr[4:26], name)
+	binary.LittleEndian.PutUint16(hdr[27:29], uint16(len(samples)))
+	if (len(samples) == 0) {
+		return hdr
+	}
+	binary.LittleEndian.PutUint32(hdr[29:33], 40)
+	for i := 0; i < 96; i++ {
+		hdr[33+i] = byte(i / 48 % len(samples))
+	}
+	volumePoints := []uint16{0, 64, 8, 48, 16, 32, 32, 0, 40, 7, 44, 3}
+	for i, v := range volumePoints {
+		binary.LittleEndian.PutUint16(hdr[129+i*2:], v)
+	}
+	panningPoints := []uint16{0, 32, 16, 48, 99, 1}
+	for i, v := range panningPoints {
+		binary.LittleEndian.PutUint16(hdr[177+i*2:], v)
+	}
+	copy(hdr[225:235], []byte{
+		4, 2,		// number of volume and panning points
+		1, 1, 2,	// volume sustain, loop start, loop end
+		0, 0, 1,	// panning sustain, loop start, loop end
+		FT_ENVELOPE_ON | FT_ENVELOPE_SUSTAIN | FT_ENVELOPE_LOOP,
+		FT_ENVELOPE_ON | FT_ENVELOPE_LOOP,
+	})
+	copy(hdr[235:239], []byte{FT_VIBRATO_RAMP_DOWN, 2, 3, 4})
+	binary.LittleEndian.PutUint16(hdr[239:241], 0x0200)
+
+	for i, data := range samples {
+		sample := make([]byte, 40)
+		binary.LittleEndian.PutUint32(sample[0:4], uint32(len(data)))
+		binary.LittleEndian.PutUint32(sample[4:8], 2)
+		binary.LittleEndian.PutUint32(sample[8:12], 4)
+		copy(sample[12:18], []byte{48, 0xF0, 1, 0x80, 12, 0})
+		copy(sample[18:40], fmt.Sprintf("sample %d", i))
+		hdr = append(hdr, sample...)
+	}
+	for _, data := range samples {
+		hdr = append(hdr, encode8Bit(data)...)
+	}
+	return hdr
+}
+
+func TestFastTrackerInstrumentHeader(t *testing.T) {
+	data := buildTestXM(2, []int{1}, [][]byte{nil})
+	binary.LittleEndian.PutUint16(data[72:74], 2)
+	data = append(data, buildTestXMInstrument("lead", []byte{0, 8, 16, 8, 0, 0xF8}, []byte{1, 2})...)
+	data = append(data, buildTestXMInstrument("empty")...)
+
+	m := &FastTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading XM: %v", err)
+	}
+	if (len(m.FTInstruments()) != 2) {
+		t.Fatalf("Expected 2 instruments, got %d", len(m.FTInstruments()))
+	}
+	inst := m.FTInstruments()[0]
+	volume := inst.VolumeEnvelope()
+	panning := inst.PanningEnvelope()
+	for _, tt := range []struct {
+		field string
+		got interface{}
+		want interface{}
+	}{
+		{"samples", len(inst.Samples()), 2},
+		{"keymap[0]", inst.Keymap()[0], uint8(0)},
+		{"keymap[47]", inst.Keymap()[47], uint8(0)},
+		{"keymap[48]", inst.Keymap()[48], uint8(1)},
+		{"keymap[95]", inst.Keymap()[95], uint8(1)},
+		{"volume points", volume.Points, []FTEnvelopePoint{{0, 64}, {8, 48}, {16, 32}, {32, 0}}},
+		{"volume sustain", volume.Sustain, uint8(1)},
+		{"volume loop start", volume.LoopStart, uint8(1)},
+		{"volume loop end", volume.LoopEnd, uint8(2)},
+		{"volume enabled", volume.Enabled(), true},
+		{"volume has sustain", volume.HasSustain(), true},
+		{"volume has loop", volume.HasLoop(), true},
+		{"panning points", panning.Points, []FTEnvelopePoint{{0, 32}, {16, 48}}},
+		{"panning sustain", panning.Sustain, uint8(0)},
+		{"panning loop start", panning.LoopStart, uint8(0)},
+		{"panning loop end", panning.LoopEnd, uint8(1)},
+		{"panning enabled", panning.Enabled(), true},
+		{"panning has sustain", panning.HasSustain(), false},
+		{"panning has loop", panning.HasLoop(), true},
+		{"vibrato", inst.Vibrato(), FTVibrato{Type: FT_VIBRATO_RAMP_DOWN, Sweep: 2, Depth: 3, Rate: 4}},
+		{"fadeout", inst.Fadeout(), uint16(0x0200)},
+		{"header extra", len(inst.HeaderExtra()), 22},
+		{"sample[1] data", inst.Samples()[1].Data(), []byte{1, 2}},
+		{"sample[0] relative note", inst.Samples()[0].RelativeNote(), uint8(12)},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.field, tt.want, tt.got)
+		}
+	}
+
+	// an instrument without samples has no extended header to decode
+	empty := m.FTInstruments()[1]
+	if (len(empty.Samples()) != 0 || len(empty.VolumeEnvelope().Points) != 0 || len(empty.HeaderExtra()) != 234) {
+		t.Errorf("Expected an empty instrument keeping its 234 header bytes, got %d samples, %d points, %d bytes",
+			len(empty.Samples()), len(empty.VolumeEnvelope().Points), len(empty.HeaderExtra()))
+	}
+}
+
+// TestFastTrackerRawRoundTrip saves an XM shaped like FastTracker 2's own
+// files, first as loaded and then rebuilt through the accessors and setters
+// as the JSON import does, expecting the original bytes both times
+func TestFastTrackerRawRoundTrip(t *testing.T) {
+	p := NewPattern(2, 4)
+	p.SetRow(0, NewRow([]Note{NewXMNote(49, 1, 0x40, 0x0f, 6), NewXMNote(KeyOff, 0, 0, 0, 0)}))
+	packed, _ := savePattern(p)
+	data := buildTestXM(2, []int{4, 4}, [][]byte{packed, nil})
+	// names keep what was typed over them after the first null
+	copy(data[17:37], "new song\x00old song name")
+	copy(data[38:58], "FastTracker v2.00   ")
+	// the song was shortened, leaving old entries in the order table
+	binary.LittleEndian.PutUint16(data[64:66], 2)
+	copy(data[80:86], []byte{1, 0, 1, 1, 0, 1})
+	binary.LittleEndian.PutUint16(data[72:74], 2)
+	data = append(data, buildTestXMInstrument("bass\x00\xe1\xe2 drum", []byte{0, 8, 16, 8, 0, 0xF8})...)
+	data = append(data, buildTestXMInstrument("")...)
+
+	m := &FastTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading XM: %v", err)
+	}
+	if (m.Title() != "new songold song na" || m.TrackerName() != "FastTracker v2.00   ") {
+		t.Errorf("Got title %q and tracker %q", m.Title(), m.TrackerName())
+	}
+	saved, err := m.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error saving XM: %v", err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Errorf("Saving the loaded XM produced different bytes")
+	}
+
+	rebuilt := NewFastTracker(m.NumChannels())
+	rebuilt.SetTitle(string(m.RawTitle()))
+	rebuilt.SetTrackerName(string(m.RawTrackerName()))
+	rebuilt.SetVersion(m.Version())
+	rebuilt.SetHeaderSize(m.HeaderSize())
+	rebuilt.SetHeaderExtra(m.HeaderExtra())
+	rebuilt.SetRestartPosition(m.RestartPosition())
+	rebuilt.SetFlags(m.Flags())
+	rebuilt.SetTempo(m.Tempo())
+	rebuilt.SetBPM(m.BPM())
+	rebuilt.SetOrderTable(m.OrderTable())
+	rebuilt.SetPatternSize(m.PatternSize())
+	for _, pattern := range m.Patterns() {
+		rebuilt.AddPattern(pattern)
+	}
+	for _, inst := range m.FTInstruments() {
+		instrument := NewFTInstrument(string(inst.RawName()))
+		instrument.SetType(inst.Type())
+		instrument.SetSampleHeaderSize(inst.SampleHeaderSize())
+		instrument.SetKeymap(inst.Keymap())
+		instrument.SetVolumeEnvelope(inst.VolumeEnvelope())
+		instrument.SetPanningEnvelope(inst.PanningEnvelope())
+		instrument.SetVibrato(inst.Vibrato())
+		instrument.SetFadeout(inst.Fadeout())
+		instrument.SetHeaderExtra(inst.HeaderExtra())
+		for _, sample := range inst.Samples() {
+			s := NewFTSample(string(sample.RawName()), sample.Data())
+			s.SetLoop(sample.LoopStart(), sample.LoopEnd())
+			s.SetVolume(sample.Volume())
+			s.SetFinetune(sample.Finetune())
+			s.SetSampleType(sample.SampleType())
+			s.SetPanning(sample.Panning())
+			s.SetRelativeNote(sample.RelativeNote())
+			s.SetDataType(sample.DataType())
+			s.SetHeaderExtra(sample.HeaderExtra())
+			instrument.AddSample(s)
+		}
+		rebuilt.AddInstrument(instrument)
+	}
+	saved, err = rebuilt.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error saving rebuilt XM: %v", err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Errorf("Saving the rebuilt XM produced different bytes")
+	}
+
+	// a larger header keeps the bytes past the order table
+	data = buildTestXM(2, []int{4}, [][]byte{packed})
+	binary.LittleEndian.PutUint32(data[60:64], 280)
+	data = append(data[:336], append([]byte{1, 2, 3, 4}, data[336:]...)...)
+	m = &FastTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading XM: %v", err)
+	}
+	if saved, _ := m.Save(); !bytes.Equal(saved, data) {
+		t.Errorf("Saving an XM with a 280 byte header produced different bytes")
+	}
+
+	// so do longer pattern and sample headers
+	data = buildTestXM(2, []int{4}, [][]byte{packed})
+	binary.LittleEndian.PutUint32(data[336:340], 11)
+	data = append(data[:345], append([]byte{5, 6}, data[345:]...)...)
+	binary.LittleEndian.PutUint16(data[72:74], 1)
+	instrument := buildTestXMInstrument("lead", []byte{0, 8, 16, 8, 0, 0xF8})
+	binary.LittleEndian.PutUint32(instrument[29:33], 44)
+	instrument = append(instrument[:303], append([]byte{7, 8, 9, 10}, instrument[303:]...)...)
+	data = append(data, instrument...)
+	m = &FastTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading XM: %v", err)
+	}
+	pattern, _ := m.GetPattern(0)
+	sample := m.FTInstruments()[0].Samples()[0]
+	if (pattern.HeaderLength() != 11 || !bytes.Equal(pattern.HeaderExtra(), []byte{5, 6}) ||
+		!bytes.Equal(sample.HeaderExtra(), []byte{7, 8, 9, 10}) || len(sample.Data()) != 6) {
+		t.Errorf("Got pattern header %d %v, sample header extra %v and %d bytes of data",
+			pattern.HeaderLength(), pattern.HeaderExtra(), sample.HeaderExtra(), len(sample.Data()))
+	}
+	if saved, _ := m.Save(); !bytes.Equal(saved, data) {
+		t.Errorf("Saving an XM with 11 byte pattern and 44 byte sample headers produced different bytes")
+	}
+}
@@ -1,11 +1,75 @@
 package module
 
+// Envelope flags
+const (
+	FT_ENVELOPE_ON = 1 << iota
+	FT_ENVELOPE_SUSTAIN
+	FT_ENVELOPE_LOOP
+)
+
+// Auto-vibrato waveforms
+const (
+	FT_VIBRATO_SINE = iota
+	FT_VIBRATO_SQUARE
+	FT_VIBRATO_RAMP_DOWN
+	FT_VIBRATO_RAMP_UP
+)
+
+type FTEnvelopePoint struct {
+	Tick uint16
+	Value uint16
+}
+
+// FTEnvelope is a volume or panning envelope of up to 12 points. Sustain and
+// loop positions are indexes into Points. Unused holds whatever is stored in
+// the slots after them, which is written back as it is.
+type FTEnvelope struct {
+	Points []FTEnvelopePoint
+	Unused []FTEnvelopePoint
+	Sustain uint8
+	LoopStart uint8
+	LoopEnd uint8
+	Flags uint8
+}
+
+func (e FTEnvelope) Enabled() bool {
+	return (e.Flags & FT_ENVELOPE_ON) != 0
+}
+
+func (e FTEnvelope) HasSustain() bool {
+	return (e.Flags & FT_ENVELOPE_SUSTAIN) != 0
+}
+
+func (e FTEnvelope) HasLoop() bool {
+	return (e.Flags & FT_ENVELOPE_LOOP) != 0
+}
+
+// FTVibrato holds the instrument's auto-vibrato settings
+type FTVibrato struct {
+	Type uint8
+	Sweep uint8
+	Depth uint8
+	Rate uint8
+}
+
 type FTInstrument struct {
 	name string
+	instType uint8
+	sampleHeaderSize uint32
+	keymap [96]uint8
+	volumeEnvelope FTEnvelope
+	panningEnvelope FTEnvelope
+	vibrato FTVibrato
+	fadeout uint16
+	headerExtra []byte
 	samples []FTSample
 	Instrument
 }
 
+func NewFTInstrument(name string) FTInstrument {
+	return FTInstrument{name: name}
+}
+
 func (i FTInstrument) Name() string {
 	return i.name
 }
@@ -14,6 +78,84 @@ func (i FTInstrument) Filename() string {
 	return i.name
 }
 
+// RawName returns the 22 byte name field as stored
+func (i FTInstrument) RawName() []byte {
+	return padString(i.name, 22)
+}
+
+// SampleHeaderSize returns the stated size of each sample header, or 0 for
+// an instrument without samples
+func (i FTInstrument) SampleHeaderSize() uint32 {
+	return i.sampleHeaderSize
+}
+
 func (i FTInstrument) Samples() []FTSample {
 	return i.samples
 }
+
+// Keymap returns the sample number (within this instrument) to play for each of the 96 notes
+func (i FTInstrument) Keymap() [96]uint8 {
+	return i.keymap
+}
+
+func (i FTInstrument) VolumeEnvelope() FTEnvelope {
+	return i.volumeEnvelope
+}
+
+func (i FTInstrument) PanningEnvelope() FTEnvelope {
+	return i.panningEnvelope
+}
+
+func (i FTInstrument) Vibrato() FTVibrato {
+	return i.vibrato
+}
+
+func (i FTInstrument) Fadeout() uint16 {
+	return i.fadeout
+}
+
+// Type returns the instrument type byte, which FastTracker ignores and often leaves as garbage
+func (i FTInstrument) Type() uint8 {
+	return i.instType
+}
+
+// HeaderExtra returns any reserved bytes padding the instrument header out to its stated size
+func (i FTInstrument) HeaderExtra() []byte {
+	return i.headerExtra
+}
+
+func (i *FTInstrument) SetType(instType uint8) {
+	i.instType = instType
+}
+
+func (i *FTInstrument) SetSampleHeaderSize(size uint32) {
+	i.sampleHeaderSize = size
+}
+
+func (i *FTInstrument) SetKeymap(keymap [96]uint8) {
+	i.keymap = keymap
+}
+
+func (i *FTInstrument) SetVolumeEnvelope(envelope FTEnvelope) {
+	i.volumeEnvelope = envelope
+}
+
+func (i *FTInstrument) SetPanningEnvelope(envelope FTEnvelope) {
+	i.panningEnvelope = envelope
+}
+
+func (i *FTInstrument) SetVibrato(vibrato FTVibrato) {
+	i.vibrato = vibrato
+}
+
+func (i *FTInstrument) SetFadeout(fadeout uint16) {
+	i.fadeout = fadeout
+}
+
+func (i *FTInstrument) SetHeaderExtra(extra []byte) {
+	i.headerExtra = extra
+}
+
+func (i *FTInstrument) AddSample(sample FTSample) {
+	i.samples = append(i.samples, sample)
+}
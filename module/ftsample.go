@@ -11,9 +11,15 @@ type FTSample struct {
 	panning uint8
 	relativeNote uint8
 	dataType uint8
+	headerExtra []byte
 	data []byte
 }
 
+// NewFTSample creates a sample from decoded (non-delta) sample data
+func NewFTSample(name string, data []byte) FTSample {
+	return FTSample{name: name, data: data, length: uint32(len(data))}
+}
+
 func (i FTSample) Name() string {
 	return i.name
 }
@@ -22,6 +28,11 @@ func (i FTSample) Filename() string {
 	return i.name
 }
 
+// RawName returns the 22 byte name field as stored
+func (i FTSample) RawName() []byte {
+	return padString(i.name, 22)
+}
+
 func (i FTSample) Data() []byte {
 	return i.data
 }
@@ -30,6 +41,7 @@ func (i FTSample) LoopStart() uint32 {
 	return i.loopStart
 }
 
+// LoopEnd returns the loop length in bytes, as stored in the XM sample header
 func (i FTSample) LoopEnd() uint32 {
 	return i.loopEnd
 }
@@ -61,3 +73,47 @@ func (i FTSample) RelativeNote() uint8 {
 func (i FTSample) DataType() uint8 {
 	return i.dataType
 }
+
+// HeaderExtra returns any bytes padding the sample header out past 40 bytes,
+// up to the instrument's sample header size
+func (i FTSample) HeaderExtra() []byte {
+	return i.headerExtra
+}
+
+// Is16Bit reports whether the sample data holds 16-bit little-endian values
+func (i FTSample) Is16Bit() bool {
+	return ((1 << 4) & i.sampleType) != 0
+}
+
+func (i *FTSample) SetLoop(loopStart uint32, loopEnd uint32) {
+	i.loopStart = loopStart
+	i.loopEnd = loopEnd
+}
+
+func (i *FTSample) SetVolume(volume uint8) {
+	i.volume = volume
+}
+
+func (i *FTSample) SetFinetune(finetune uint8) {
+	i.finetune = finetune
+}
+
+func (i *FTSample) SetSampleType(sampleType uint8) {
+	i.sampleType = sampleType
+}
+
+func (i *FTSample) SetPanning(panning uint8) {
+	i.panning = panning
+}
+
+func (i *FTSample) SetRelativeNote(relativeNote uint8) {
+	i.relativeNote = relativeNote
+}
+
+func (i *FTSample) SetDataType(dataType uint8) {
+	i.dataType = dataType
+}
+
+func (i *FTSample) SetHeaderExtra(extra []byte) {
+	i.headerExtra = extra
+}
@@ -0,0 +1,173 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Save encodes the module as an XM file. Patterns are packed and sample data
+// delta-encoded the same way FastTracker 2 does, so a module that was loaded
+// from an FT2-written file saves back to identical bytes.
+func (m *FastTracker) Save() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	headerSize := m.headerSize
+	if (headerSize == 0) {
+		headerSize = 276
+	}
+
+	// header
+	buf.WriteString("Extended Module: ")
+	buf.Write(padString(m.title, 20))
+	buf.WriteByte(0x1a)
+	buf.Write(padString(m.trackerName, 20))
+	binary.Write(buf, binary.LittleEndian, m.version)
+	binary.Write(buf, binary.LittleEndian, headerSize)
+	binary.Write(buf, binary.LittleEndian, m.patternSize)
+	binary.Write(buf, binary.LittleEndian, m.restartPos)
+	binary.Write(buf, binary.LittleEndian, m.numChannels)
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.patterns)))
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.instruments)))
+	binary.Write(buf, binary.LittleEndian, m.flags)
+	binary.Write(buf, binary.LittleEndian, m.tempo)
+	binary.Write(buf, binary.LittleEndian, m.bpm)
+	orderTable := make([]byte, 256)
+	copy(orderTable, m.orderTable)
+	buf.Write(orderTable)
+	buf.Write(m.headerExtra)
+	// the header is cut or padded to its stated size, which is counted from offset 60
+	buf.Truncate(min(buf.Len(), 60 + int(headerSize)))
+	buf.Write(make([]byte, 60 + int(headerSize) - buf.Len()))
+
+	for i, pattern := range m.patterns {
+		packed, err := savePattern(pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to write pattern %d: %v", i, err))
+		}
+		// a header shorter than its 9 bytes of fields can't be written back
+		headerLength := pattern.headerLength
+		if (headerLength < 9) {
+			headerLength = 9
+		}
+		binary.Write(buf, binary.LittleEndian, headerLength)
+		buf.WriteByte(0)	// packing type
+		binary.Write(buf, binary.LittleEndian, uint16(pattern.NumRows()))
+		binary.Write(buf, binary.LittleEndian, uint16(len(packed)))
+		buf.Write(padBytes(pattern.headerExtra, int(headerLength) - 9))
+		buf.Write(packed)
+	}
+
+	for _, instrument := range m.instruments {
+		saveInstrument(buf, instrument)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// savePattern packs the pattern's notes. Completely empty patterns are
+// stored without any data at all.
+func savePattern(pattern Pattern) ([]byte, error) {
+	packed := make([]byte, 0)
+	empty := true
+	for _, row := range pattern.rows {
+		for k := 0; k < pattern.NumChannels(); k++ {
+			n := Note{}
+			if (k < len(row.notes)) {
+				n = row.notes[k]
+			}
+			if !n.IsEmpty() {
+				empty = false
+			}
+			packed = append(packed, n.SaveXM()...)
+		}
+	}
+	if empty {
+		return []byte{}, nil
+	}
+	if (len(packed) > 0xffff) {
+		return nil, errors.New(fmt.Sprintf("packed size %d exceeds 65535 bytes", len(packed)))
+	}
+	return packed, nil
+}
+
+func saveInstrument(buf *bytes.Buffer, instrument FTInstrument) {
+	hdr := new(bytes.Buffer)
+	hdr.Write(padString(instrument.name, 22))
+	hdr.WriteByte(instrument.instType)
+	binary.Write(hdr, binary.LittleEndian, uint16(len(instrument.samples)))
+
+	if (len(instrument.samples) > 0) {
+		sampleHeaderSize := instrument.sampleHeaderSize
+		if (sampleHeaderSize == 0) {
+			sampleHeaderSize = 40
+		}
+		binary.Write(hdr, binary.LittleEndian, sampleHeaderSize)
+		hdr.Write(instrument.keymap[:])
+		saveFTEnvelopePoints(hdr, instrument.volumeEnvelope)
+		saveFTEnvelopePoints(hdr, instrument.panningEnvelope)
+		hdr.WriteByte(uint8(len(instrument.volumeEnvelope.Points)))
+		hdr.WriteByte(uint8(len(instrument.panningEnvelope.Points)))
+		hdr.WriteByte(instrument.volumeEnvelope.Sustain)
+		hdr.WriteByte(instrument.volumeEnvelope.LoopStart)
+		hdr.WriteByte(instrument.volumeEnvelope.LoopEnd)
+		hdr.WriteByte(instrument.panningEnvelope.Sustain)
+		hdr.WriteByte(instrument.panningEnvelope.LoopStart)
+		hdr.WriteByte(instrument.panningEnvelope.LoopEnd)
+		hdr.WriteByte(instrument.volumeEnvelope.Flags)
+		hdr.WriteByte(instrument.panningEnvelope.Flags)
+		hdr.WriteByte(instrument.vibrato.Type)
+		hdr.WriteByte(instrument.vibrato.Sweep)
+		hdr.WriteByte(instrument.vibrato.Depth)
+		hdr.WriteByte(instrument.vibrato.Rate)
+		binary.Write(hdr, binary.LittleEndian, instrument.fadeout)
+	}
+	hdr.Write(instrument.headerExtra)
+
+	// header size includes its own 4 bytes
+	binary.Write(buf, binary.LittleEndian, uint32(hdr.Len() + 4))
+	buf.Write(hdr.Bytes())
+
+	// sample headers are padded out to their stated size
+	sampleHeaderSize := 40
+	if (instrument.sampleHeaderSize > 40) {
+		sampleHeaderSize = int(instrument.sampleHeaderSize)
+	}
+	for _, sample := range instrument.samples {
+		binary.Write(buf, binary.LittleEndian, uint32(len(sample.data)))
+		binary.Write(buf, binary.LittleEndian, sample.loopStart)
+		binary.Write(buf, binary.LittleEndian, sample.loopEnd)
+		buf.WriteByte(sample.volume)
+		buf.WriteByte(sample.finetune)
+		buf.WriteByte(sample.sampleType)
+		buf.WriteByte(sample.panning)
+		buf.WriteByte(sample.relativeNote)
+		buf.WriteByte(sample.dataType)
+		buf.Write(padString(sample.name, 22))
+		buf.Write(padBytes(sample.headerExtra, sampleHeaderSize - 40))
+	}
+
+	for _, sample := range instrument.samples {
+		if sample.Is16Bit() {
+			buf.Write(encode16Bit(sample.data))
+		} else {
+			buf.Write(encode8Bit(sample.data))
+		}
+	}
+}
+
+// saveFTEnvelopePoints writes the 48 byte block of 12 envelope points, followed
+// by any unused points kept from loading and zero filling the rest
+func saveFTEnvelopePoints(buf *bytes.Buffer, envelope FTEnvelope) {
+	points := make([]byte, 48)
+	all := append(append([]FTEnvelopePoint{}, envelope.Points...), envelope.Unused...)
+	for i, point := range all {
+		if (i >= 12) {
+			break
+		}
+		binary.LittleEndian.PutUint16(points[i*4:i*4+2], point.Tick)
+		binary.LittleEndian.PutUint16(points[i*4+2:i*4+4], point.Value)
+	}
+	buf.Write(points)
+}
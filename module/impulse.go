@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 )
 
 type ImpulseTracker struct {
@@ -20,23 +21,48 @@ type ImpulseTracker struct {
 	pitchWheelDepth uint8
 	messageLength uint16
 	messageOffset uint32
+	message string
+	numChannels int
 	orders []uint8
 	instruments []ITInstrument
 	samples []ITSample
 	patterns []Pattern
+	loadState
 	Module
 }
 
+func init() {
+	Register(Format{
+		Name: "impulsetracker",
+		Extensions: []string{".it"},
+		Detect: detectImpulseTracker,
+		New: func() Module { return &ImpulseTracker{} },
+	})
+}
+
+// detectImpulseTracker looks for the "IMPM" signature
+func detectImpulseTracker(data []byte) int {
+	if (len(data) < 192 || string(data[0:4]) != "IMPM") {
+		return SCORE_NONE
+	}
+	return SCORE_CERTAIN
+}
+
 func (m *ImpulseTracker) Type() FileFormat {
 	return IMPULSETRACKER
 }
 
 func (m *ImpulseTracker) Load(data []byte) (error) {
+	r := reader{format: "impulsetracker", data: data}
+	limits := m.limits()
+	if err := r.check("header", 0, 192); err != nil {
+		return err
+	}
 	m.title = filterNulls(string(data[4:30]))
 	numOrders := binary.LittleEndian.Uint16(data[32:34])
 	numInstruments := binary.LittleEndian.Uint16(data[34:36])
 	numSamples := binary.LittleEndian.Uint16(data[36:38])
-	//numPatterns := int(binary.LittleEndian.Uint16(data[38:40]))
+	numPatterns := binary.LittleEndian.Uint16(data[38:40])
 	m.version = binary.LittleEndian.Uint16(data[40:42])
 	m.compat  = binary.LittleEndian.Uint16(data[42:44])
 	m.flags = binary.LittleEndian.Uint16(data[44:46])
@@ -50,8 +76,27 @@ func (m *ImpulseTracker) Load(data []byte) (error) {
 	m.messageLength = binary.LittleEndian.Uint16(data[54:56])
 	m.messageOffset = binary.LittleEndian.Uint32(data[56:60])
 
-	// read order list
+	// the song message is only present if bit 0 of special is set
+	messageEnd := int(m.messageOffset) + int(m.messageLength)
+	if (m.special & 1) != 0 && messageEnd <= len(data) {
+		m.message = decodeCP437(data[m.messageOffset:messageEnd])
+	}
+
+	if err := checkLimit("MaxSamples", int64(numInstruments), int64(limits.MaxSamples)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxSamples", int64(numSamples), int64(limits.MaxSamples)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxPatterns", int64(numPatterns), int64(limits.MaxPatterns)); err != nil {
+		return err
+	}
+
+	// read order list, followed by the instrument, sample and pattern offsets
 	offset := 192
+	if err := r.check("orders", offset, int(numOrders) + (int(numInstruments) + int(numSamples) + int(numPatterns)) * 4); err != nil {
+		return err
+	}
 	for i := offset; i < offset+int(numOrders); i++ {
 		m.orders = append(m.orders, data[i])
 	}
@@ -73,37 +118,46 @@ func (m *ImpulseTracker) Load(data []byte) (error) {
 		offset = offset + 4
 	}
 
+	// read pattern offsets
+	patternOffsets := make([]uint32, 0)
+	for i := 0; i < int(numPatterns); i++ {
+		poff := binary.LittleEndian.Uint32(data[offset:offset+4])
+		patternOffsets = append(patternOffsets, poff)
+		offset = offset + 4
+	}
+
 	// read instruments
 	for i, v := range instrumentOffsets {
 		offset = int(v)
+		field := fmt.Sprintf("instrument[%d]", i)
+		if err := r.check(field, offset, 554); err != nil {
+			return err
+		}
 		hdr := string(data[offset:offset+4])
 		if hdr != "IMPI" {
-			return errors.New(fmt.Sprintf("Invalid instrument %d read at offset %d", i, offset))
+			return r.invalid(field + ".signature", offset, fmt.Sprintf("%q", hdr))
+		}
+		var instrument ITInstrument
+		if (m.compat < 0x200) {
+			instrument = loadOldITInstrument(data[offset:offset+554])
+		} else {
+			instrument = loadITInstrument(data[offset:offset+554])
 		}
-		offset = offset + 4
-		instrument := ITInstrument{}
-		instrument.filename = filterNulls(string(data[offset:offset+12]))
-		offset = offset + 12
-		// skip flags
-		offset = offset + 16
-		instrument.name = filterNulls(string(data[offset:offset+26]))
-		offset = offset + 26
-		// skip flags
-		offset = offset +6
-		// skip note sample
-		offset = offset + 240
-		// skip envelopes
-		offset = offset + 16
 
 		m.instruments = append(m.instruments, instrument)
 	}
 
 	// read samples
+	totalSampleSize := int64(0)
 	for i, v := range sampleOffsets {
 		offset = int(v)
+		field := fmt.Sprintf("sample[%d]", i)
+		if err := r.check(field, offset, 80); err != nil {
+			return err
+		}
 		hdr := string(data[offset:offset+4])
 		if hdr != "IMPS" {
-			return errors.New(fmt.Sprintf("Invalid sample %d read at offset %d", i, offset))
+			return r.invalid(field + ".signature", offset, fmt.Sprintf("%q", hdr))
 		}
 		offset = offset + 4
 
@@ -111,11 +165,15 @@ func (m *ImpulseTracker) Load(data []byte) (error) {
 		sample.filename = filterNulls(string(data[offset:offset+12]))
 		offset = offset + 12
 
-		// skip flags
+		// skip reserved byte
+		sample.globalVolume = data[offset+1]
+		sample.flags = data[offset+2]
+		sample.volume = data[offset+3]
 		offset = offset + 4
 		sample.name = filterNulls(string(data[offset:offset+26]))
 		offset = offset + 26
-		// skip flags
+		sample.convert = data[offset]
+		sample.defaultPan = data[offset+1]
 		offset = offset + 2
 		sample.length = binary.LittleEndian.Uint32(data[offset:offset+4])
 		offset = offset + 4
@@ -133,12 +191,311 @@ func (m *ImpulseTracker) Load(data []byte) (error) {
 		samplePointer := binary.LittleEndian.Uint32(data[offset:offset+4])
 		offset = offset + 4
 
-		sample.data = data[samplePointer:samplePointer+sample.length]
+		sample.vibratoSpeed = data[offset]
+		sample.vibratoDepth = data[offset+1]
+		sample.vibratoRate = data[offset+2]
+		sample.vibratoType = data[offset+3]
+
+		if sample.HasData() && int(samplePointer) <= len(data) {
+			// compressed samples can unpack to far more than the file size, and
+			// any number of headers can share the same compressed data
+			totalSampleSize += int64(sample.length) * int64(sample.BytesPerFrame())
+			if err := checkLimit("MaxSize", totalSampleSize, limits.MaxSize); err != nil {
+				return err
+			}
+			if sample.IsCompressed() {
+				sampleData, err := decompressITSample(data[samplePointer:], sample)
+				if err != nil {
+					// keep whatever we managed to decompress, truncated samples are common enough
+					slog.Warn("Unable to decompress sample", "index", i, "name", sample.name, "error", err)
+				}
+				sample.data = sampleData
+			} else {
+				end := int(samplePointer) + int(sample.length) * sample.BytesPerFrame()
+				if (end > len(data)) {
+					slog.Warn("Sample data truncated", "index", i, "name", sample.name)
+					end = len(data)
+				}
+				sample.data = data[samplePointer:end]
+			}
+		}
 
 		m.samples = append(m.samples, sample)
 	}
 
-	return nil
+	// read patterns
+	for i, v := range patternOffsets {
+		if v == 0 {
+			// no data, so an empty pattern
+			m.patterns = append(m.patterns, NewPattern(64, 64))
+			continue
+		}
+		offset = int(v)
+		field := fmt.Sprintf("pattern[%d]", i)
+		if err := r.check(field + ".header", offset, 8); err != nil {
+			return err
+		}
+		packedLength := int(binary.LittleEndian.Uint16(data[offset:offset+2]))
+		numRows := int(binary.LittleEndian.Uint16(data[offset+2:offset+4]))
+		if (numRows < 1 || numRows > 256) {
+			return r.invalid(field + ".numRows", offset+2, numRows)
+		}
+		// skip reserved
+		offset = offset + 8
+		packed, err := r.slice(field + ".data", offset, packedLength)
+		if err != nil {
+			return err
+		}
+
+		pattern, err := m.loadPattern(packed, numRows)
+		if err != nil {
+			return r.wrap(field + ".data", offset, err)
+		}
+		m.patterns = append(m.patterns, pattern)
+	}
+
+	return checkLimit("MaxChannels", int64(m.numChannels), int64(limits.MaxChannels))
+}
+
+// loadITInstrument reads an instrument in the format used since IT 2.00
+//
+//	0	char[4]	IMPI
+//	4	char[12]	filename
+//	17	UINT8	NNA	New Note Action
+//	18	UINT8	DCT	Duplicate Check Type
+//	19	UINT8	DCA	Duplicate Check Action
+//	20	UINT16LE	fadeout
+//	22	INT8	PPS	Pitch-Pan separation
+//	23	UINT8	PPC	Pitch-Pan center
+//	24	UINT8	GbV	global volume
+//	25	UINT8	DfP	default pan, bit 7 set when unused
+//	26	UINT8	RV	random volume variation
+//	27	UINT8	RP	random pan variation
+//	32	char[26]	name
+//	58	UINT8	IFC	initial filter cutoff
+//	59	UINT8	IFR	initial filter resonance
+//	64	UINT8[240]	keyboard table, (note, sample) for each of 120 notes
+//	304	BYTE[82]	volume envelope
+//	386	BYTE[82]	panning envelope
+//	468	BYTE[82]	pitch/filter envelope
+func loadITInstrument(data []byte) ITInstrument {
+	instrument := ITInstrument{}
+	instrument.filename = filterNulls(string(data[4:16]))
+	instrument.nna = data[17]
+	instrument.dct = data[18]
+	instrument.dca = data[19]
+	instrument.fadeout = binary.LittleEndian.Uint16(data[20:22])
+	instrument.pitchPanSeparation = int8(data[22])
+	instrument.pitchPanCenter = data[23]
+	instrument.globalVolume = data[24]
+	instrument.defaultPan = data[25]
+	instrument.randomVolume = data[26]
+	instrument.randomPan = data[27]
+	instrument.name = filterNulls(string(data[32:58]))
+	instrument.filterCutoff = data[58]
+	instrument.filterResonance = data[59]
+	for j := 0; j < 120; j++ {
+		instrument.keyboard[j] = ITKeyboardEntry{Note: data[64+j*2], Sample: data[65+j*2]}
+	}
+	instrument.volumeEnvelope = loadITEnvelope(data[304:386])
+	instrument.panningEnvelope = loadITEnvelope(data[386:468])
+	instrument.pitchEnvelope = loadITEnvelope(data[468:550])
+	return instrument
+}
+
+// loadITEnvelope reads an 82 byte envelope: flags, node count, loop and sustain
+// loop start/end, then 25 nodes of a value and a little-endian tick
+func loadITEnvelope(data []byte) ITEnvelope {
+	numNodes := int(data[1])
+	if (numNodes > 25) {
+		numNodes = 25
+	}
+	envelope := ITEnvelope{
+		Flags: data[0],
+		LoopStart: data[2],
+		LoopEnd: data[3],
+		SustainStart: data[4],
+		SustainEnd: data[5],
+		Nodes: make([]ITEnvelopeNode, numNodes),
+	}
+	for j := 0; j < numNodes; j++ {
+		node := data[6+j*3:9+j*3]
+		envelope.Nodes[j] = ITEnvelopeNode{Value: int8(node[0]), Tick: binary.LittleEndian.Uint16(node[1:3])}
+	}
+	return envelope
+}
+
+// loadOldITInstrument reads an instrument from a file made for IT versions
+// before 2.00, which only had a volume envelope and fewer settings
+//
+//	17	UINT8	flags	envelope on, loop and sustain loop
+//	18	UINT8	VLS/VLE	volume loop start/end
+//	20	UINT8	SLS/SLE	sustain loop start/end
+//	24	UINT16LE	fadeout
+//	26	UINT8	NNA
+//	27	UINT8	DNC	duplicate note check on/off
+//	32	char[26]	name
+//	64	UINT8[240]	keyboard table
+//	504	UINT8[50]	volume envelope nodes, (tick, value) pairs ending with a tick of 0xFF
+func loadOldITInstrument(data []byte) ITInstrument {
+	instrument := ITInstrument{}
+	instrument.filename = filterNulls(string(data[4:16]))
+	// old fadeout has half the range of the new one
+	instrument.fadeout = binary.LittleEndian.Uint16(data[24:26]) * 2
+	instrument.nna = data[26]
+	if data[27] != 0 {
+		instrument.dct = IT_DCT_NOTE
+	}
+	instrument.globalVolume = 128
+	instrument.defaultPan = 0x80 | 32
+	instrument.pitchPanCenter = 60
+	instrument.name = filterNulls(string(data[32:58]))
+	for j := 0; j < 120; j++ {
+		instrument.keyboard[j] = ITKeyboardEntry{Note: data[64+j*2], Sample: data[65+j*2]}
+	}
+	instrument.volumeEnvelope = ITEnvelope{
+		Flags: data[17] & (IT_ENVELOPE_ON | IT_ENVELOPE_LOOP | IT_ENVELOPE_SUSTAIN_LOOP),
+		LoopStart: data[18],
+		LoopEnd: data[19],
+		SustainStart: data[20],
+		SustainEnd: data[21],
+	}
+	for j := 0; j < 25 && data[504+j*2] != 0xFF; j++ {
+		node := ITEnvelopeNode{Tick: uint16(data[504+j*2]), Value: int8(data[505+j*2])}
+		instrument.volumeEnvelope.Nodes = append(instrument.volumeEnvelope.Nodes, node)
+	}
+	return instrument
+}
+
+// decompressITSample decompresses IT214/IT215 sample data. Stereo samples
+// store the compressed right channel straight after the left.
+func decompressITSample(data []byte, sample ITSample) ([]byte, error) {
+	it215 := (sample.convert & IT_CONVERT_DELTA) != 0
+	numChannels := 1
+	if sample.IsStereo() {
+		numChannels = 2
+	}
+
+	r := make([]byte, 0)
+	offset := 0
+	for c := 0; c < numChannels; c++ {
+		var channelData []byte
+		var used int
+		var err error
+		if sample.Is16Bit() {
+			channelData, used, err = decompressIT16Bit(data[offset:], int(sample.length), it215)
+		} else {
+			channelData, used, err = decompressIT8Bit(data[offset:], int(sample.length), it215)
+		}
+		r = append(r, channelData...)
+		if err != nil {
+			return r, err
+		}
+		offset += used
+	}
+	return r, nil
+}
+
+// loadPattern decodes a packed IT pattern. Each row is a list of channel
+// entries terminated by a zero byte. An entry starts with the channel number
+// (plus one), and if its top bit is set a new mask byte for that channel
+// follows. Otherwise the channel's previous mask is reused:
+//
+//	bit 0: note follows
+//	bit 1: instrument follows
+//	bit 2: volume/pan column follows
+//	bit 3: command and value follow
+//	bit 4: use the channel's last note
+//	bit 5: use the channel's last instrument
+//	bit 6: use the channel's last volume/pan
+//	bit 7: use the channel's last command and value
+func (m *ImpulseTracker) loadPattern(data []byte, numRows int) (Pattern, error) {
+	pattern := NewPattern(64, numRows)
+	var masks [64]uint8
+	var last [64]Note
+	pos := 0
+
+	// read a byte, keeping track of whether we've run out
+	truncated := false
+	next := func() uint8 {
+		if (pos >= len(data)) {
+			truncated = true
+			return 0
+		}
+		pos++
+		return data[pos-1]
+	}
+
+	for row := 0; row < numRows; row++ {
+		for {
+			channelVariable := next()
+			if truncated {
+				return pattern, errors.New(fmt.Sprintf("ran out of data at row %d", row))
+			}
+			if channelVariable == 0 {
+				break
+			}
+
+			channel := int(channelVariable - 1) & 63
+			if (channelVariable & 128) != 0 {
+				masks[channel] = next()
+			}
+			mask := masks[channel]
+			n := &pattern.rows[row].notes[channel]
+
+			if (mask & 1) != 0 {
+				last[channel].key = itNoteToKey(next())
+			}
+			if (mask & 2) != 0 {
+				last[channel].instrument = int(next())
+			}
+			if (mask & 4) != 0 {
+				last[channel].volume = int(next())
+				last[channel].hasVolume = true
+			}
+			if (mask & 8) != 0 {
+				last[channel].effect = int(next())
+				last[channel].parameter = int(next())
+			}
+			if truncated {
+				return pattern, errors.New(fmt.Sprintf("truncated note at row %d, channel %d", row, channel))
+			}
+
+			if (mask & (1 | 16)) != 0 {
+				n.key = last[channel].key
+			}
+			if (mask & (2 | 32)) != 0 {
+				n.instrument = last[channel].instrument
+			}
+			if (mask & (4 | 64)) != 0 {
+				n.volume = last[channel].volume
+				n.hasVolume = last[channel].hasVolume
+			}
+			if (mask & (8 | 128)) != 0 {
+				n.effect = last[channel].effect
+				n.parameter = last[channel].parameter
+			}
+
+			if (channel + 1 > m.numChannels) {
+				m.numChannels = channel + 1
+			}
+		}
+	}
+	return pattern, nil
+}
+
+// itNoteToKey converts an IT note byte (0 = C-0 to 119 = B-9) to a key
+// number. 255 is note off, 254 note cut and anything else above 119 a note fade.
+func itNoteToKey(note uint8) int {
+	switch {
+	case note == 255:
+		return KeyOff
+	case note == 254:
+		return KeyCut
+	case note > 119:
+		return KeyFade
+	}
+	return int(note) + 1
 }
 
 func (m *ImpulseTracker) Play() {
@@ -149,6 +506,11 @@ func (m *ImpulseTracker) Title() (string) {
 	return m.title
 }
 
+// Filename returns the name the module was loaded from, if any
+func (m *ImpulseTracker) Filename() string {
+	return m.filename
+}
+
 func (m *ImpulseTracker) Instruments() []Instrument {
 	r := make([]Instrument, len(m.instruments))
 	for i := range m.instruments {
@@ -168,3 +530,55 @@ func (m *ImpulseTracker) Samples() []Sample {
 func (m *ImpulseTracker) NumPatterns() int {
 	return len(m.patterns)
 }
+
+func (m *ImpulseTracker) Patterns() []Pattern {
+	return m.patterns
+}
+
+func (m *ImpulseTracker) GetPattern(patternNumber int) (Pattern,error) {
+	if (patternNumber < 0 || patternNumber >= len(m.patterns)) {
+		return Pattern{},errors.New("Pattern index out of range.")
+	}
+	return m.patterns[patternNumber], nil
+}
+
+// NumChannels returns the number of channels up to the last one used in any pattern
+func (m *ImpulseTracker) NumChannels() int {
+	return m.numChannels
+}
+
+func (m *ImpulseTracker) Orders() []uint8 {
+	return m.orders
+}
+
+func (m *ImpulseTracker) Speed() uint8 {
+	return m.speed
+}
+
+func (m *ImpulseTracker) Tempo() uint8 {
+	return m.tempo
+}
+
+func (m *ImpulseTracker) ITInstruments() []ITInstrument {
+	return m.instruments
+}
+
+// Message returns the song message, with lines separated by newlines
+func (m *ImpulseTracker) Message() string {
+	return m.message
+}
+
+// Version returns the Cwt/v field, the version of the tracker that saved the module
+func (m *ImpulseTracker) Version() uint16 {
+	return m.version
+}
+
+// CompatibleVersion returns the Cmwt field, the oldest tracker version the module works in
+func (m *ImpulseTracker) CompatibleVersion() uint16 {
+	return m.compat
+}
+
+// Tracker identifies the program that saved the module
+func (m *ImpulseTracker) Tracker() TrackerInfo {
+	return fingerprintIT(m.version, m.compat)
+}
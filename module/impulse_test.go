@@ -0,0 +1,261 @@
+package module
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// buildTestIT returns a minimal IT file with no instruments or samples and
+// the given patterns, each supplied as its number of rows and packed data.
+func buildTestIT(rows []int, packed [][]byte) []byte {
+	data := make([]byte, 192)
+	copy(data[0:4], "IMPM")
+	copy(data[4:30], "test song")
+	binary.LittleEndian.PutUint16(data[32:34], 1)
+	binary.LittleEndian.PutUint16(data[38:40], uint16(len(rows)))
+	binary.LittleEndian.PutUint16(data[40:42], 0x0214)
+	binary.LittleEndian.PutUint16(data[42:44], 0x0214)
+	data[50] = 6
+	data[51] = 125
+	data = append(data, 0)
+
+	pointerOffset := len(data)
+	data = append(data, make([]byte, 4*len(rows))...)
+	for i, numRows := range rows {
+		if packed[i] == nil {
+			continue
+		}
+		binary.LittleEndian.PutUint32(data[pointerOffset+i*4:], uint32(len(data)))
+		hdr := make([]byte, 8)
+		binary.LittleEndian.PutUint16(hdr[0:2], uint16(len(packed[i])))
+		binary.LittleEndian.PutUint16(hdr[2:4], uint16(numRows))
+		data = append(data, hdr...)
+		data = append(data, packed[i]...)
+	}
+	return data
+}
+
+func TestImpulseTrackerLoadPatterns(t *testing.T) {
+	packed := []byte{
+		// row 0: C-5 instrument 1 volume 0 command A06 on channel 0, note off on channel 2
+		0x81, 0x0f, 60, 1, 0, 1, 6, 0x83, 0x01, 255, 0,
+		// row 1: repeat everything from channel 0's last values
+		0x81, 0xf0, 0,
+		// row 2: reuse channel 0's mask
+		0x01, 0,
+		// row 3: volume only on channel 1
+		0x82, 0x04, 32, 0,
+	}
+
+	m := &ImpulseTracker{}
+	if err := m.Load(buildTestIT([]int{4, 64}, [][]byte{packed, nil})); err != nil {
+		t.Fatalf("Unexpected error loading IT: %v", err)
+	}
+	if m.NumPatterns() != 2 || m.NumChannels() != 3 {
+		t.Fatalf("Expected 2 patterns and 3 channels, got %d,%d", m.NumPatterns(), m.NumChannels())
+	}
+
+	pattern, _ := m.GetPattern(0)
+	if pattern.NumRows() != 4 || pattern.NumChannels() != 64 {
+		t.Fatalf("Expected 4 rows and 64 channels, got %d,%d", pattern.NumRows(), pattern.NumChannels())
+	}
+	for row := 0; row < 3; row++ {
+		r, _ := pattern.GetRow(row)
+		n := r.Notes()[0]
+		if s, _ := n.ToString(); s != "C-5" || n.Instrument() != 1 || !n.HasVolume() || n.Volume() != 0 || n.Effect() != 1 || n.Parameter() != 6 {
+			t.Errorf("Row %d: expected C-5,1,0,1,6 got %s,%d,%d,%d,%d", row, s, n.Instrument(), n.Volume(), n.Effect(), n.Parameter())
+		}
+	}
+	r, _ := pattern.GetRow(0)
+	if r.Notes()[2].Key() != KeyOff {
+		t.Errorf("Expected note off, got %d", r.Notes()[2].Key())
+	}
+	r, _ = pattern.GetRow(3)
+	if n := r.Notes()[1]; n.Key() != 0 || n.Volume() != 32 || !n.HasVolume() {
+		t.Errorf("Expected volume 32 only, got %d,%d", n.Key(), n.Volume())
+	}
+	if n := r.Notes()[0]; !n.IsEmpty() {
+		t.Errorf("Expected empty note on row 3 channel 0")
+	}
+
+	empty, _ := m.GetPattern(1)
+	if empty.NumRows() != 64 {
+		t.Errorf("Expected empty 64 row pattern, got %d rows", empty.NumRows())
+	}
+}
+
+func TestImpulseTrackerTruncatedPattern(t *testing.T) {
+	m := &ImpulseTracker{}
+	if err := m.Load(buildTestIT([]int{4}, [][]byte{{0x81, 0x0f, 60}})); err == nil {
+		t.Errorf("Expected error for truncated pattern data")
+	}
+}
+
+func TestLoadITInstrument(t *testing.T) {
+	data := make([]byte, 554)
+	copy(data[0:4], "IMPI")
+	copy(data[4:16], "PIANO.ITI")
+	data[17] = IT_NNA_NOTE_FADE
+	data[18] = IT_DCT_SAMPLE
+	data[19] = IT_DCA_NOTE_OFF
+	binary.LittleEndian.PutUint16(data[20:22], 512)
+	data[22] = 0xf8	// -8
+	data[23] = 60
+	data[24] = 128
+	data[25] = 0x80 | 32
+	data[26] = 25
+	copy(data[32:58], "Grand piano")
+	for j := 0; j < 120; j++ {
+		data[64+j*2] = byte(j)
+		data[65+j*2] = 1
+	}
+	data[65+60*2] = 2
+
+	// volume envelope with a loop over nodes 1-2
+	copy(data[304:], []byte{IT_ENVELOPE_ON | IT_ENVELOPE_LOOP, 3, 1, 2, 0, 0, 64, 0, 0, 32, 10, 0, 0, 0x2c, 0x01})
+	// pitch envelope used as a filter
+	copy(data[468:], []byte{IT_ENVELOPE_ON | IT_ENVELOPE_FILTER, 2, 0, 0, 0, 0, 0xe0, 0, 0, 32, 100, 0})
+
+	instrument := loadITInstrument(data)
+	if instrument.Name() != "Grand piano" || instrument.NNA() != IT_NNA_NOTE_FADE || instrument.DuplicateCheckType() != IT_DCT_SAMPLE || instrument.DuplicateCheckAction() != IT_DCA_NOTE_OFF {
+		t.Errorf("Unexpected instrument settings")
+	}
+	if instrument.Fadeout() != 512 || instrument.PitchPanSeparation() != -8 || instrument.RandomVolume() != 25 {
+		t.Errorf("Expected 512,-8,25 got %d,%d,%d", instrument.Fadeout(), instrument.PitchPanSeparation(), instrument.RandomVolume())
+	}
+	if _, enabled := instrument.DefaultPan(); enabled {
+		t.Errorf("Expected default pan to be unused")
+	}
+	if entry := instrument.Keyboard()[60]; entry.Note != 60 || entry.Sample != 2 {
+		t.Errorf("Expected keyboard entry 60,2 got %d,%d", entry.Note, entry.Sample)
+	}
+
+	volume := instrument.VolumeEnvelope()
+	if !volume.Enabled() || !volume.HasLoop() || volume.HasSustainLoop() || len(volume.Nodes) != 3 {
+		t.Fatalf("Unexpected volume envelope %+v", volume)
+	}
+	if volume.Nodes[2] != (ITEnvelopeNode{Tick: 300, Value: 0}) || volume.Nodes[1] != (ITEnvelopeNode{Tick: 10, Value: 32}) {
+		t.Errorf("Unexpected volume envelope nodes %+v", volume.Nodes)
+	}
+	pitch := instrument.PitchEnvelope()
+	if !pitch.IsFilter() || pitch.Nodes[0].Value != -32 || pitch.Nodes[1].Tick != 100 {
+		t.Errorf("Unexpected pitch envelope %+v", pitch)
+	}
+	if instrument.PanningEnvelope().Enabled() {
+		t.Errorf("Expected panning envelope to be disabled")
+	}
+}
+
+func TestLoadOldITInstrument(t *testing.T) {
+	data := make([]byte, 554)
+	copy(data[0:4], "IMPI")
+	copy(data[4:16], "STRINGS.ITI")
+	data[17] = IT_ENVELOPE_ON | IT_ENVELOPE_SUSTAIN_LOOP | 0x08	// bit 3 isn't an envelope flag here
+	data[18] = 0	// volume loop start/end
+	data[19] = 2
+	data[20] = 1	// sustain loop start/end
+	data[21] = 1
+	binary.LittleEndian.PutUint16(data[24:26], 100)
+	data[26] = IT_NNA_NOTE_OFF
+	data[27] = 1
+	copy(data[32:58], "Old strings")
+	for j := 0; j < 120; j++ {
+		data[64+j*2] = byte(j)
+		data[65+j*2] = 3
+	}
+	// volume envelope nodes as (tick, value) bytes, ending with a tick of 0xFF
+	copy(data[504:], []byte{0, 64, 20, 48, 200, 0, 0xFF})
+
+	instrument := loadOldITInstrument(data)
+	if instrument.Name() != "Old strings" || instrument.NNA() != IT_NNA_NOTE_OFF || instrument.DuplicateCheckType() != IT_DCT_NOTE {
+		t.Errorf("Unexpected instrument settings")
+	}
+	if instrument.Fadeout() != 200 || instrument.GlobalVolume() != 128 || instrument.PitchPanCenter() != 60 {
+		t.Errorf("Expected 200,128,60 got %d,%d,%d", instrument.Fadeout(), instrument.GlobalVolume(), instrument.PitchPanCenter())
+	}
+	if _, enabled := instrument.DefaultPan(); enabled {
+		t.Errorf("Expected default pan to be unused")
+	}
+	if entry := instrument.Keyboard()[119]; entry.Note != 119 || entry.Sample != 3 {
+		t.Errorf("Expected keyboard entry 119,3 got %d,%d", entry.Note, entry.Sample)
+	}
+
+	volume := instrument.VolumeEnvelope()
+	if volume.Flags != IT_ENVELOPE_ON | IT_ENVELOPE_SUSTAIN_LOOP || volume.LoopEnd != 2 || volume.SustainStart != 1 || volume.SustainEnd != 1 {
+		t.Errorf("Unexpected volume envelope %+v", volume)
+	}
+	if len(volume.Nodes) != 3 || volume.Nodes[1] != (ITEnvelopeNode{Tick: 20, Value: 48}) || volume.Nodes[2] != (ITEnvelopeNode{Tick: 200, Value: 0}) {
+		t.Errorf("Unexpected volume envelope nodes %+v", volume.Nodes)
+	}
+	if instrument.PanningEnvelope().Enabled() || instrument.PitchEnvelope().Enabled() {
+		t.Errorf("Expected only a volume envelope")
+	}
+
+	// a file made for IT versions before 2.00 loads its instruments the old way
+	it := buildTestIT(nil, nil)
+	binary.LittleEndian.PutUint16(it[34:36], 1)
+	binary.LittleEndian.PutUint16(it[42:44], 0x0100)
+	it = binary.LittleEndian.AppendUint32(it, uint32(len(it) + 4))
+	it = append(it, data...)
+	m := &ImpulseTracker{}
+	if err := m.Load(it); err != nil {
+		t.Fatalf("Unexpected error loading IT: %v", err)
+	}
+	if len(m.instruments) != 1 || m.instruments[0].Fadeout() != 200 || len(m.instruments[0].VolumeEnvelope().Nodes) != 3 {
+		t.Errorf("Expected the old instrument layout to be used, got %+v", m.instruments)
+	}
+}
+
+func TestImpulseTrackerSampleSizeLimit(t *testing.T) {
+	// three sample headers sharing one compressed sample, each under the
+	// limit on its own but not together
+	it := buildTestIT(nil, nil)
+	binary.LittleEndian.PutUint16(it[36:38], 3)
+	headerOffset := len(it) + 3*4
+	for i := 0; i < 3; i++ {
+		it = binary.LittleEndian.AppendUint32(it, uint32(headerOffset))
+	}
+	header := make([]byte, 80)
+	copy(header[0:4], "IMPS")
+	header[18] = IT_SAMPLE_ASSOCIATED | IT_SAMPLE_COMPRESSED
+	binary.LittleEndian.PutUint32(header[48:52], 400000)
+	binary.LittleEndian.PutUint32(header[72:76], uint32(headerOffset + 80))
+	it = append(it, header...)
+	it = append(it, 0, 0, 0, 0)
+
+	var limitError *LimitError
+	if _, err := (LoadOptions{MaxSize: 1 << 20}).LoadBytes(it, "song.it"); !errors.As(err, &limitError) || limitError.Limit != "MaxSize" {
+		t.Errorf("Expected MaxSize to be exceeded, got %v", err)
+	}
+	if _, err := (LoadOptions{MaxSize: 2 << 20}).LoadBytes(it, "song.it"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestImpulseTrackerMessage(t *testing.T) {
+	data := buildTestIT(nil, nil)
+	msg := []byte("Hello\rW\x94rld\x00junk")
+	binary.LittleEndian.PutUint16(data[46:48], 1)
+	binary.LittleEndian.PutUint16(data[54:56], uint16(len(msg)))
+	binary.LittleEndian.PutUint32(data[56:60], uint32(len(data)))
+	data = append(data, msg...)
+
+	m := &ImpulseTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if m.Message() != "Hello\nWörld" {
+		t.Errorf("Message = %q, want %q", m.Message(), "Hello\nWörld")
+	}
+
+	// without the special flag the message is ignored
+	binary.LittleEndian.PutUint16(data[46:48], 0)
+	m = &ImpulseTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if m.Message() != "" {
+		t.Errorf("Message = %q, want empty", m.Message())
+	}
+}
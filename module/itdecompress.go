@@ -0,0 +1,197 @@
+package module
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// itBitReader reads little-endian bit fields from a compressed IT sample block
+type itBitReader struct {
+	data []byte
+	pos int
+	bit uint
+}
+
+func (r *itBitReader) readBits(n uint) (uint32, error) {
+	value := uint32(0)
+	for i := uint(0); i < n; i++ {
+		if (r.pos >= len(r.data)) {
+			return 0, errors.New("ran out of compressed data")
+		}
+		value |= uint32((r.data[r.pos] >> r.bit) & 1) << i
+		r.bit++
+		if (r.bit == 8) {
+			r.bit = 0
+			r.pos++
+		}
+	}
+	return value, nil
+}
+
+// decompressIT8Bit decompresses numSamples 8-bit samples from IT214 (or, if
+// it215 is set, IT215) compressed data. It returns the signed PCM data along
+// with the number of compressed bytes consumed, so that the right channel of a
+// stereo sample can be decompressed from where the left one ends.
+//
+// The data is split into blocks of up to 0x8000 samples, each prefixed with
+// its compressed length. Within a block each delta value is stored with a bit
+// width that starts at 9 and is changed by special values:
+//
+//	width 1-6: the value 1 << (width-1) is followed by 3 bits giving the new width
+//	width 7-8: values just below the top of the range give the new width
+//	width 9: values with bit 8 set give the new width in the lower bits
+func decompressIT8Bit(data []byte, numSamples int, it215 bool) ([]byte, int, error) {
+	r := make([]byte, 0, numSamples)
+	offset := 0
+	for len(r) < numSamples {
+		block, err := nextITBlock(data, &offset)
+		if err != nil {
+			return r, offset, err
+		}
+		blockLength := numSamples - len(r)
+		if (blockLength > 0x8000) {
+			blockLength = 0x8000
+		}
+
+		width := uint(9)
+		d1, d2 := int8(0), int8(0)
+		for blockPos := 0; blockPos < blockLength; {
+			value, err := block.readBits(width)
+			if err != nil {
+				return r, offset, err
+			}
+
+			if (width < 7) {
+				if (value == 1 << (width-1)) {
+					value, err = block.readBits(3)
+					if err != nil {
+						return r, offset, err
+					}
+					width = changeITWidth(uint(value+1), width)
+					continue
+				}
+			} else if (width < 9) {
+				border := (uint32(0xFF) >> (9 - width)) - 4
+				if (value > border && value <= border + 8) {
+					width = changeITWidth(uint(value-border), width)
+					continue
+				}
+			} else if (width == 9) {
+				if (value & 0x100) != 0 {
+					width = uint((value + 1) & 0xFF)
+					continue
+				}
+			} else {
+				return r, offset, errors.New(fmt.Sprintf("invalid bit width %d", width))
+			}
+
+			// sign extend the delta value from its bit width
+			v := int8(value)
+			if (width < 8) {
+				shift := 8 - width
+				v = int8(value << shift) >> shift
+			}
+			d1 += v
+			d2 += d1
+			if it215 {
+				r = append(r, byte(d2))
+			} else {
+				r = append(r, byte(d1))
+			}
+			blockPos++
+		}
+	}
+	return r, offset, nil
+}
+
+// decompressIT16Bit works as decompressIT8Bit, but for 16-bit samples with
+// blocks of up to 0x4000 samples and an initial bit width of 17. The PCM data
+// is returned as signed little-endian values.
+func decompressIT16Bit(data []byte, numSamples int, it215 bool) ([]byte, int, error) {
+	r := make([]byte, 0, numSamples*2)
+	offset := 0
+	for len(r) < numSamples*2 {
+		block, err := nextITBlock(data, &offset)
+		if err != nil {
+			return r, offset, err
+		}
+		blockLength := numSamples - len(r)/2
+		if (blockLength > 0x4000) {
+			blockLength = 0x4000
+		}
+
+		width := uint(17)
+		d1, d2 := int16(0), int16(0)
+		for blockPos := 0; blockPos < blockLength; {
+			value, err := block.readBits(width)
+			if err != nil {
+				return r, offset, err
+			}
+
+			if (width < 7) {
+				if (value == 1 << (width-1)) {
+					value, err = block.readBits(4)
+					if err != nil {
+						return r, offset, err
+					}
+					width = changeITWidth(uint(value+1), width)
+					continue
+				}
+			} else if (width < 17) {
+				border := (uint32(0xFFFF) >> (17 - width)) - 8
+				if (value > border && value <= border + 16) {
+					width = changeITWidth(uint(value-border), width)
+					continue
+				}
+			} else if (width == 17) {
+				if (value & 0x10000) != 0 {
+					width = uint((value + 1) & 0xFF)
+					continue
+				}
+			} else {
+				return r, offset, errors.New(fmt.Sprintf("invalid bit width %d", width))
+			}
+
+			v := int16(value)
+			if (width < 16) {
+				shift := 16 - width
+				v = int16(value << shift) >> shift
+			}
+			d1 += v
+			d2 += d1
+			if it215 {
+				r = binary.LittleEndian.AppendUint16(r, uint16(d2))
+			} else {
+				r = binary.LittleEndian.AppendUint16(r, uint16(d1))
+			}
+			blockPos++
+		}
+	}
+	return r, offset, nil
+}
+
+// nextITBlock reads the compressed block starting at offset, moving offset past it
+func nextITBlock(data []byte, offset *int) (*itBitReader, error) {
+	if (*offset + 2 > len(data)) {
+		return nil, errors.New("ran out of compressed data")
+	}
+	blockSize := int(binary.LittleEndian.Uint16(data[*offset:*offset+2]))
+	*offset += 2
+	end := *offset + blockSize
+	if (end > len(data)) {
+		end = len(data)
+	}
+	block := &itBitReader{data: data[*offset:end]}
+	*offset = end
+	return block, nil
+}
+
+// changeITWidth gives the new bit width, skipping over the current width since
+// that is never a valid change
+func changeITWidth(value uint, width uint) uint {
+	if (value < width) {
+		return value
+	}
+	return value + 1
+}
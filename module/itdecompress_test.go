@@ -0,0 +1,92 @@
+package module
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testBitWriter packs values LSB first, the way IT compressed samples are stored
+type testBitWriter struct {
+	data []byte
+	bits uint
+}
+
+func (w *testBitWriter) write(value uint32, width uint) {
+	for i := uint(0); i < width; i++ {
+		if (w.bits % 8 == 0) {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte((value >> i) & 1) << (w.bits % 8)
+		w.bits++
+	}
+}
+
+// block returns the packed data prefixed with its block length
+func (w *testBitWriter) block() []byte {
+	r := make([]byte, 2)
+	binary.LittleEndian.PutUint16(r, uint16(len(w.data)))
+	return append(r, w.data...)
+}
+
+func TestDecompressIT8Bit(t *testing.T) {
+	w := &testBitWriter{}
+	w.write(5, 9)
+	w.write(0xFD, 9)	// -3
+	w.write(0x100 | 3, 9)	// change to 4 bits
+	w.write(2, 4)
+	w.write(0xF, 4)	// -1
+	w.write(8, 4)	// change width, 3 bits follow
+	w.write(6, 3)	// to 8 bits (7 + 1, skipping the current width)
+	w.write(0x9C, 8)	// -100
+
+	expected := []int8{5, 2, 4, 3, -97}
+	data, _, err := decompressIT8Bit(w.block(), len(expected), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, v := range expected {
+		if (int8(data[i]) != v) {
+			t.Errorf("Sample %d: expected %d, got %d", i, v, int8(data[i]))
+		}
+	}
+
+	// IT215 integrates twice
+	expected215 := []int8{5, 7, 11, 14, -83}
+	data, _, err = decompressIT8Bit(w.block(), len(expected), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, v := range expected215 {
+		if (int8(data[i]) != v) {
+			t.Errorf("IT215 sample %d: expected %d, got %d", i, v, int8(data[i]))
+		}
+	}
+
+	if _, _, err := decompressIT8Bit(w.block(), 10, false); err == nil {
+		t.Errorf("Expected error when running out of compressed data")
+	}
+}
+
+func TestDecompressIT16Bit(t *testing.T) {
+	w := &testBitWriter{}
+	w.write(1000, 17)
+	w.write(0x10000 | 7, 17)	// change to 8 bits
+	w.write(100, 8)
+	w.write(0x9C, 8)	// -100
+	w.write(119 + 9, 8)	// change to 10 bits (9 + 1, skipping the current width)
+	w.write(0x3FF, 10)	// -1
+
+	expected := []int16{1000, 1100, 1000, 999}
+	data, _, err := decompressIT16Bit(w.block(), len(expected), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(data) != len(expected)*2 {
+		t.Fatalf("Expected %d bytes, got %d", len(expected)*2, len(data))
+	}
+	for i, v := range expected {
+		if got := int16(binary.LittleEndian.Uint16(data[i*2:])); got != v {
+			t.Errorf("Sample %d: expected %d, got %d", i, v, got)
+		}
+	}
+}
@@ -1,8 +1,97 @@
 package module
 
+// New Note Actions
+const (
+	IT_NNA_CUT = iota
+	IT_NNA_CONTINUE
+	IT_NNA_NOTE_OFF
+	IT_NNA_NOTE_FADE
+)
+
+// Duplicate Check Types
+const (
+	IT_DCT_OFF = iota
+	IT_DCT_NOTE
+	IT_DCT_SAMPLE
+	IT_DCT_INSTRUMENT
+)
+
+// Duplicate Check Actions
+const (
+	IT_DCA_CUT = iota
+	IT_DCA_NOTE_OFF
+	IT_DCA_NOTE_FADE
+)
+
+// Envelope flags
+const (
+	IT_ENVELOPE_ON = 1 << iota
+	IT_ENVELOPE_LOOP
+	IT_ENVELOPE_SUSTAIN_LOOP
+	IT_ENVELOPE_CARRY
+	IT_ENVELOPE_FILTER = 0x80
+)
+
+// ITEnvelopeNode is a single envelope point. Volume envelopes range from 0 to
+// 64, panning and pitch/filter envelopes from -32 to 32.
+type ITEnvelopeNode struct {
+	Tick uint16
+	Value int8
+}
+
+// ITEnvelope is a volume, panning or pitch/filter envelope of up to 25 nodes.
+// Loop and sustain loop positions are indexes into Nodes.
+type ITEnvelope struct {
+	Nodes []ITEnvelopeNode
+	Flags uint8
+	LoopStart uint8
+	LoopEnd uint8
+	SustainStart uint8
+	SustainEnd uint8
+}
+
+func (e ITEnvelope) Enabled() bool {
+	return (e.Flags & IT_ENVELOPE_ON) != 0
+}
+
+func (e ITEnvelope) HasLoop() bool {
+	return (e.Flags & IT_ENVELOPE_LOOP) != 0
+}
+
+func (e ITEnvelope) HasSustainLoop() bool {
+	return (e.Flags & IT_ENVELOPE_SUSTAIN_LOOP) != 0
+}
+
+// IsFilter reports whether a pitch envelope controls the filter cutoff instead of pitch
+func (e ITEnvelope) IsFilter() bool {
+	return (e.Flags & IT_ENVELOPE_FILTER) != 0
+}
+
+// ITKeyboardEntry maps a note played with the instrument to the note and sample actually played
+type ITKeyboardEntry struct {
+	Note uint8
+	Sample uint8
+}
+
 type ITInstrument struct {
 	name string
 	filename string
+	nna uint8
+	dct uint8
+	dca uint8
+	fadeout uint16
+	pitchPanSeparation int8
+	pitchPanCenter uint8
+	globalVolume uint8
+	defaultPan uint8
+	randomVolume uint8
+	randomPan uint8
+	filterCutoff uint8
+	filterResonance uint8
+	keyboard [120]ITKeyboardEntry
+	volumeEnvelope ITEnvelope
+	panningEnvelope ITEnvelope
+	pitchEnvelope ITEnvelope
 	data []byte
 	Instrument
 }
@@ -22,3 +111,79 @@ func (i ITInstrument) Load(data []byte) (error) {
 func (i ITInstrument) Data() []byte {
 	return i.data
 }
+
+// NNA returns the New Note Action, one of the IT_NNA_* values
+func (i ITInstrument) NNA() uint8 {
+	return i.nna
+}
+
+// DuplicateCheckType returns one of the IT_DCT_* values
+func (i ITInstrument) DuplicateCheckType() uint8 {
+	return i.dct
+}
+
+// DuplicateCheckAction returns one of the IT_DCA_* values
+func (i ITInstrument) DuplicateCheckAction() uint8 {
+	return i.dca
+}
+
+func (i ITInstrument) Fadeout() uint16 {
+	return i.fadeout
+}
+
+// PitchPanSeparation returns how far panning moves per note away from the pitch-pan center, -32 to 32
+func (i ITInstrument) PitchPanSeparation() int8 {
+	return i.pitchPanSeparation
+}
+
+// PitchPanCenter returns the note (0 = C-0) around which pitch-pan separation applies
+func (i ITInstrument) PitchPanCenter() uint8 {
+	return i.pitchPanCenter
+}
+
+// GlobalVolume returns the instrument's global volume, 0-128
+func (i ITInstrument) GlobalVolume() uint8 {
+	return i.globalVolume
+}
+
+// DefaultPan returns the instrument's default pan, 0-64, and whether it should be used
+func (i ITInstrument) DefaultPan() (uint8, bool) {
+	return i.defaultPan & 0x7F, (i.defaultPan & 0x80) == 0
+}
+
+// RandomVolume returns the random volume variation, as a percentage
+func (i ITInstrument) RandomVolume() uint8 {
+	return i.randomVolume
+}
+
+// RandomPan returns the random pan variation
+func (i ITInstrument) RandomPan() uint8 {
+	return i.randomPan
+}
+
+// FilterCutoff returns the initial filter cutoff, 0-127, and whether it is set
+func (i ITInstrument) FilterCutoff() (uint8, bool) {
+	return i.filterCutoff & 0x7F, (i.filterCutoff & 0x80) != 0
+}
+
+// FilterResonance returns the initial filter resonance, 0-127, and whether it is set
+func (i ITInstrument) FilterResonance() (uint8, bool) {
+	return i.filterResonance & 0x7F, (i.filterResonance & 0x80) != 0
+}
+
+// Keyboard returns the note and sample to play for each of the 120 notes
+func (i ITInstrument) Keyboard() [120]ITKeyboardEntry {
+	return i.keyboard
+}
+
+func (i ITInstrument) VolumeEnvelope() ITEnvelope {
+	return i.volumeEnvelope
+}
+
+func (i ITInstrument) PanningEnvelope() ITEnvelope {
+	return i.panningEnvelope
+}
+
+func (i ITInstrument) PitchEnvelope() ITEnvelope {
+	return i.pitchEnvelope
+}
@@ -1,5 +1,27 @@
 package module
 
+// Sample flags
+const (
+	IT_SAMPLE_ASSOCIATED = 1 << iota
+	IT_SAMPLE_16BIT
+	IT_SAMPLE_STEREO
+	IT_SAMPLE_COMPRESSED
+	IT_SAMPLE_LOOP
+	IT_SAMPLE_SUSTAIN_LOOP
+	IT_SAMPLE_PINGPONG_LOOP
+	IT_SAMPLE_PINGPONG_SUSTAIN
+)
+
+// Convert flags
+const (
+	IT_CONVERT_SIGNED = 1 << iota
+	IT_CONVERT_BIG_ENDIAN
+	IT_CONVERT_DELTA
+	IT_CONVERT_BYTE_DELTA
+	IT_CONVERT_TX_WAVE
+	IT_CONVERT_STEREO_PROMPT
+)
+
 type ITSample struct {
 	name string
 	filename string
@@ -9,6 +31,15 @@ type ITSample struct {
 	sustainStart uint32
 	sustainEnd uint32
 	c5speed uint32
+	flags uint8
+	convert uint8
+	globalVolume uint8
+	volume uint8
+	defaultPan uint8
+	vibratoSpeed uint8
+	vibratoDepth uint8
+	vibratoRate uint8
+	vibratoType uint8
 	data []byte
 }
 
@@ -24,6 +55,165 @@ func (i ITSample) Load(data []byte) (error) {
 	return nil
 }
 
+// Data returns the sample as signed PCM. 16-bit samples are little-endian and
+// stereo samples have their channels interleaved, left first.
 func (i ITSample) Data() []byte {
-	return i.data
+	r := make([]byte, len(i.data))
+	copy(r, i.data)
+
+	// compressed samples have already been decompressed to signed little-endian PCM
+	if !i.IsCompressed() {
+		if i.Is16Bit() && (i.convert & IT_CONVERT_BIG_ENDIAN) != 0 {
+			for j := 0; j+1 < len(r); j += 2 {
+				r[j], r[j+1] = r[j+1], r[j]
+			}
+		}
+		if (i.convert & IT_CONVERT_DELTA) != 0 {
+			if i.Is16Bit() {
+				r = decode16Bit(r)
+			} else {
+				r = decode8Bit(r)
+			}
+		}
+		if !i.IsSigned() {
+			for j := range r {
+				// flip the sign bit, which is the high byte for 16-bit little-endian data
+				if !i.Is16Bit() || j % 2 == 1 {
+					r[j] += 128
+				}
+			}
+		}
+	}
+
+	if i.IsStereo() {
+		r = interleave(r, i.BytesPerFrame() / 2)
+	}
+	return r
+}
+
+// interleave converts a block of left channel data followed by a block of
+// right channel data into alternating left/right values of the given size
+func interleave(data []byte, valueSize int) []byte {
+	r := make([]byte, len(data))
+	half := (len(data) / 2 / valueSize) * valueSize
+	for j := 0; j < half; j += valueSize {
+		copy(r[j*2:j*2+valueSize], data[j:j+valueSize])
+		copy(r[j*2+valueSize:j*2+valueSize*2], data[half+j:half+j+valueSize])
+	}
+	return r
+}
+
+// BytesPerFrame returns the size of a single sample value across all channels
+func (i ITSample) BytesPerFrame() int {
+	size := 1
+	if i.Is16Bit() {
+		size = 2
+	}
+	if i.IsStereo() {
+		size *= 2
+	}
+	return size
+}
+
+func (i ITSample) Flags() uint8 {
+	return i.flags
+}
+
+func (i ITSample) Convert() uint8 {
+	return i.convert
+}
+
+// HasData reports whether the header has sample data associated with it
+func (i ITSample) HasData() bool {
+	return (i.flags & IT_SAMPLE_ASSOCIATED) != 0
+}
+
+func (i ITSample) Is16Bit() bool {
+	return (i.flags & IT_SAMPLE_16BIT) != 0
+}
+
+func (i ITSample) IsStereo() bool {
+	return (i.flags & IT_SAMPLE_STEREO) != 0
+}
+
+func (i ITSample) IsCompressed() bool {
+	return (i.flags & IT_SAMPLE_COMPRESSED) != 0
+}
+
+func (i ITSample) HasLoop() bool {
+	return (i.flags & IT_SAMPLE_LOOP) != 0
+}
+
+func (i ITSample) HasSustainLoop() bool {
+	return (i.flags & IT_SAMPLE_SUSTAIN_LOOP) != 0
+}
+
+func (i ITSample) IsPingPongLoop() bool {
+	return (i.flags & IT_SAMPLE_PINGPONG_LOOP) != 0
+}
+
+func (i ITSample) IsPingPongSustain() bool {
+	return (i.flags & IT_SAMPLE_PINGPONG_SUSTAIN) != 0
+}
+
+// IsSigned reports whether the stored sample data is signed, Data always returns signed PCM
+func (i ITSample) IsSigned() bool {
+	return (i.convert & IT_CONVERT_SIGNED) != 0 || i.IsCompressed()
+}
+
+// GlobalVolume returns the sample's global volume, 0-64
+func (i ITSample) GlobalVolume() uint8 {
+	return i.globalVolume
+}
+
+// Volume returns the sample's default volume, 0-64
+func (i ITSample) Volume() uint8 {
+	return i.volume
+}
+
+// DefaultPan returns the sample's default pan, 0-64, and whether it should be used
+func (i ITSample) DefaultPan() (uint8, bool) {
+	return i.defaultPan & 0x7F, (i.defaultPan & 0x80) != 0
+}
+
+func (i ITSample) VibratoSpeed() uint8 {
+	return i.vibratoSpeed
+}
+
+func (i ITSample) VibratoDepth() uint8 {
+	return i.vibratoDepth
+}
+
+func (i ITSample) VibratoRate() uint8 {
+	return i.vibratoRate
+}
+
+// VibratoType returns the vibrato waveform: 0=sine, 1=ramp down, 2=square, 3=random
+func (i ITSample) VibratoType() uint8 {
+	return i.vibratoType
+}
+
+// Length returns the sample length in sample frames, not bytes
+func (i ITSample) Length() uint32 {
+	return i.length
+}
+
+func (i ITSample) LoopStart() uint32 {
+	return i.loopStart
+}
+
+func (i ITSample) LoopEnd() uint32 {
+	return i.loopEnd
+}
+
+func (i ITSample) SustainStart() uint32 {
+	return i.sustainStart
+}
+
+func (i ITSample) SustainEnd() uint32 {
+	return i.sustainEnd
+}
+
+func (i ITSample) C5Speed() uint32 {
+	return i.c5speed
 }
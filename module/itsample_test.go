@@ -0,0 +1,56 @@
+package module
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestITSampleData(t *testing.T) {
+	tests := []struct {
+		name string
+		sample ITSample
+		expected []byte
+	}{
+		{
+			"unsigned 8-bit",
+			ITSample{flags: IT_SAMPLE_ASSOCIATED, data: []byte{0x80, 0xff, 0x00}},
+			[]byte{0x00, 0x7f, 0x80},
+		},
+		{
+			"signed delta 8-bit",
+			ITSample{flags: IT_SAMPLE_ASSOCIATED, convert: IT_CONVERT_SIGNED | IT_CONVERT_DELTA, data: []byte{10, 5, 0xfe}},
+			[]byte{10, 15, 13},
+		},
+		{
+			"unsigned big-endian 16-bit",
+			ITSample{flags: IT_SAMPLE_ASSOCIATED | IT_SAMPLE_16BIT, convert: IT_CONVERT_BIG_ENDIAN, data: []byte{0x80, 0x01, 0x7f, 0xff}},
+			[]byte{0x01, 0x00, 0xff, 0xff},
+		},
+		{
+			"signed stereo 16-bit",
+			ITSample{flags: IT_SAMPLE_ASSOCIATED | IT_SAMPLE_16BIT | IT_SAMPLE_STEREO, convert: IT_CONVERT_SIGNED, data: []byte{1, 0, 2, 0, 3, 0, 4, 0}},
+			[]byte{1, 0, 3, 0, 2, 0, 4, 0},
+		},
+		{
+			"compressed stereo 8-bit",
+			ITSample{flags: IT_SAMPLE_ASSOCIATED | IT_SAMPLE_COMPRESSED | IT_SAMPLE_STEREO, data: []byte{1, 2, 3, 4}},
+			[]byte{1, 3, 2, 4},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.sample.Data(); !bytes.Equal(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestITSampleFlags(t *testing.T) {
+	s := ITSample{flags: IT_SAMPLE_ASSOCIATED | IT_SAMPLE_LOOP | IT_SAMPLE_PINGPONG_SUSTAIN, defaultPan: 0x80 | 32}
+	if !s.HasData() || !s.HasLoop() || s.HasSustainLoop() || !s.IsPingPongSustain() || s.IsSigned() {
+		t.Errorf("Unexpected flags decoded from %x", s.Flags())
+	}
+	if pan, enabled := s.DefaultPan(); pan != 32 || !enabled {
+		t.Errorf("Expected pan 32 enabled, got %d,%v", pan, enabled)
+	}
+}
@@ -0,0 +1,453 @@
+package module
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LHA archives, as made by LhA on the Amiga and LHarc on DOS. Each file is a
+// header followed by its compressed data, and the archive ends with a zero
+// byte. Only the stored (lh0) and LZSS with static Huffman (lh5, lh6 and lh7)
+// methods are supported, which covers nearly every archive made since the
+// early 90s.
+
+// lhaDictionaryBits gives the log2 of the window size for each supported method
+var lhaDictionaryBits = map[string]uint{
+	"-lh5-": 13,
+	"-lh6-": 15,
+	"-lh7-": 16,
+}
+
+// isLHA checks for the method of the first header, e.g. "-lh5-"
+func isLHA(data []byte) bool {
+	if (len(data) < 22 || data[20] > 2) {
+		return false
+	}
+	method := data[2:7]
+	return method[0] == '-' && method[1] == 'l' && (method[2] == 'h' || method[2] == 'z') && method[4] == '-'
+}
+
+// lhaHeader is the part of an LHA file header needed to extract it
+type lhaHeader struct {
+	method string
+	name string
+	compressedSize int
+	originalSize int
+	crc uint16
+	dataOffset int
+}
+
+// lhaMembers lists the files in an LHA archive
+func lhaMembers(data []byte, options LoadOptions) ([]archiveMember, error) {
+	r := reader{format: "lha", data: data}
+	members := make([]archiveMember, 0)
+	offset := 0
+	for offset < len(data) && data[offset] != 0 {
+		if err := checkLimit("MaxEntries", int64(len(members)+1), int64(options.MaxEntries)); err != nil {
+			return nil, err
+		}
+		header, err := readLHAHeader(r, offset, len(members))
+		if err != nil {
+			return nil, err
+		}
+		field := fmt.Sprintf("file[%d].data", len(members))
+		compressed, err := r.slice(field, header.dataOffset, header.compressedSize)
+		if err != nil {
+			return nil, err
+		}
+		offset = header.dataOffset + header.compressedSize
+
+		if (header.method == "-lhd-") {
+			members = append(members, archiveMember{name: header.name, dir: true})
+			continue
+		}
+		dataOffset := header.dataOffset
+		members = append(members, archiveMember{
+			name: header.name,
+			read: func() ([]byte, error) {
+				if err := checkLimit("MaxSize", int64(header.originalSize), options.MaxSize); err != nil {
+					return nil, err
+				}
+				out, err := decodeLHA(header.method, compressed, header.originalSize)
+				if err != nil {
+					return nil, r.wrap(field, dataOffset, err)
+				}
+				if (lhaCRC(out) != header.crc) {
+					return nil, r.wrap(field, dataOffset, errors.New("CRC mismatch"))
+				}
+				return out, nil
+			},
+		})
+	}
+	return members, nil
+}
+
+// readLHAHeader reads the level 0, 1 or 2 file header at offset
+func readLHAHeader(r reader, offset int, index int) (lhaHeader, error) {
+	field := fmt.Sprintf("file[%d].header", index)
+	base, err := r.slice(field, offset, 22)
+	if err != nil {
+		return lhaHeader{}, err
+	}
+	header := lhaHeader{
+		method: string(base[2:7]),
+		compressedSize: int(binary.LittleEndian.Uint32(base[7:11])),
+		originalSize: int(binary.LittleEndian.Uint32(base[11:15])),
+	}
+	level := base[20]
+
+	var nextSize, extOffset, extEnd int
+	var directory string
+	headerCRC, headerCRCOffset := -1, 0
+	switch level {
+	case 0, 1:
+		headerSize := int(base[0]) + 2
+		// the fixed fields, an empty name and the CRC
+		if (headerSize < 24) {
+			return lhaHeader{}, r.invalid(field, offset, headerSize)
+		}
+		data, err := r.slice(field, offset, headerSize)
+		if err != nil {
+			return lhaHeader{}, err
+		}
+		checksum := byte(0)
+		for _, b := range data[2:] {
+			checksum += b
+		}
+		if (checksum != base[1]) {
+			return lhaHeader{}, r.wrap(field, offset, errors.New("header checksum mismatch"))
+		}
+		nameLength := int(data[21])
+		if (22 + nameLength + 2 > headerSize) {
+			return lhaHeader{}, r.invalid(field, offset + 21, nameLength)
+		}
+		header.name = strings.ReplaceAll(string(data[22:22+nameLength]), "\\", "/")
+		header.crc = binary.LittleEndian.Uint16(data[22+nameLength:])
+		header.dataOffset = offset + headerSize
+		if (level == 1) {
+			if (22 + nameLength + 5 > headerSize) {
+				return lhaHeader{}, r.invalid(field, offset, headerSize)
+			}
+			nextSize = int(binary.LittleEndian.Uint16(data[22+nameLength+3:]))
+			extOffset = header.dataOffset
+			extEnd = len(r.data)
+		}
+	case 2:
+		headerSize := int(binary.LittleEndian.Uint16(base[0:2]))
+		data, err := r.slice(field, offset, headerSize)
+		if (err != nil || headerSize < 26) {
+			return lhaHeader{}, r.invalid(field, offset, headerSize)
+		}
+		header.crc = binary.LittleEndian.Uint16(data[21:23])
+		nextSize = int(binary.LittleEndian.Uint16(data[24:26]))
+		extOffset = offset + 26
+		header.dataOffset = offset + headerSize
+		// level 2 counts the extended headers in the header size
+		extEnd = header.dataOffset
+	default:
+		return lhaHeader{}, r.invalid(field, offset + 20, level)
+	}
+
+	// extended headers: a type byte, the data, then the size of the next one
+	for nextSize != 0 {
+		ext, err := r.slice(field, extOffset, nextSize)
+		if (err != nil || nextSize < 3 || extOffset + nextSize > extEnd) {
+			return lhaHeader{}, r.invalid(field, extOffset, nextSize)
+		}
+		switch ext[0] {
+		case 0x00:
+			if (nextSize >= 5) {
+				headerCRC = int(binary.LittleEndian.Uint16(ext[1:3]))
+				headerCRCOffset = extOffset + 1
+			}
+		case 0x01:
+			header.name = string(ext[1:nextSize-2])
+		case 0x02:
+			directory = strings.ReplaceAll(string(ext[1:nextSize-2]), "\xff", "/")
+		}
+		extOffset += nextSize
+		if (level == 1) {
+			// level 1 counts the extended headers in the compressed size
+			header.compressedSize -= nextSize
+			header.dataOffset += nextSize
+		}
+		nextSize = int(binary.LittleEndian.Uint16(ext[nextSize-2:]))
+	}
+	if (header.compressedSize < 0) {
+		return lhaHeader{}, r.invalid(field, offset + 7, header.compressedSize)
+	}
+	if (headerCRC >= 0) {
+		if (headerCRCOffset + 2 > header.dataOffset) {
+			return lhaHeader{}, r.invalid(field, headerCRCOffset, headerCRC)
+		}
+		// the CRC covers the whole header, with the CRC itself taken as zero
+		crc := updateLHACRC(0, r.data[offset:headerCRCOffset])
+		crc = updateLHACRC(crc, []byte{0, 0})
+		crc = updateLHACRC(crc, r.data[headerCRCOffset+2:header.dataOffset])
+		if (int(crc) != headerCRC) {
+			return lhaHeader{}, r.wrap(field, offset, errors.New("header CRC mismatch"))
+		}
+	}
+	if (directory != "") {
+		header.name = strings.TrimSuffix(directory, "/") + "/" + header.name
+	}
+	return header, nil
+}
+
+// lhaCRC is the CRC-16 used by LHA, with the polynomial 0xA001
+func lhaCRC(data []byte) uint16 {
+	return updateLHACRC(0, data)
+}
+
+func updateLHACRC(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if (crc & 1 != 0) {
+				crc = crc >> 1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// decodeLHA decompresses originalSize bytes of data stored with method
+func decodeLHA(method string, data []byte, originalSize int) ([]byte, error) {
+	if (method == "-lh0-" || method == "-lz4-") {
+		if (len(data) != originalSize) {
+			return nil, errors.New(fmt.Sprintf("stored size %d doesn't match original size %d", len(data), originalSize))
+		}
+		return data, nil
+	}
+	dictionaryBits, ok := lhaDictionaryBits[method]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported LHA method %s", method))
+	}
+	return decodeLH5(data, originalSize, dictionaryBits)
+}
+
+const (
+	// lhaNumCodes is the number of literal and match length codes
+	lhaNumCodes = 256 + 256 - 2
+	// lhaNumLengthCodes is the number of codes used to send the literal code lengths
+	lhaNumLengthCodes = 19
+	// lhaMinMatch is the shortest match, sent as code 256
+	lhaMinMatch = 3
+)
+
+// lhaBitReader reads MSB-first bits, giving zeros past the end of the data
+type lhaBitReader struct {
+	data []byte
+	pos int
+	bit uint
+}
+
+func (r *lhaBitReader) readBits(n uint) int {
+	value := 0
+	for i := uint(0); i < n; i++ {
+		value <<= 1
+		if (r.pos < len(r.data)) {
+			value |= int(r.data[r.pos] >> (7 - r.bit)) & 1
+		}
+		r.bit++
+		if (r.bit == 8) {
+			r.bit = 0
+			r.pos++
+		}
+	}
+	return value
+}
+
+// lhaHuffman decodes canonical Huffman codes, where shorter codes come first
+// and codes of the same length are in symbol order
+type lhaHuffman struct {
+	counts [17]int
+	symbols []int
+	// single is the only symbol when the table was sent as one code of no bits
+	single int
+}
+
+// newLHAHuffman builds a decoder from the code length of each symbol
+func newLHAHuffman(lengths []int) (*lhaHuffman, error) {
+	h := &lhaHuffman{single: -1}
+	for _, length := range lengths {
+		if (length > 16) {
+			return nil, errors.New(fmt.Sprintf("code length %d is too long", length))
+		}
+		h.counts[length]++
+	}
+	h.counts[0] = 0
+	left := 1
+	for length := 1; length <= 16; length++ {
+		left = left << 1 - h.counts[length]
+		if (left < 0) {
+			return nil, errors.New("over-subscribed code lengths")
+		}
+	}
+	for length := 1; length <= 16; length++ {
+		for symbol, l := range lengths {
+			if (l == length) {
+				h.symbols = append(h.symbols, symbol)
+			}
+		}
+	}
+	return h, nil
+}
+
+func (h *lhaHuffman) decode(r *lhaBitReader) (int, error) {
+	if (h.single >= 0) {
+		return h.single, nil
+	}
+	code, first, index := 0, 0, 0
+	for length := 1; length <= 16; length++ {
+		code |= r.readBits(1)
+		count := h.counts[length]
+		if (code - first < count) {
+			return h.symbols[index + code - first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errors.New("invalid Huffman code")
+}
+
+// readLHAPTLengths reads the code lengths of the table used for match
+// distances, or for sending the literal code lengths. If special is set, the
+// third length is followed by a count of extra zero lengths.
+func readLHAPTLengths(r *lhaBitReader, numSymbols int, countBits uint, special int) (*lhaHuffman, error) {
+	n := r.readBits(countBits)
+	if (n == 0) {
+		h := &lhaHuffman{single: r.readBits(countBits)}
+		if (h.single >= numSymbols) {
+			return nil, errors.New(fmt.Sprintf("invalid code %d", h.single))
+		}
+		return h, nil
+	}
+	if (n > numSymbols) {
+		return nil, errors.New(fmt.Sprintf("invalid code count %d", n))
+	}
+	lengths := make([]int, numSymbols)
+	for i := 0; i < n; {
+		length := r.readBits(3)
+		if (length == 7) {
+			for r.readBits(1) == 1 {
+				length++
+				if (length > 16) {
+					return nil, errors.New("code length is too long")
+				}
+			}
+		}
+		lengths[i] = length
+		i++
+		if (i == special) {
+			// lengths beyond the table count as zero
+			i += r.readBits(2)
+		}
+	}
+	return newLHAHuffman(lengths)
+}
+
+// readLHACLengths reads the code lengths of the literal and match length table,
+// which are themselves sent with the codes of lengthTable
+func readLHACLengths(r *lhaBitReader, lengthTable *lhaHuffman) (*lhaHuffman, error) {
+	n := r.readBits(9)
+	if (n == 0) {
+		h := &lhaHuffman{single: r.readBits(9)}
+		if (h.single >= lhaNumCodes) {
+			return nil, errors.New(fmt.Sprintf("invalid code %d", h.single))
+		}
+		return h, nil
+	}
+	if (n > lhaNumCodes) {
+		return nil, errors.New(fmt.Sprintf("invalid code count %d", n))
+	}
+	lengths := make([]int, lhaNumCodes)
+	for i := 0; i < n; {
+		c, err := lengthTable.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		// codes 0-2 are runs of zero lengths, the rest are lengths plus two
+		switch c {
+		case 0:
+			i++
+		case 1:
+			i += r.readBits(4) + 3
+		case 2:
+			i += r.readBits(9) + 20
+		default:
+			lengths[i] = c - 2
+			i++
+		}
+	}
+	return newLHAHuffman(lengths)
+}
+
+// decodeLH5 decompresses the lh5, lh6 and lh7 methods, which differ only in
+// their window size. The data is a series of blocks, each starting with its
+// number of codes and the Huffman tables used to send them.
+func decodeLH5(data []byte, originalSize int, dictionaryBits uint) ([]byte, error) {
+	numPositions := int(dictionaryBits) + 1
+	positionBits := uint(4)
+	if (dictionaryBits > 13) {
+		positionBits = 5
+	}
+
+	r := &lhaBitReader{data: data}
+	out := make([]byte, 0, originalSize)
+	var codes, positions *lhaHuffman
+	blockSize := 0
+	for len(out) < originalSize {
+		if (r.pos > len(data)) {
+			return nil, ErrTruncated
+		}
+		if (blockSize == 0) {
+			blockSize = r.readBits(16)
+			if (blockSize == 0) {
+				return nil, errors.New("empty block")
+			}
+			lengthTable, err := readLHAPTLengths(r, lhaNumLengthCodes, 5, 3)
+			if err != nil {
+				return nil, err
+			}
+			if codes, err = readLHACLengths(r, lengthTable); err != nil {
+				return nil, err
+			}
+			if positions, err = readLHAPTLengths(r, numPositions, positionBits, -1); err != nil {
+				return nil, err
+			}
+		}
+		blockSize--
+
+		c, err := codes.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if (c < 256) {
+			out = append(out, byte(c))
+			continue
+		}
+		length := c - 256 + lhaMinMatch
+		// the distance is sent as its bit length, then the bits below the top one
+		distance, err := positions.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if (distance > 1) {
+			distance = 1 << (distance - 1) + r.readBits(uint(distance - 1))
+		}
+		from := len(out) - distance - 1
+		if (from < 0) {
+			return nil, errors.New(fmt.Sprintf("match distance %d is before the start of the data", distance + 1))
+		}
+		for i := 0; i < length && len(out) < originalSize; i++ {
+			out = append(out, out[from+i])
+		}
+	}
+	return out, nil
+}
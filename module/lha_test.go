@@ -0,0 +1,332 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lhaBitWriter writes MSB-first bits
+type lhaBitWriter struct {
+	data []byte
+	bit uint
+}
+
+func (w *lhaBitWriter) writeBits(value int, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if (w.bit == 0) {
+			w.data = append(w.data, 0)
+		}
+		if (value >> i & 1 != 0) {
+			w.data[len(w.data)-1] |= 0x80 >> w.bit
+		}
+		w.bit = (w.bit + 1) % 8
+	}
+}
+
+// encodeLH5 compresses data as a single block with greedy matching and fixed
+// tables. The tables are complete, as LHA itself requires: codes 508 and 509
+// are 8 bits and the other literal and length codes 9, and the distance
+// classes are split between the two bit lengths that fit them exactly.
+func encodeLH5(data []byte, dictionaryBits uint) []byte {
+	type token struct {
+		literal byte
+		length int
+		distance int
+	}
+	tokens := make([]token, 0)
+	for pos := 0; pos < len(data); {
+		best := token{literal: data[pos]}
+		for from := pos - 1; from >= 0 && pos - from <= 1 << dictionaryBits; from-- {
+			length := 0
+			for length < 256 && pos + length < len(data) && data[from+length] == data[pos+length] {
+				length++
+			}
+			if (length >= 3 && length > best.length) {
+				best = token{length: length, distance: pos - from - 1}
+			}
+			if (length == 256) {
+				break
+			}
+		}
+		tokens = append(tokens, best)
+		if (best.length > 0) {
+			pos += best.length
+		} else {
+			pos++
+		}
+	}
+
+	w := &lhaBitWriter{}
+	w.writeBits(len(tokens), 16)
+	// the code lengths 8 and 9 are sent as codes 10 and 11, each one bit
+	w.writeBits(12, 5)
+	for i := 0; i < 12; i++ {
+		if (i >= 10) {
+			w.writeBits(1, 3)
+		} else {
+			w.writeBits(0, 3)
+		}
+		if (i == 2) {
+			w.writeBits(0, 2)
+		}
+	}
+	w.writeBits(lhaNumCodes, 9)
+	for i := 0; i < lhaNumCodes; i++ {
+		if (i >= 508) {
+			w.writeBits(0, 1)
+		} else {
+			w.writeBits(1, 1)
+		}
+	}
+	writeCode := func(c int) {
+		if (c >= 508) {
+			w.writeBits(c - 508, 8)
+		} else {
+			w.writeBits(c + 4, 9)
+		}
+	}
+
+	numPositions := int(dictionaryBits) + 1
+	positionBits := uint(4)
+	if (dictionaryBits > 13) {
+		positionBits = 5
+	}
+	long := uint(bits.Len(uint(numPositions - 1)))
+	numShort := 1 << long - numPositions
+	w.writeBits(numPositions, positionBits)
+	for i := 0; i < numPositions; i++ {
+		if (i < numShort) {
+			w.writeBits(int(long) - 1, 3)
+		} else {
+			w.writeBits(int(long), 3)
+		}
+	}
+	writeClass := func(class int) {
+		if (class < numShort) {
+			w.writeBits(class, long - 1)
+		} else {
+			w.writeBits(numShort + class, long)
+		}
+	}
+
+	for _, tok := range tokens {
+		if (tok.length == 0) {
+			writeCode(int(tok.literal))
+			continue
+		}
+		writeCode(256 + tok.length - 3)
+		class := bits.Len(uint(tok.distance))
+		writeClass(class)
+		if (class > 1) {
+			w.writeBits(tok.distance - 1 << (class - 1), uint(class - 1))
+		}
+	}
+	return w.data
+}
+
+type testLHAFile struct {
+	name string
+	method string
+	data []byte
+}
+
+// buildTestLHA builds an archive with the given header level. Level 1 and 2
+// headers put any directory in an extended header.
+func buildTestLHA(level int, files []testLHAFile) []byte {
+	var buf bytes.Buffer
+	for _, file := range files {
+		var compressed []byte
+		switch file.method {
+		case "-lh0-":
+			compressed = file.data
+		case "-lh5-", "-lh6-", "-lh7-":
+			compressed = encodeLH5(file.data, lhaDictionaryBits[file.method])
+		default:
+			compressed = []byte{0, 0, 0, 0}
+		}
+		dir, name := "", file.name
+		if i := strings.LastIndex(file.name, "/"); i >= 0 && level > 0 {
+			dir, name = file.name[:i], file.name[i+1:]
+		}
+
+		header := make([]byte, 22)
+		copy(header[2:7], file.method)
+		binary.LittleEndian.PutUint32(header[11:15], uint32(len(file.data)))
+		header[19] = 0x20
+		header[20] = byte(level)
+		crc := binary.LittleEndian.AppendUint16(nil, lhaCRC(file.data))
+
+		var ext []byte
+		if (dir != "") {
+			ext = append([]byte{0x02}, strings.ReplaceAll(dir + "/", "/", "\xff")...)
+		}
+		switch level {
+		case 0, 1:
+			header[21] = byte(len(name))
+			header = append(append(header, name...), crc...)
+			extSize := 0
+			if (level == 1) {
+				header = append(header, 'U')
+				if (ext != nil) {
+					extSize = len(ext) + 2
+				}
+				header = binary.LittleEndian.AppendUint16(header, uint16(extSize))
+				if (ext != nil) {
+					ext = append(ext, 0, 0)
+				}
+			}
+			header[0] = byte(len(header) - 2)
+			binary.LittleEndian.PutUint32(header[7:11], uint32(len(compressed) + extSize))
+			sum := byte(0)
+			for _, b := range header[2:] {
+				sum += b
+			}
+			header[1] = sum
+			header = append(header, ext...)
+		case 2:
+			header = append(header[:21], crc...)
+			header = append(header, 'U')
+			exts := [][]byte{{0x00, 0, 0}, append([]byte{0x01}, name...)}
+			if (ext != nil) {
+				exts = append(exts, ext)
+			}
+			header = binary.LittleEndian.AppendUint16(header, uint16(len(exts[0]) + 2))
+			for i, e := range exts {
+				next := 0
+				if (i + 1 < len(exts)) {
+					next = len(exts[i+1]) + 2
+				}
+				header = binary.LittleEndian.AppendUint16(append(header, e...), uint16(next))
+			}
+			binary.LittleEndian.PutUint16(header[0:2], uint16(len(header)))
+			binary.LittleEndian.PutUint32(header[7:11], uint32(len(compressed)))
+			binary.LittleEndian.PutUint16(header[27:29], lhaCRC(header))
+		}
+		buf.Write(header)
+		buf.Write(compressed)
+	}
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+func TestDecodeLH5(t *testing.T) {
+	data := make([]byte, 0)
+	for i := 0; i < 300; i++ {
+		data = append(data, []byte("ProTracker ")...)
+		data = append(data, byte(i), byte(i*7))
+	}
+	data = append(data, make([]byte, 1000)...)
+	for _, method := range []string{"-lh5-", "-lh6-", "-lh7-"} {
+		out, err := decodeLHA(method, encodeLH5(data, lhaDictionaryBits[method]), len(data))
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", method, err)
+			continue
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%s: decoded data doesn't match", method)
+		}
+	}
+
+	if _, err := decodeLHA("-lh5-", []byte{0, 0}, 10); err == nil {
+		t.Errorf("Expected an error for an empty block")
+	}
+	if _, err := decodeLHA("-lh1-", nil, 10); err == nil {
+		t.Errorf("Expected an error for an unsupported method")
+	}
+}
+
+func TestLoadLHA(t *testing.T) {
+	dir := t.TempDir()
+	mod := buildTestMOD("M.K.", 4, []byte{0})
+	xm := buildTestXM(4, []int{64}, [][]byte{nil})
+
+	for level := 0; level <= 2; level++ {
+		data := buildTestLHA(level, []testLHAFile{
+			{"readme.txt", "-lh5-", []byte("greetings to everyone at the party")},
+			{"mods/one.mod", "-lh0-", mod},
+			{"mods/two.xm", "-lh5-", xm},
+			{"mods/three.mod", "-lh7-", mod},
+		})
+		if !isLHA(data) {
+			t.Fatalf("level %d: not detected as LHA", level)
+		}
+		file := filepath.Join(dir, "pack.lha")
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := LoadArchive(file)
+		if err != nil {
+			t.Fatalf("level %d: Unexpected error: %v", level, err)
+		}
+		types := make(map[string]FileFormat)
+		for _, entry := range entries {
+			if entry.Err != nil {
+				t.Errorf("level %d %s: Unexpected error: %v", level, entry.Path, entry.Err)
+				continue
+			}
+			types[entry.Path] = entry.Module.Type()
+		}
+		if (len(types) != 3 || types["mods/one.mod"] != PROTRACKER || types["mods/two.xm"] != FASTTRACKER || types["mods/three.mod"] != PROTRACKER) {
+			t.Errorf("level %d: got entries %v", level, types)
+		}
+
+		m, err := Load(file + "#mods/two.xm")
+		if (err != nil || m.Type() != FASTTRACKER) {
+			t.Errorf("level %d: got %v, %v", level, m, err)
+		}
+	}
+
+	// a corrupt member and one with an unsupported method are reported, but
+	// don't stop the rest loading
+	data := buildTestLHA(2, []testLHAFile{
+		{"one.mod", "-lh5-", mod},
+		{"two.mod", "-lh5-", mod},
+		{"three.mod", "-lh1-", mod},
+	})
+	data[bytes.Index(data, []byte("two.mod")) + 20] ^= 0xFF
+	file := filepath.Join(dir, "bad.lha")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := LoadArchive(file)
+	if (err != nil || len(entries) != 3) {
+		t.Fatalf("got %+v, %v", entries, err)
+	}
+	if (entries[0].Err != nil || entries[1].Err == nil || entries[2].Err == nil) {
+		t.Errorf("got errors %v, %v, %v", entries[0].Err, entries[1].Err, entries[2].Err)
+	}
+
+	// the level 2 header CRC covers the timestamp
+	data = buildTestLHA(2, []testLHAFile{{"one.mod", "-lh5-", mod}})
+	data[15] ^= 0xFF
+	var formatError *FormatError
+	if _, err := LoadBytes(data, "song.lha"); !errors.As(err, &formatError) {
+		t.Errorf("Expected a FormatError for a bad header CRC, got %v", err)
+	}
+
+	// a level 0 header too short for its fixed fields, with a checksum to match
+	short := make([]byte, 64)
+	copy(short[2:7], "-lh0-")
+	short[0] = 5
+	for _, b := range short[2:7] {
+		short[1] += b
+	}
+	// a level 2 header with an extended header running past its end
+	longExt := make([]byte, 64)
+	copy(longExt[2:7], "-lh0-")
+	longExt[20] = 2
+	binary.LittleEndian.PutUint16(longExt[0:2], 26)
+	binary.LittleEndian.PutUint16(longExt[24:26], 5)
+	for name, data := range map[string][]byte{"short header": short, "extended header past the end": longExt} {
+		if _, err := LoadBytes(data, "song.lha"); !errors.As(err, &formatError) || !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("%s: Expected an invalid value FormatError, got %v", name, err)
+		}
+	}
+}
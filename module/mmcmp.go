@@ -0,0 +1,285 @@
+package module
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MMCMP compressed modules, as made by ModPlug's "ziRCONia" packer. The file
+// is split into blocks, each unpacking into one or more sub blocks of the
+// output. Compressed blocks hold a stream of variable width values, read
+// lowest bit first, where values at the top of the current width's range are
+// commands that change the width or give the largest values.
+
+// block flags
+const (
+	mmcmpComp = 0x0001
+	mmcmpDelta = 0x0002
+	mmcmp16Bit = 0x0004
+	mmcmpAbs16 = 0x0200
+	mmcmpEndian = 0x0400
+)
+
+// mmcmp8BitCommands gives, for each width, the lowest value that's a command.
+// Commands read mmcmp8BitFetch more bits to give the new width.
+var mmcmp8BitCommands = []uint32{0x01, 0x03, 0x07, 0x0F, 0x1E, 0x3C, 0x78, 0xF8}
+var mmcmp8BitFetch = []uint{3, 3, 3, 3, 2, 1, 0, 0}
+
+var mmcmp16BitCommands = []uint32{
+	0x01, 0x03, 0x07, 0x0F, 0x1E, 0x3C, 0x78, 0xF0,
+	0x1F0, 0x3F0, 0x7F0, 0xFF0, 0x1FF0, 0x3FF0, 0x7FF0, 0xFFF0,
+}
+var mmcmp16BitFetch = []uint{4, 4, 4, 4, 3, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// isMMCMP checks for the "ziRCONia" signature and header size
+func isMMCMP(data []byte) bool {
+	return len(data) >= 24 && string(data[0:8]) == "ziRCONia" && binary.LittleEndian.Uint16(data[8:10]) == 14
+}
+
+// mmcmpBitReader reads bits lowest first, giving zeros past the end of the
+// block. overrun counts the bytes read past the end.
+type mmcmpBitReader struct {
+	data []byte
+	pos int
+	buffer uint32
+	count uint
+	overrun int
+}
+
+func (r *mmcmpBitReader) readBits(n uint) uint32 {
+	if (n == 0) {
+		return 0
+	}
+	for r.count < 24 {
+		if (r.pos < len(r.data)) {
+			r.buffer |= uint32(r.data[r.pos]) << r.count
+			r.pos++
+		} else {
+			r.overrun++
+		}
+		r.count += 8
+	}
+	value := r.buffer & (1 << n - 1)
+	r.buffer >>= n
+	r.count -= n
+	return value
+}
+
+// mmcmpSubBlock is a part of the output filled by a block
+type mmcmpSubBlock struct {
+	position int
+	size int
+}
+
+// unpackMMCMP unpacks an MMCMP compressed file, refusing any that would
+// unpack to more than maxSize bytes
+func unpackMMCMP(data []byte, maxSize int64) ([]byte, error) {
+	r := reader{format: "mmcmp", data: data}
+	if err := r.check("header", 0, 24); err != nil {
+		return nil, err
+	}
+	numBlocks := int(binary.LittleEndian.Uint16(data[12:14]))
+	fileSize := int(binary.LittleEndian.Uint32(data[14:18]))
+	blockTable := int(binary.LittleEndian.Uint32(data[18:22]))
+	if err := checkLimit("MaxSize", int64(fileSize), maxSize); err != nil {
+		return nil, err
+	}
+	if err := r.check("blockTable", blockTable, numBlocks*4); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, fileSize)
+	for i := 0; i < numBlocks; i++ {
+		field := fmt.Sprintf("block[%d]", i)
+		offset := int(binary.LittleEndian.Uint32(data[blockTable+i*4:]))
+		header, err := r.slice(field, offset, 20)
+		if err != nil {
+			return nil, err
+		}
+		packedSize := int(binary.LittleEndian.Uint32(header[4:8]))
+		numSubBlocks := int(binary.LittleEndian.Uint16(header[12:14]))
+		flags := binary.LittleEndian.Uint16(header[14:16])
+		tableSize := int(binary.LittleEndian.Uint16(header[16:18]))
+		numBits := uint(binary.LittleEndian.Uint16(header[18:20]))
+		offset += 20
+
+		if err := r.check(field + ".subBlocks", offset, numSubBlocks*8); err != nil {
+			return nil, err
+		}
+		subBlocks := make([]mmcmpSubBlock, numSubBlocks)
+		for j := range subBlocks {
+			subBlock := data[offset+j*8:offset+j*8+8]
+			subBlocks[j] = mmcmpSubBlock{
+				position: int(binary.LittleEndian.Uint32(subBlock[0:4])),
+				size: int(binary.LittleEndian.Uint32(subBlock[4:8])),
+			}
+			if (subBlocks[j].position < 0 || subBlocks[j].size < 0 || subBlocks[j].position > fileSize - subBlocks[j].size) {
+				return nil, r.invalid(fmt.Sprintf("%s.subBlock[%d]", field, j), offset + j*8, fmt.Sprintf("%d bytes at %d", subBlocks[j].size, subBlocks[j].position))
+			}
+		}
+		offset += numSubBlocks*8
+
+		if (flags & mmcmpComp == 0) {
+			// stored as it is
+			for j, subBlock := range subBlocks {
+				stored, err := r.slice(fmt.Sprintf("%s.subBlock[%d].data", field, j), offset, subBlock.size)
+				if err != nil {
+					return nil, err
+				}
+				copy(out[subBlock.position:], stored)
+				offset += subBlock.size
+			}
+			continue
+		}
+
+		packed, err := r.slice(field + ".data", offset, packedSize)
+		if err != nil {
+			return nil, err
+		}
+		if (tableSize > len(packed)) {
+			return nil, r.invalid(field + ".table", offset, tableSize)
+		}
+		if (flags & mmcmp16Bit != 0) {
+			// 16 bit blocks don't use the table, but the data still follows it
+			err = unpackMMCMP16Bit(out, subBlocks, packed[tableSize:], flags, numBits)
+		} else {
+			err = unpackMMCMP8Bit(out, subBlocks, packed[:tableSize], packed[tableSize:], flags, numBits)
+		}
+		if err != nil {
+			return nil, r.wrap(field + ".data", offset, err)
+		}
+	}
+	return out, nil
+}
+
+// mmcmpOutput writes values to the sub blocks of a block in turn
+type mmcmpOutput struct {
+	out []byte
+	subBlocks []mmcmpSubBlock
+	pos int
+}
+
+// next returns where the next value of the given size goes, or false once
+// the sub blocks are full
+func (o *mmcmpOutput) next(size int) (int, bool) {
+	for len(o.subBlocks) > 0 && o.pos + size > o.subBlocks[0].size {
+		o.subBlocks = o.subBlocks[1:]
+		o.pos = 0
+	}
+	if (len(o.subBlocks) == 0) {
+		return 0, false
+	}
+	at := o.subBlocks[0].position + o.pos
+	o.pos += size
+	return at, true
+}
+
+func unpackMMCMP8Bit(out []byte, subBlocks []mmcmpSubBlock, table []byte, packed []byte, flags uint16, numBits uint) error {
+	in := &mmcmpBitReader{data: packed}
+	o := &mmcmpOutput{out: out, subBlocks: subBlocks}
+	numBits &= 7
+	previous := byte(0)
+	for {
+		at, ok := o.next(1)
+		if !ok {
+			return nil
+		}
+		var value uint32
+		for {
+			if (in.overrun > 4) {
+				return ErrTruncated
+			}
+			d := in.readBits(numBits + 1)
+			command := mmcmp8BitCommands[numBits]
+			if (d < command) {
+				value = d
+				break
+			}
+			fetch := mmcmp8BitFetch[numBits]
+			newBits := uint(in.readBits(fetch) + (d - command) << fetch)
+			if (newBits != numBits) {
+				numBits = newBits & 7
+				continue
+			}
+			// a command for the current width gives one of the top 8 values
+			d = in.readBits(3)
+			if (d < 7) {
+				value = 0xF8 + d
+				break
+			}
+			if (in.readBits(1) != 0) {
+				// end of the block
+				return nil
+			}
+			value = 0xFF
+			break
+		}
+		if (int(value) >= len(table)) {
+			return ErrInvalidValue
+		}
+		n := table[value]
+		if (flags & mmcmpDelta != 0) {
+			n += previous
+			previous = n
+		}
+		out[at] = n
+	}
+}
+
+func unpackMMCMP16Bit(out []byte, subBlocks []mmcmpSubBlock, packed []byte, flags uint16, numBits uint) error {
+	in := &mmcmpBitReader{data: packed}
+	o := &mmcmpOutput{out: out, subBlocks: subBlocks}
+	numBits &= 15
+	previous := uint16(0)
+	for {
+		at, ok := o.next(2)
+		if !ok {
+			return nil
+		}
+		var value uint32
+		for {
+			if (in.overrun > 4) {
+				return ErrTruncated
+			}
+			d := in.readBits(numBits + 1)
+			command := mmcmp16BitCommands[numBits]
+			if (d < command) {
+				value = d
+				break
+			}
+			fetch := mmcmp16BitFetch[numBits]
+			newBits := uint(in.readBits(fetch) + (d - command) << fetch)
+			if (newBits != numBits) {
+				numBits = newBits & 15
+				continue
+			}
+			d = in.readBits(4)
+			if (d < 15) {
+				value = 0xFFF0 + d
+				break
+			}
+			if (in.readBits(1) != 0) {
+				return nil
+			}
+			value = 0xFFFF
+			break
+		}
+
+		// the lowest bit is the sign
+		sample := uint16(value >> 1)
+		if (value & 1 != 0) {
+			sample = -uint16((value + 1) >> 1)
+		}
+		if (flags & mmcmpDelta != 0) {
+			sample += previous
+			previous = sample
+		} else if (flags & mmcmpAbs16 == 0) {
+			sample ^= 0x8000
+		}
+		if (flags & mmcmpEndian != 0) {
+			binary.BigEndian.PutUint16(out[at:], sample)
+		} else {
+			binary.LittleEndian.PutUint16(out[at:], sample)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// mmcmpBitWriter writes bits lowest first, as mmcmpBitReader reads them
+type mmcmpBitWriter struct {
+	data []byte
+	count uint
+}
+
+func (w *mmcmpBitWriter) write(value uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if (w.count % 8 == 0) {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(value >> i & 1) << (w.count % 8)
+		w.count++
+	}
+}
+
+// encodeMMCMPValues writes values as an MMCMP block does, switching to the
+// narrowest width that holds each value. escapeBits is the number of bits
+// giving the top values at the widest width.
+func encodeMMCMPValues(values []uint32, numBits uint, commands []uint32, fetch []uint, escapeBits uint) []byte {
+	w := &mmcmpBitWriter{}
+	widest := uint(len(commands) - 1)
+	for _, value := range values {
+		newBits := uint(0)
+		for newBits < widest && value >= commands[newBits] {
+			newBits++
+		}
+		if (newBits != numBits) {
+			w.write(commands[numBits] + uint32(newBits >> fetch[numBits]), numBits + 1)
+			w.write(uint32(newBits) & (1 << fetch[numBits] - 1), fetch[numBits])
+			numBits = newBits
+		}
+		if (value < commands[numBits]) {
+			w.write(value, numBits + 1)
+			continue
+		}
+		// the top values follow a command for the current width
+		top := value - commands[numBits]
+		w.write(commands[numBits] + uint32(numBits), numBits + 1)
+		w.write(top, escapeBits)
+		if (top == 1 << escapeBits - 1) {
+			w.write(0, 1)
+		}
+	}
+	return w.data
+}
+
+// packMMCMP packs data into an MMCMP file of three blocks: the first 300
+// bytes stored in two sub blocks, the next 300 as 8 bit deltas through a
+// reversed translation table, and the rest as 16 bit deltas following a
+// table that's never used
+func packMMCMP(data []byte) []byte {
+	type block struct {
+		subBlocks [][2]int
+		flags uint16
+		table []byte
+		packed []byte
+	}
+	blocks := make([]block, 0)
+
+	blocks = append(blocks, block{subBlocks: [][2]int{{0, 100}, {100, 200}}, packed: data[0:300]})
+
+	table := make([]byte, 256)
+	values := make([]uint32, 0)
+	previous := byte(0)
+	for i := range table {
+		table[i] = ^byte(i)
+	}
+	for _, b := range data[300:600] {
+		values = append(values, uint32(^(b - previous)))
+		previous = b
+	}
+	blocks = append(blocks, block{
+		subBlocks: [][2]int{{300, 300}},
+		flags: mmcmpComp | mmcmpDelta,
+		table: table,
+		packed: encodeMMCMPValues(values, 7, mmcmp8BitCommands, mmcmp8BitFetch, 3),
+	})
+
+	values = values[:0]
+	previous16 := uint16(0)
+	for i := 600; i < len(data); i += 2 {
+		sample := binary.LittleEndian.Uint16(data[i:])
+		delta := int16(sample - previous16)
+		previous16 = sample
+		if (delta >= 0) {
+			values = append(values, uint32(delta) * 2)
+		} else {
+			values = append(values, uint32(-int32(delta)) * 2 - 1)
+		}
+	}
+	blocks = append(blocks, block{
+		subBlocks: [][2]int{{600, len(data) - 600}},
+		flags: mmcmpComp | mmcmpDelta | mmcmp16Bit,
+		table: []byte{0xFF, 0xFF, 0xFF, 0xFF},
+		packed: encodeMMCMPValues(values, 15, mmcmp16BitCommands, mmcmp16BitFetch, 4),
+	})
+
+	out := make([]byte, 24)
+	copy(out[0:8], "ziRCONia")
+	binary.LittleEndian.PutUint16(out[8:10], 14)
+	binary.LittleEndian.PutUint16(out[10:12], 0x1310)
+	binary.LittleEndian.PutUint16(out[12:14], uint16(len(blocks)))
+	binary.LittleEndian.PutUint32(out[14:18], uint32(len(data)))
+	binary.LittleEndian.PutUint32(out[18:22], 24)
+	offset := 24 + len(blocks)*4
+	for _, b := range blocks {
+		out = binary.LittleEndian.AppendUint32(out, uint32(offset))
+		offset += 20 + len(b.subBlocks)*8 + len(b.table) + len(b.packed)
+	}
+	for _, b := range blocks {
+		header := make([]byte, 20)
+		binary.LittleEndian.PutUint32(header[4:8], uint32(len(b.table) + len(b.packed)))
+		binary.LittleEndian.PutUint16(header[12:14], uint16(len(b.subBlocks)))
+		binary.LittleEndian.PutUint16(header[14:16], b.flags)
+		binary.LittleEndian.PutUint16(header[16:18], uint16(len(b.table)))
+		binary.LittleEndian.PutUint16(header[18:20], 7)
+		if (b.flags & mmcmp16Bit != 0) {
+			binary.LittleEndian.PutUint16(header[18:20], 15)
+		}
+		out = append(out, header...)
+		for _, subBlock := range b.subBlocks {
+			out = binary.LittleEndian.AppendUint32(out, uint32(subBlock[0]))
+			out = binary.LittleEndian.AppendUint32(out, uint32(subBlock[1]))
+		}
+		out = append(out, b.table...)
+		out = append(out, b.packed...)
+	}
+	return out
+}
+
+func TestUnpackMMCMP(t *testing.T) {
+	packed := bytes.Repeat([]byte{0x80}, 4*64)
+	xm := buildTestXM(4, []int{64, 64}, [][]byte{packed, packed})
+	// values across the whole range, to switch through every width
+	for i := 0; i < 300; i++ {
+		xm = append(xm, byte(i*i >> 3), byte(i*7), byte(i >> 1))
+	}
+	mmcmp := packMMCMP(xm)
+	if !isMMCMP(mmcmp) {
+		t.Fatalf("packed file isn't detected as MMCMP")
+	}
+
+	out, err := unpackMMCMP(mmcmp, 1 << 20)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(out, xm) {
+		t.Errorf("unpacked data doesn't match")
+	}
+
+	truncated := mmcmp[:len(mmcmp)-200]
+	if _, err := unpackMMCMP(truncated, 1 << 20); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if _, err := unpackMMCMP(mmcmp, 1000); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+	corrupt := append([]byte(nil), mmcmp...)
+	binary.LittleEndian.PutUint32(corrupt[24+12+20:], uint32(len(xm)))
+	if _, err := unpackMMCMP(corrupt, 1 << 20); err == nil {
+		t.Errorf("Expected an error for a sub block past the end")
+	}
+}
+
+func TestLoadMMCMP(t *testing.T) {
+	packed := bytes.Repeat([]byte{0x80}, 4*64)
+	mmcmp := packMMCMP(buildTestXM(4, []int{64, 64}, [][]byte{packed, packed}))
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("song.xm")
+	w.Write(mmcmp)
+	zw.Close()
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"song.xm", mmcmp},
+		{"pack.zip", buf.Bytes()},
+	} {
+		m, err := LoadBytes(tt.data, tt.name)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.name, err)
+			continue
+		}
+		if (m.Type() != FASTTRACKER || m.NumPatterns() != 2) {
+			t.Errorf("%s: got type %d with %d patterns", tt.name, m.Type(), m.NumPatterns())
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package module
 
 import (
-	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 type FileFormat int
@@ -12,6 +16,7 @@ const (
 	SCREAMTRACKER
 	FASTTRACKER
 	IMPULSETRACKER
+	SOUNDTRACKER
 )
 
 type Module interface {
@@ -23,6 +28,7 @@ type Module interface {
 	Instruments() []Instrument
 	Samples() []Sample
 	NumPatterns() int
+	Tracker() TrackerInfo
 }
 
 type Sample interface {
@@ -36,55 +42,157 @@ type Instrument interface {
 	Filename() string
 }
 
+// Load reads a module from a file, or from a zip containing one, using the
+// default limits. A member of a zip holding several modules can be loaded with
+// a path like "pack.zip#path/in/zip.xm".
 func Load(modFile string) (Module,error) {
+	return DefaultLoadOptions().Load(modFile)
+}
 
-	// assume a zipfile
-	zf, err := zip.OpenReader(modFile)
-	var data []byte
-	if err == nil {
-		// TODO: > 1 file per zip
-		for _, file := range zf.File {
-			fc, err := file.Open()
-			defer fc.Close()
-			if err != nil {
-				return nil, err
-			}
-			data, err = ioutil.ReadAll(fc)
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		f, err := os.Open(modFile)
-		defer f.Close()
-		data, err = ioutil.ReadAll(f)
+// LoadWithOptions reads a module from a file, or from a zip containing one,
+// returning a LimitError if the file goes over any of the limits
+func LoadWithOptions(modFile string, options LoadOptions) (Module,error) {
+	return options.Load(modFile)
+}
+
+// LoadBytes reads a module, or a zip containing one, that's already in
+// memory. The name is reported by Filename and used to pick between formats
+// that match equally well; it may be empty.
+func LoadBytes(data []byte, name string) (Module,error) {
+	return DefaultLoadOptions().LoadBytes(data, name)
+}
+
+// LoadReader reads a module, or a zip containing one, from r. If name is empty
+// and r has a Name method, as an *os.File does, that name is used instead.
+func LoadReader(r io.Reader, name string) (Module,error) {
+	return DefaultLoadOptions().LoadReader(r, name)
+}
+
+// LoadReaderAt reads a module, or a zip containing one, of the given size from r
+func LoadReaderAt(r io.ReaderAt, size int64, name string) (Module,error) {
+	return DefaultLoadOptions().LoadReaderAt(r, size, name)
+}
+
+// LoadFS reads the named module, or a zip containing one, from fsys
+func LoadFS(fsys fs.FS, name string) (Module,error) {
+	return DefaultLoadOptions().LoadFS(fsys, name)
+}
+
+// Load reads a module from a file, or from a zip containing one
+func (o LoadOptions) Load(modFile string) (Module,error) {
+	file, member := SplitArchivePath(modFile)
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if (member != "") {
+		entries, err := o.loadEntries(f, info.Size(), file, member, false)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
+		return entries[0].Module, entries[0].Err
 	}
+	return o.LoadReaderAt(f, info.Size(), file)
+}
 
-	// Determine file type
-	if (string(data[44:48]) == "SCRM") {
-		m := &ScreamTracker{}
-		err = m.Load(data)
-		return m, err
-	}
+// LoadBytes reads a module, or a zip containing one, that's already in memory
+func (o LoadOptions) LoadBytes(data []byte, name string) (Module,error) {
+	return o.LoadReaderAt(bytes.NewReader(data), int64(len(data)), name)
+}
 
-	if (string(data[0:17]) == "Extended Module: ") {
-		m := &FastTracker{}
-		err = m.Load(data)
-		return m, err
+// LoadReader reads a module, or a zip containing one, from r
+func (o LoadOptions) LoadReader(r io.Reader, name string) (Module,error) {
+	o = o.withDefaults()
+	if n, ok := r.(interface{ Name() string }); ok && name == "" {
+		name = n.Name()
+	}
+	data, err := readAllLimited(r, "MaxCompressedSize", o.MaxCompressedSize)
+	if err != nil {
+		return nil, err
 	}
+	return o.LoadBytes(data, name)
+}
 
-	if (string(data[0:4]) == "IMPM") {
-		m := &ImpulseTracker{}
-		err = m.Load(data)
-		return m, err
+// LoadFS reads the named module, or a zip containing one, from fsys
+func (o LoadOptions) LoadFS(fsys fs.FS, name string) (Module,error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if ra, ok := f.(io.ReaderAt); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return o.LoadReaderAt(ra, info.Size(), name)
 	}
+	return o.LoadReader(f, name)
+}
 
-	m := &ProTracker{}
-	err = m.Load(data)
+// LoadReaderAt reads a module, or a zip containing one, of the given size
+// from r. A zip holding more than one module gives ErrMultipleModules.
+func (o LoadOptions) LoadReaderAt(r io.ReaderAt, size int64, name string) (Module,error) {
+	entries, err := o.loadEntries(r, size, name, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if (len(entries) == 0) {
+		return nil, ErrUnknownFormat
+	}
+	if (len(entries) > 1) {
+		paths := make([]string, len(entries))
+		for i, entry := range entries {
+			paths[i] = entry.Path
+		}
+		return nil, fmt.Errorf("%w: %s", ErrMultipleModules, strings.Join(paths, ", "))
+	}
+	return entries[0].Module, entries[0].Err
+}
 
-	return m, err
+// loadData detects the format of data and loads it, unpacking it first if
+// it's PowerPacker crunched or MMCMP compressed, or taking the module out of
+// it if it's an Unreal package
+func loadData(data []byte, name string, options LoadOptions) (Module,error) {
+	var err error
+	switch {
+	case IsPowerPacked(data):
+		data, err = decrunchPowerPacker(data, options.MaxSize)
+	case isMMCMP(data):
+		data, err = unpackMMCMP(data, options.MaxSize)
+	case IsUMX(data):
+		data, err = ExtractUMX(data)
+	}
+	if (err != nil) {
+		return nil, err
+	}
+	format, err := DetectFormat(data, name)
+	if (err != nil) {
+		return nil, err
+	}
+	m := format.New()
+	if l, ok := m.(loadStateSetter); ok {
+		l.setLoadState(options, name)
+	}
+	if err := m.Load(data); err != nil {
+		return m, err
+	}
+	return m, checkModuleLimits(m, options)
+}
 
+// readAllLimited reads everything from r, without trusting any stated size
+func readAllLimited(r io.Reader, limit string, max int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, max + 1))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit(limit, int64(len(data)), max); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
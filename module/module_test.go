@@ -1,3 +1,380 @@
 package module
 
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
 
+// buildTestModules returns a small module of each format, each with a pattern
+// holding a note
+func buildTestModules(t *testing.T) map[string][]byte {
+	xm := NewFastTracker(4)
+	xm.SetOrderTable([]byte{0})
+	p := NewPattern(4, 64)
+	p.SetRow(0, NewRow([]Note{NewXMNote(49, 1, 0x40, 0x0f, 6), {}, {}, {}}))
+	xm.AddPattern(p)
+	instrument := NewFTInstrument("lead")
+	instrument.AddSample(NewFTSample("sample", []byte{0, 10, 20, 30}))
+	xm.AddInstrument(instrument)
+	xmData, err := xm.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error saving XM: %v", err)
+	}
+
+	mod := buildTestMOD("M.K.", 4, []byte{0})
+	copy(mod[1084:1088], []byte{0x10, 0xd6, 0x0c, 0x20})
+
+	return map[string][]byte{
+		"fasttracker": xmData,
+		"protracker": mod,
+		"soundtracker": buildTestMOD("", 4, []byte{0}),
+		"screamtracker": buildTestS3M(nil, [][]byte{append([]byte{0x20 | 1, 60, 1, 0}, make([]byte, 63)...)}),
+		"impulsetracker": buildTestIT([]int{64}, [][]byte{append([]byte{0x81, 0x03, 60, 1, 0}, make([]byte, 63)...)}),
+	}
+}
+
+func newTestModule(name string) Module {
+	switch name {
+	case "fasttracker":
+		return &FastTracker{}
+	case "screamtracker":
+		return &ScreamTracker{}
+	case "impulsetracker":
+		return &ImpulseTracker{}
+	}
+	return &ProTracker{}
+}
+
+// checkLoadError loads data, which must not panic, and makes sure any error
+// is a FormatError or LimitError
+func checkLoadError(t *testing.T, name string, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("%s: Load panicked on %d bytes: %v", name, len(data), r)
+		}
+	}()
+	err := newTestModule(name).Load(data)
+	var formatError *FormatError
+	var limitError *LimitError
+	if err != nil && !errors.As(err, &formatError) && !errors.As(err, &limitError) {
+		t.Errorf("%s: Load returned %T (%v), want *FormatError or *LimitError", name, err, err)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	for name, data := range buildTestModules(t) {
+		if err := newTestModule(name).Load(data); err != nil {
+			t.Fatalf("%s: Unexpected error loading: %v", name, err)
+		}
+		for n := 0; n < len(data); n++ {
+			checkLoadError(t, name, data[:n])
+		}
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for name, data := range buildTestModules(t) {
+		for i := 0; i < 2000; i++ {
+			corrupted := append([]byte{}, data...)
+			for j := 0; j < 1 + rng.Intn(4); j++ {
+				corrupted[rng.Intn(len(corrupted))] = byte(rng.Intn(256))
+			}
+			checkLoadError(t, name, corrupted)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	err := (&ProTracker{}).Load(buildTestMOD("M.K.", 4, []byte{0})[:1500])
+	var formatError *FormatError
+	if !errors.As(err, &formatError) {
+		t.Fatalf("Expected a FormatError, got %v", err)
+	}
+	if (formatError.Format != "protracker" || formatError.Field != "pattern[0]" || formatError.Offset != 1084) {
+		t.Errorf("got %+v", formatError)
+	}
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected error to wrap ErrTruncated, got %v", err)
+	}
+}
+
+func TestLoadWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	mod := buildTestMOD("M.K.", 4, []byte{0, 1, 2})
+	modFile := filepath.Join(dir, "song.mod")
+	if err := os.WriteFile(modFile, mod, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a zip with the module in it twice
+	zipFile := filepath.Join(dir, "song.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.mod", "b.mod"} {
+		w, _ := zw.Create(name)
+		w.Write(mod)
+	}
+	zw.Close()
+	if err := os.WriteFile(zipFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		options LoadOptions
+		limit string
+	}{
+		{modFile, LoadOptions{}, ""},
+		{zipFile + "#a.mod", LoadOptions{}, ""},
+		{modFile, LoadOptions{MaxCompressedSize: 1000}, "MaxCompressedSize"},
+		{modFile, LoadOptions{MaxSize: 1000}, "MaxSize"},
+		{zipFile, LoadOptions{MaxSize: 1000}, "MaxSize"},
+		{zipFile, LoadOptions{MaxEntries: 1}, "MaxEntries"},
+		// each entry fits but both together don't
+		{zipFile, LoadOptions{MaxSize: int64(len(mod)) * 3 / 2}, "MaxSize"},
+		{modFile, LoadOptions{MaxPatterns: 2}, "MaxPatterns"},
+		{modFile, LoadOptions{MaxChannels: 2}, "MaxChannels"},
+		{modFile, LoadOptions{MaxSamples: 30}, "MaxSamples"},
+	}
+	for _, tt := range tests {
+		_, err := LoadWithOptions(tt.file, tt.options)
+		var limitError *LimitError
+		if (tt.limit == "") {
+			if err != nil {
+				t.Errorf("%s %+v: Unexpected error: %v", tt.file, tt.options, err)
+			}
+		} else if !errors.As(err, &limitError) || limitError.Limit != tt.limit || !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s %+v: got %v, want %s exceeded", tt.file, tt.options, err, tt.limit)
+		}
+	}
+
+	// loading a single module stops at the second, so a third over the
+	// limit is never read
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	for _, name := range []string{"a.mod", "b.mod", "c.mod"} {
+		w, _ := zw.Create(name)
+		w.Write(mod)
+	}
+	w, _ := zw.Create("d.mod")
+	w.Write(make([]byte, 1 << 20))
+	zw.Close()
+	options := LoadOptions{MaxSize: 1 << 19}
+	if _, err := options.LoadBytes(buf.Bytes(), "pack.zip"); !errors.Is(err, ErrMultipleModules) {
+		t.Errorf("Expected ErrMultipleModules, got %v", err)
+	}
+	// while loading every module does read it
+	packFile := filepath.Join(dir, "pack.zip")
+	if err := os.WriteFile(packFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := options.LoadArchive(packFile); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	mod := buildTestMOD("M.K.", 4, []byte{0})
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("inner.mod")
+	w.Write(mod)
+	zw.Close()
+	fsys := fstest.MapFS{
+		"songs/song.mod": {Data: mod},
+		"songs/pack.zip": {Data: buf.Bytes()},
+	}
+
+	tests := []struct {
+		desc string
+		load func() (Module, error)
+		filename string
+	}{
+		{"bytes", func() (Module, error) { return LoadBytes(mod, "song.mod") }, "song.mod"},
+		{"unnamed bytes", func() (Module, error) { return LoadBytes(mod, "") }, ""},
+		{"zip bytes", func() (Module, error) { return LoadBytes(buf.Bytes(), "pack.zip") }, "pack.zip#inner.mod"},
+		{"reader", func() (Module, error) { return LoadReader(iotest.OneByteReader(bytes.NewReader(mod)), "song.mod") }, "song.mod"},
+		{"reader at", func() (Module, error) { return LoadReaderAt(bytes.NewReader(mod), int64(len(mod)), "song.mod") }, "song.mod"},
+		{"fs", func() (Module, error) { return LoadFS(fsys, "songs/song.mod") }, "songs/song.mod"},
+		{"fs zip", func() (Module, error) { return LoadFS(fsys, "songs/pack.zip") }, "songs/pack.zip#inner.mod"},
+	}
+	for _, tt := range tests {
+		m, err := tt.load()
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if (m.Type() != PROTRACKER || m.Filename() != tt.filename) {
+			t.Errorf("%s: got type %d filename %q, want %q", tt.desc, m.Type(), m.Filename(), tt.filename)
+		}
+	}
+
+	if _, err := LoadFS(fsys, "songs/missing.mod"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for a missing file, got %v", err)
+	}
+}
+
+func TestLoadArchive(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.Create("songs/")
+	for name, data := range map[string][]byte{
+		"readme.txt": []byte("greetings to everyone at the party"),
+		"songs/one.mod": buildTestMOD("M.K.", 4, []byte{0}),
+		"songs/two.xm": buildTestXM(4, []int{64}, [][]byte{nil}),
+		"file_id.diz": []byte("a pack of songs"),
+	} {
+		w, _ := zw.Create(name)
+		w.Write(data)
+	}
+	zw.Close()
+	zipFile := filepath.Join(dir, "pack.zip")
+	if err := os.WriteFile(zipFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadArchive(zipFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	types := make(map[string]FileFormat)
+	for _, entry := range entries {
+		if entry.Err != nil {
+			t.Errorf("%s: Unexpected error: %v", entry.Path, entry.Err)
+			continue
+		}
+		types[entry.Path] = entry.Module.Type()
+	}
+	if (len(types) != 2 || types["songs/one.mod"] != PROTRACKER || types["songs/two.xm"] != FASTTRACKER) {
+		t.Errorf("got entries %v", types)
+	}
+
+	m, err := Load(zipFile + "#songs/two.xm")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if (m.Type() != FASTTRACKER || m.Filename() != zipFile + "#songs/two.xm") {
+		t.Errorf("got type %d filename %q", m.Type(), m.Filename())
+	}
+
+	if _, err := Load(zipFile); !errors.Is(err, ErrMultipleModules) {
+		t.Errorf("Expected ErrMultipleModules, got %v", err)
+	}
+	if _, err := Load(zipFile + "#songs/three.s3m"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for a missing member, got %v", err)
+	}
+	if _, err := Load(zipFile + "#readme.txt"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat for a readme, got %v", err)
+	}
+
+	// a plain module is a single entry with no path
+	modFile := filepath.Join(dir, "song.mod")
+	os.WriteFile(modFile, buildTestMOD("M.K.", 4, []byte{0}), 0644)
+	entries, err = LoadArchive(modFile)
+	if (err != nil || len(entries) != 1 || entries[0].Path != "" || entries[0].Module == nil) {
+		t.Errorf("got %+v, %v", entries, err)
+	}
+}
+
+func TestLoadCompressed(t *testing.T) {
+	dir := t.TempDir()
+	mod := buildTestMOD("M.K.", 4, []byte{0})
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(mod)
+	zw.Close()
+
+	// buildTestMOD("M.K.", 4, []byte{0}), compressed with bzip2 -9
+	bz, _ := hex.DecodeString("425a68393141592653599aa41ec600001b5590e0004001000a02818c0480040008200021a1341a0f28530004d13980d5890ccc8d6eea7c5151c09c01f1772453850909aa41ec60")
+
+	var tgz bytes.Buffer
+	zw = gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "songs/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"songs/one.mod", mod},
+		{"songs/two.xm", buildTestXM(4, []int{64}, [][]byte{nil})},
+		{"songs/info.txt", []byte("ripped from a demo disk")},
+	} {
+		tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file.data))})
+		tw.Write(file.data)
+	}
+	tw.Close()
+	zw.Close()
+
+	// compressed modules inside a zip
+	var zipped bytes.Buffer
+	zipw := zip.NewWriter(&zipped)
+	for name, data := range map[string][]byte{"song.mod.gz": gz.Bytes(), "song.mod.bz2": bz} {
+		w, _ := zipw.Create(name)
+		w.Write(data)
+	}
+	zipw.Close()
+
+	tests := []struct {
+		desc string
+		data []byte
+		name string
+		filename string
+	}{
+		{"gzip", gz.Bytes(), "song.mod.gz", "song.mod.gz"},
+		{"bzip2", bz, "song.mod.bz2", "song.mod.bz2"},
+		{"tar.gz member", tgz.Bytes(), "pack.tar.gz#songs/one.mod", "pack.tar.gz#songs/one.mod"},
+		{"gzip zip member", zipped.Bytes(), "pack.zip#song.mod.gz", "pack.zip#song.mod.gz"},
+		{"bzip2 zip member", zipped.Bytes(), "pack.zip#song.mod.bz2", "pack.zip#song.mod.bz2"},
+	}
+	for _, tt := range tests {
+		file, _, _ := strings.Cut(tt.name, "#")
+		if err := os.WriteFile(filepath.Join(dir, file), tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Load(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if (m.Type() != PROTRACKER || m.Filename() != filepath.Join(dir, tt.filename)) {
+			t.Errorf("%s: got type %d filename %q", tt.desc, m.Type(), m.Filename())
+		}
+	}
+
+	entries, err := LoadArchive(filepath.Join(dir, "pack.tar.gz"))
+	if (err != nil || len(entries) != 2 || entries[0].Path != "songs/one.mod" || entries[1].Path != "songs/two.xm") {
+		t.Errorf("got %+v, %v", entries, err)
+	}
+
+	entries, err = LoadArchive(filepath.Join(dir, "pack.zip"))
+	if (err != nil || len(entries) != 2 || entries[0].Module == nil || entries[1].Module == nil) {
+		t.Errorf("got %+v, %v", entries, err)
+	}
+	if data, err := ReadFile(filepath.Join(dir, "pack.zip#song.mod.gz")); (err != nil || !bytes.Equal(data, mod)) {
+		t.Errorf("Expected the unpacked module reading a member, got %d bytes, %v", len(data), err)
+	}
+
+	// a gzip that unpacks to more than MaxSize
+	if _, err := LoadWithOptions(filepath.Join(dir, "song.mod.gz"), LoadOptions{MaxSize: 1000}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+	// two members that only exceed it together once unpacked
+	if _, err := LoadWithOptions(filepath.Join(dir, "pack.zip"), LoadOptions{MaxSize: int64(len(mod)) + 100}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded for the zip, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package module
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// NoisePacker 2 and 3 modules start with an 8 byte header: a word holding
+// the number of samples above a low nibble that's always 0xC, then the sizes
+// of the position list, the track table and the track data. Next come 16
+// byte headers for only as many samples as are used, each giving the
+// sample's length, finetune, volume, loop length and loop start in bytes,
+// alongside addresses the replayer fills in. Each position is the offset of
+// a pattern in the track table, which gives the offset of each of the
+// pattern's tracks in the track data, fourth channel first. Notes are 3
+// bytes: the note number (1 for C-1 up to 36) and the top bit of the sample
+// number, the rest of the sample number and the effect, then the parameter.
+// Some effects are stored differently: arpeggio is effect 8, as effect 0
+// means none, effect 7 is a volume slide rather than tremolo, volume slides
+// are a signed byte, and position jumps are stored as the position times 2
+// less 4, the offset of the position in the list less the header. Version 2 stores all 64 rows of each track, while
+// version 3 packs runs of empty rows into a single byte, 0x100 less the
+// number of rows.
+const noisePackerHeaderSize = 8
+
+func parseNoisePacker(data []byte, version int) (packedMOD, error) {
+	r := reader{format: fmt.Sprintf("noisepacker%d", version), data: data}
+	if err := r.check("header", 0, noisePackerHeaderSize); err != nil {
+		return packedMOD{}, err
+	}
+	first := binary.BigEndian.Uint16(data[0:2])
+	numSamples := int(first >> 4)
+	if (first & 0x0F != 0x0C || numSamples == 0 || numSamples > 31) {
+		return packedMOD{}, r.invalid("numSamples", 0, first)
+	}
+	positionsSize := int(binary.BigEndian.Uint16(data[2:4]))
+	if (positionsSize == 0 || positionsSize % 2 != 0 || positionsSize > 256) {
+		return packedMOD{}, r.invalid("positionsSize", 2, positionsSize)
+	}
+	trackTableSize := int(binary.BigEndian.Uint16(data[4:6]))
+	if (trackTableSize == 0 || trackTableSize % 8 != 0) {
+		return packedMOD{}, r.invalid("trackTableSize", 4, trackTableSize)
+	}
+	trackDataSize := int(binary.BigEndian.Uint16(data[6:8]))
+
+	p := packedMOD{samples: make([]byte, 31*8), songLength: positionsSize / 2, restartPos: 0x7F}
+	offset := noisePackerHeaderSize
+	if err := r.check("samples", offset, numSamples*16); err != nil {
+		return packedMOD{}, err
+	}
+	sampleSize := 0
+	for i := 0; i < numSamples; i++ {
+		header := data[offset+i*16:offset+i*16+16]
+		field := fmt.Sprintf("sample[%d]", i)
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		loopStart := int(binary.BigEndian.Uint16(header[14:16])) / 2
+		if (header[6] > 0x0F) {
+			return packedMOD{}, r.invalid(field + ".finetune", offset + i*16 + 6, header[6])
+		}
+		if (header[7] > 0x40) {
+			return packedMOD{}, r.invalid(field + ".volume", offset + i*16 + 7, header[7])
+		}
+		if (loopStart > length) {
+			return packedMOD{}, r.invalid(field + ".loopStart", offset + i*16 + 14, loopStart)
+		}
+		sample := p.samples[i*8:i*8+8]
+		binary.BigEndian.PutUint16(sample[0:2], uint16(length))
+		sample[2] = header[6]
+		sample[3] = header[7]
+		binary.BigEndian.PutUint16(sample[4:6], uint16(loopStart))
+		copy(sample[6:8], header[12:14])
+		sampleSize += length * 2
+	}
+	if (sampleSize == 0) {
+		return packedMOD{}, r.invalid("samples", offset, "no sample data")
+	}
+	offset += numSamples*16
+
+	if err := r.check("positions", offset, positionsSize + trackTableSize); err != nil {
+		return packedMOD{}, err
+	}
+	trackTable := offset + positionsSize
+	trackData := trackTable + trackTableSize
+	if err := r.check("trackData", trackData, trackDataSize); err != nil {
+		return packedMOD{}, err
+	}
+	// tracks can't run on into the sample data
+	tracks := reader{format: r.format, data: data[:trackData+trackDataSize]}
+	notes := make(map[int][]byte)
+	for pos := 0; pos < p.songLength; pos++ {
+		pattern := int(binary.BigEndian.Uint16(data[offset+pos*2:offset+pos*2+2]))
+		if (pattern % 8 != 0 || pattern >= trackTableSize) {
+			return packedMOD{}, r.invalid(fmt.Sprintf("position[%d]", pos), offset + pos*2, pattern)
+		}
+		for channel := 0; channel < 4; channel++ {
+			tableOffset := trackTable + pattern + (3 - channel)*2
+			track := int(binary.BigEndian.Uint16(data[tableOffset:tableOffset+2]))
+			if _, ok := notes[track]; !ok {
+				trackNotes, err := readNoisePackerTrack(tracks, trackData + track, version)
+				if err != nil {
+					return packedMOD{}, err
+				}
+				notes[track] = trackNotes
+			}
+			p.tracks[channel][pos] = track
+		}
+	}
+	p.note = func(track int, row int) []byte {
+		return notes[track][row*4:row*4+4]
+	}
+
+	var err error
+	p.sampleData, err = r.slice("sampleData", trackData + trackDataSize, sampleSize)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	p.end = trackData + trackDataSize + sampleSize
+	return p, nil
+}
+
+// readNoisePackerTrack converts the track at offset to 64 MOD notes
+func readNoisePackerTrack(r reader, offset int, version int) ([]byte, error) {
+	start := offset
+	notes := make([]byte, 0, 256)
+	for row := 0; row < 64; {
+		field := fmt.Sprintf("track[%d].row[%d]", start, row)
+		if err := r.check(field, offset, 1); err != nil {
+			return nil, err
+		}
+		if (version == 3 && r.data[offset] >= 0x80) {
+			empty := 0x100 - int(r.data[offset])
+			if (row + empty > 64) {
+				return nil, r.invalid(field, offset, empty)
+			}
+			notes = append(notes, make([]byte, empty*4)...)
+			row += empty
+			offset++
+			continue
+		}
+		if err := r.check(field, offset, 3); err != nil {
+			return nil, err
+		}
+		note := r.data[offset:offset+3]
+		number := int(note[0] >> 1)
+		if (number > 36) {
+			return nil, r.invalid(field, offset, fmt.Sprintf("%x", note))
+		}
+		effect, parameter := note[1] & 0x0F, note[2]
+		switch effect {
+		case 0x08:
+			effect = 0x00
+		case 0x07:
+			effect = 0x0A
+			fallthrough
+		case 0x05, 0x06, 0x0A:
+			if (parameter > 0x80) {
+				parameter = 0x00 - parameter
+			} else {
+				parameter <<= 4
+			}
+		case 0x0B:
+			parameter = byte((int(parameter) + 4) / 2)
+		}
+		notes = append(notes, modNote(packedPeriod(number), int(note[0] & 1) << 4 | int(note[1] >> 4), effect, parameter)...)
+		row++
+		offset += 3
+	}
+	return notes, nil
+}
+
+func detectNoisePacker(data []byte, version int) int {
+	p, err := parseNoisePacker(data, version)
+	if (err == nil && version == 3) {
+		if _, err := parseNoisePacker(data, 2); err == nil {
+			// with no empty rows to pack, version 3 reads the same as 2
+			return SCORE_NONE
+		}
+	}
+	return scorePacked(p, err, data)
+}
+
+func unpackNoisePacker(data []byte, version int) ([]byte, error) {
+	p, err := parseNoisePacker(data, version)
+	if err != nil {
+		return nil, err
+	}
+	return rebuildMOD(p), nil
+}
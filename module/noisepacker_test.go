@@ -0,0 +1,157 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// packNoisePacker packs a 4 channel MOD as the given version of NoisePacker does
+func packNoisePacker(mod []byte, version int) []byte {
+	numSamples, numPatterns := modCounts(mod)
+	songLength := int(mod[950])
+
+	out := binary.BigEndian.AppendUint16(nil, uint16(numSamples << 4 | 0x0C))
+	out = binary.BigEndian.AppendUint16(out, uint16(songLength*2))
+	out = binary.BigEndian.AppendUint16(out, uint16(numPatterns*8))
+	sizeOffset := len(out)
+	out = append(out, 0, 0)
+	for i := 0; i < numSamples; i++ {
+		header := mod[20+i*30+22:20+i*30+30]
+		out = append(out, 0, 0, 0, 0)
+		out = append(out, header[0:4]...)
+		out = append(out, 0, 0, 0, 0)
+		out = append(out, header[6:8]...)
+		out = binary.BigEndian.AppendUint16(out, binary.BigEndian.Uint16(header[4:6]) * 2)
+	}
+	for pos := 0; pos < songLength; pos++ {
+		out = binary.BigEndian.AppendUint16(out, uint16(mod[952+pos]) * 8)
+	}
+
+	trackOffsets := make(map[string]int)
+	trackData := make([]byte, 0)
+	for pattern := 0; pattern < numPatterns; pattern++ {
+		for channel := 3; channel >= 0; channel-- {
+			track := make([]byte, 0)
+			empty := 0
+			for row := 0; row < 64; row++ {
+				note := modPatternNote(mod, pattern, row, channel)
+				if (version == 3 && bytes.Equal(note, make([]byte, 4))) {
+					empty++
+					continue
+				}
+				if (empty > 0) {
+					track = append(track, byte(0x100 - empty))
+					empty = 0
+				}
+				period := int(note[0] & 0x0F) << 8 | int(note[1])
+				sample := int(note[0] & 0xF0) | int(note[2] >> 4)
+				effect, parameter := note[2] & 0x0F, note[3]
+				switch effect {
+				case 0x00:
+					if (parameter != 0) {
+						effect = 0x08
+					}
+				case 0x0A:
+					effect = 0x07
+					fallthrough
+				case 0x05, 0x06:
+					if (parameter & 0xF0 != 0) {
+						parameter >>= 4
+					} else {
+						parameter = 0x00 - parameter
+					}
+				case 0x0B:
+					parameter = parameter*2 - 4
+				}
+				track = append(track, byte(packedNumber(period) << 1 | sample >> 4), byte(sample << 4) | effect, parameter)
+			}
+			if (empty > 0) {
+				track = append(track, byte(0x100 - empty))
+			}
+			offset, ok := trackOffsets[string(track)]
+			if !ok {
+				offset = len(trackData)
+				trackOffsets[string(track)] = offset
+				trackData = append(trackData, track...)
+			}
+			out = binary.BigEndian.AppendUint16(out, uint16(offset))
+		}
+	}
+	binary.BigEndian.PutUint16(out[sizeOffset:], uint16(len(trackData)))
+	out = append(out, trackData...)
+	return append(out, mod[1084+numPatterns*1024:]...)
+}
+
+func TestNoisePacker(t *testing.T) {
+	mod := buildPackableMOD()
+	// volume slides, which NoisePacker stores signed
+	copy(modPatternNote(mod, 0, 0, 0)[2:4], []byte{0x1A, 0x04})
+	copy(modPatternNote(mod, 2, 0, 2)[2:4], []byte{0x15, 0x30})
+	// arpeggio, stored as effect 8, and a position jump
+	copy(modPatternNote(mod, 1, 0, 1)[2:4], []byte{0x10, 0x37})
+	copy(modPatternNote(mod, 1, 60, 0)[2:4], []byte{0x1B, 0x03})
+
+	for _, tt := range []struct {
+		version int
+		format string
+		tracker string
+	}{
+		{2, "noisepacker2", "NoisePacker 2"},
+		{3, "noisepacker3", "NoisePacker 3"},
+	} {
+		packed := packNoisePacker(mod, tt.version)
+		format, err := DetectFormat(packed, "")
+		if (err != nil || format.Name != tt.format) {
+			t.Errorf("%s: detected as %q, %v", tt.format, format.Name, err)
+		}
+
+		unpacked, err := unpackNoisePacker(packed, tt.version)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+		} else if !bytes.Equal(unpacked, mod) {
+			t.Errorf("%s: unpacked module doesn't match the original", tt.format)
+		}
+
+		m, err := LoadBytes(packed, "song")
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+			continue
+		}
+		pt := m.(*ProTracker)
+		if (pt.Type() != PROTRACKER || pt.NumPatterns() != 3 || pt.Tracker().String() != tt.tracker) {
+			t.Errorf("%s: got type %d with %d patterns from %s", tt.format, pt.Type(), pt.NumPatterns(), pt.Tracker())
+		}
+		sample, err := pt.GetSample(0)
+		if (err != nil || len(sample.Data()) != 32 || sample.Data()[31] != 248) {
+			t.Errorf("%s: got sample %+v, %v", tt.format, sample, err)
+		}
+		for _, want := range []struct {
+			pattern, row, channel int
+			effect, parameter int
+		}{
+			{0, 0, 0, 0xA, 0x04},
+			{2, 0, 2, 0x5, 0x30},
+			{1, 0, 1, 0x0, 0x37},
+			{1, 60, 0, 0xB, 0x03},
+		} {
+			row, _ := pt.patterns[want.pattern].GetRow(want.row)
+			note := row.Notes()[want.channel]
+			if (note.Instrument() != 1 || note.Effect() != want.effect || note.Parameter() != want.parameter) {
+				t.Errorf("%s: pattern %d row %d: got note %+v", tt.format, want.pattern, want.row, note)
+			}
+		}
+	}
+
+	// version 3 packs the empty rows
+	if (len(packNoisePacker(mod, 3)) >= len(packNoisePacker(mod, 2))) {
+		t.Errorf("Expected NoisePacker 3 to be smaller than 2")
+	}
+	// a run of empty rows past the end of a track
+	packed := packNoisePacker(mod, 3)
+	trackData := noisePackerHeaderSize + 16 + 4*2 + 3*8
+	packed[trackData] = 0x80
+	if _, err := unpackNoisePacker(packed, 3); err == nil {
+		t.Errorf("Expected an error for too many empty rows")
+	}
+}
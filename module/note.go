@@ -9,6 +9,8 @@ type Note struct {
 	key int
 	instrument int
 	period int
+	volume int
+	hasVolume bool
 	effect int
 	parameter int
 }
@@ -29,6 +31,16 @@ var periodLookup = []int {
     107,  101,   95,   90,   85,   80,   75,   71,   67,   63,  60,  56,  // 0ctave 4
 }
 
+// Special key values shared by all formats. Regular notes are numbered from 1 (C-0) to 120 (B-9).
+const (
+	KeyFade = 253
+	KeyCut = 254
+	KeyOff = 255
+)
+
+// xmKeyOff is the XM note value used to release the currently playing note
+const xmKeyOff = 97
+
 var notes = []string {
 	"C","C#","D","D#","E","F","F#","G","G#","A","A#","B",
 }
@@ -44,6 +56,9 @@ var notes = []string {
 //ple number.                  ple number.
 
 func (n *Note) Load(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("Truncated note data")
+	}
 
 	periodUpper := int(data[0] & 0x0F)
 	periodLower := int(data[1])
@@ -59,8 +74,102 @@ func (n *Note) Load(data []byte) error {
 	return nil
 }
 
+// NewXMNote creates a note from the five XM note fields
+func NewXMNote(key int, instrument int, volume int, effect int, parameter int) Note {
+	return Note{key: key, instrument: instrument, volume: volume, hasVolume: volume != 0, effect: effect, parameter: parameter}
+}
+
+// XM notes are stored as up to five bytes: note, instrument, volume column,
+// effect type and effect parameter. If the high bit of the first byte is set
+// it is instead a mask describing which of the five fields follow:
+//
+//	bit 0: note follows
+//	bit 1: instrument follows
+//	bit 2: volume column byte follows
+//	bit 3: effect type follows
+//	bit 4: effect parameter follows
+//
+// LoadXM returns the number of bytes consumed from data.
+func (n *Note) LoadXM(data []byte) (int, error) {
+	if len(data) < 1 {
+		return 0, errors.New("Missing XM note data")
+	}
+
+	if (data[0] & 0x80) == 0 {
+		if len(data) < 5 {
+			return 0, errors.New("Truncated XM note data")
+		}
+		n.key = int(data[0])
+		n.instrument = int(data[1])
+		n.volume = int(data[2])
+		n.effect = int(data[3])
+		n.parameter = int(data[4])
+		n.normalizeXM()
+		return 5, nil
+	}
+
+	mask := data[0]
+	pos := 1
+	fields := []*int{&n.key, &n.instrument, &n.volume, &n.effect, &n.parameter}
+	for bit, field := range fields {
+		if (mask & (1 << bit)) == 0 {
+			continue
+		}
+		if pos >= len(data) {
+			return pos, errors.New("Truncated packed XM note data")
+		}
+		*field = int(data[pos])
+		pos++
+	}
+	n.normalizeXM()
+	return pos, nil
+}
+
+// normalizeXM converts the XM specific key off value and notes the presence of
+// a volume column, which in XM is only ever empty when zero
+func (n *Note) normalizeXM() {
+	if (n.key == xmKeyOff) {
+		n.key = KeyOff
+	}
+	n.hasVolume = n.volume != 0
+}
+
+// SaveXM encodes the note in the packed XM format. As FastTracker does, the
+// note is written unpacked when all five fields are in use, since packing
+// wouldn't save anything.
+func (n *Note) SaveXM() []byte {
+	key := n.key
+	if (key == KeyOff) {
+		key = xmKeyOff
+	}
+	fields := []int{key, n.instrument, n.volume, n.effect, n.parameter}
+	mask := byte(0x80)
+	packed := make([]byte, 1, 6)
+	for bit, field := range fields {
+		if (field != 0) {
+			mask |= 1 << bit
+			packed = append(packed, byte(field))
+		}
+	}
+	if (mask == 0x9F) {
+		return packed[1:]
+	}
+	packed[0] = mask
+	return packed
+}
+
+// IsEmpty reports whether the note has no data at all
+func (n *Note) IsEmpty() bool {
+	return n.key == 0 && n.instrument == 0 && n.period == 0 && !n.hasVolume && n.volume == 0 && n.effect == 0 && n.parameter == 0
+}
+
 func (n *Note) ToString() (string, error) {
 
+	// XM notes carry a key number rather than an Amiga period
+	if (n.key > 0) {
+		return keyToString(n.key)
+	}
+
 	// First find the octave
 	octave := 0
 	for i := 0; i < len(periodLookup); i+=12 {
@@ -71,6 +180,9 @@ func (n *Note) ToString() (string, error) {
 		}
 	}
 	octave--
+	if (octave < 0) {
+		return "",errors.New("unable to find note for period")
+	}
 	// Now find the note within that octave
 	offset := octave * 12
 	position := 0
@@ -108,7 +220,43 @@ func periodToString(periodPos int, octave int) (string,error) {
 	}
 }
 
+// keyToString converts a key number (1 = C-0, 120 = B-9) or special key to a string
+func keyToString(key int) (string,error) {
+	switch key {
+	case KeyOff:
+		return "===",nil
+	case KeyCut:
+		return "^^^",nil
+	case KeyFade:
+		return "~~~",nil
+	}
+	if (key < 1 || key > 120) {
+		return "",errors.New("Invalid key to convert to string")
+	}
+	pitch := notes[(key-1) % 12]
+	octave := (key-1) / 12
+	if (strings.HasSuffix(pitch, "#")) {
+		return fmt.Sprintf("%s%d", pitch, octave),nil
+	} else {
+		return fmt.Sprintf("%s-%d", pitch, octave),nil
+	}
+}
+
 // Getters for exporting note data
+func (n *Note) Key() int {
+	return n.key
+}
+
+func (n *Note) Volume() int {
+	return n.volume
+}
+
+// HasVolume reports whether the note has a volume column entry, which for
+// S3M and IT can legitimately be zero
+func (n *Note) HasVolume() bool {
+	return n.hasVolume
+}
+
 func (n *Note) Period() int {
 	return n.period
 }
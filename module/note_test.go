@@ -42,7 +42,7 @@ func TestNoteLoad(t *testing.T) {
 	}
 	notestr,_ := n.ToString()
 	if (notestr != "A#3") {
-		t.Errorf("Expected %s, got %s","A#3")
+		t.Errorf("Expected %s, got %s","A#3",notestr)
 	}
 
 	n.Load(testNote3)
@@ -51,7 +51,7 @@ func TestNoteLoad(t *testing.T) {
 	}
 	notestr,_ = n.ToString()
 	if (notestr != "A#3") {
-		t.Errorf("Expected %s, got %s","A#3")
+		t.Errorf("Expected %s, got %s","A#3",notestr)
 	}
 
 	n.Load(testNote4)
@@ -60,7 +60,7 @@ func TestNoteLoad(t *testing.T) {
 	}
 	notestr,_ = n.ToString()
 	if (notestr != "A#3") {
-		t.Errorf("Expected %s, got %s","A#3")
+		t.Errorf("Expected %s, got %s","A#3",notestr)
 	}
 
 }
@@ -0,0 +1,119 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is wrapped by a LimitError when a file goes over one of the LoadOptions limits
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LoadOptions limits the resources used loading a file, to protect against
+// zip bombs and hostile headers. A zero value means the default limit.
+type LoadOptions struct {
+	// MaxCompressedSize limits the size of the file as stored, e.g. a zip
+	MaxCompressedSize int64
+	// MaxSize limits the size of the module data once unpacked, of all the
+	// entries read from an archive together, and of all a module's samples
+	// together once decompressed
+	MaxSize int64
+	// MaxEntries limits the number of entries in an archive
+	MaxEntries int
+	// MaxSamples limits the number of samples and instruments
+	MaxSamples int
+	// MaxPatterns limits the number of patterns
+	MaxPatterns int
+	// MaxChannels limits the number of channels
+	MaxChannels int
+}
+
+// DefaultLoadOptions returns limits that comfortably fit any real module
+func DefaultLoadOptions() LoadOptions {
+	return LoadOptions{
+		MaxCompressedSize: 64 << 20,
+		MaxSize: 256 << 20,
+		MaxEntries: 1024,
+		MaxSamples: 4000,
+		MaxPatterns: 1024,
+		MaxChannels: 64,
+	}
+}
+
+// withDefaults fills in the default for any limit that isn't set
+func (o LoadOptions) withDefaults() LoadOptions {
+	d := DefaultLoadOptions()
+	if (o.MaxCompressedSize == 0) {
+		o.MaxCompressedSize = d.MaxCompressedSize
+	}
+	if (o.MaxSize == 0) {
+		o.MaxSize = d.MaxSize
+	}
+	if (o.MaxEntries == 0) {
+		o.MaxEntries = d.MaxEntries
+	}
+	if (o.MaxSamples == 0) {
+		o.MaxSamples = d.MaxSamples
+	}
+	if (o.MaxPatterns == 0) {
+		o.MaxPatterns = d.MaxPatterns
+	}
+	if (o.MaxChannels == 0) {
+		o.MaxChannels = d.MaxChannels
+	}
+	return o
+}
+
+// LimitError reports which limit a file went over
+type LimitError struct {
+	Limit string
+	Value int64
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// checkLimit returns a LimitError if value is over max
+func checkLimit(limit string, value int64, max int64) error {
+	if (value > max) {
+		return &LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+// loadState is embedded by the built in formats to hold what they were
+// loaded with. Keeping the limits lets them check header counts before
+// allocating anything.
+type loadState struct {
+	options LoadOptions
+	filename string
+}
+
+func (l *loadState) setLoadState(options LoadOptions, filename string) {
+	l.options = options
+	l.filename = filename
+}
+
+// limits returns the limits to load with, which are the defaults unless set
+func (l *loadState) limits() LoadOptions {
+	return l.options.withDefaults()
+}
+
+// loadStateSetter is implemented by modules that embed a loadState
+type loadStateSetter interface {
+	setLoadState(options LoadOptions, filename string)
+}
+
+// checkModuleLimits checks a loaded module against the limits, catching
+// registered formats that don't check them while loading
+func checkModuleLimits(m Module, options LoadOptions) error {
+	if err := checkLimit("MaxSamples", int64(len(m.Samples())), int64(options.MaxSamples)); err != nil {
+		return err
+	}
+	return checkLimit("MaxPatterns", int64(m.NumPatterns()), int64(options.MaxPatterns))
+}
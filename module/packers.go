@@ -0,0 +1,343 @@
+package module
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Packed ProTracker modules, as found in game rips and demos. Packers
+// rearranged the MOD structure to save space and to put off rippers: the
+// title and sample names are dropped, and patterns are split into tracks of
+// one channel each that can be shared between patterns. Each packer is
+// registered as a format of its own, and loads as a standard ProTracker
+// module rebuilt from the packed data.
+
+// modPacker describes a packed MOD format and how to turn it back into a MOD
+type modPacker struct {
+	name string
+	extensions []string
+	tracker TrackerInfo
+	detect func([]byte) int
+	unpack func([]byte) ([]byte, error)
+}
+
+var modPackers = []modPacker{
+	{
+		name: "propacker10",
+		extensions: []string{".pp10"},
+		tracker: TrackerInfo{Name: "ProPacker", Version: "1.0", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectProPacker(data, 10) },
+		unpack: func(data []byte) ([]byte, error) { return unpackProPacker(data, 10) },
+	},
+	{
+		name: "propacker21",
+		extensions: []string{".pp21"},
+		tracker: TrackerInfo{Name: "ProPacker", Version: "2.1", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectProPacker(data, 21) },
+		unpack: func(data []byte) ([]byte, error) { return unpackProPacker(data, 21) },
+	},
+	{
+		name: "propacker30",
+		extensions: []string{".pp30"},
+		tracker: TrackerInfo{Name: "ProPacker", Version: "3.0", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectProPacker(data, 30) },
+		unpack: func(data []byte) ([]byte, error) { return unpackProPacker(data, 30) },
+	},
+	{
+		name: "noisepacker2",
+		extensions: []string{".np2"},
+		tracker: TrackerInfo{Name: "NoisePacker", Version: "2", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectNoisePacker(data, 2) },
+		unpack: func(data []byte) ([]byte, error) { return unpackNoisePacker(data, 2) },
+	},
+	{
+		name: "noisepacker3",
+		extensions: []string{".np3"},
+		tracker: TrackerInfo{Name: "NoisePacker", Version: "3", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectNoisePacker(data, 3) },
+		unpack: func(data []byte) ([]byte, error) { return unpackNoisePacker(data, 3) },
+	},
+	{
+		name: "theplayer50",
+		extensions: []string{".p50a"},
+		tracker: TrackerInfo{Name: "The Player", Version: "5.0A", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectPlayer(data, 50) },
+		unpack: func(data []byte) ([]byte, error) { return unpackPlayer(data, 50) },
+	},
+	{
+		name: "theplayer60",
+		extensions: []string{".p60a", ".p60"},
+		tracker: TrackerInfo{Name: "The Player", Version: "6.0A", Confidence: CONFIDENCE_MEDIUM},
+		detect: func(data []byte) int { return detectPlayer(data, 60) },
+		unpack: func(data []byte) ([]byte, error) { return unpackPlayer(data, 60) },
+	},
+	{
+		name: "promizer20",
+		extensions: []string{".pm20"},
+		tracker: TrackerInfo{Name: "Promizer", Version: "2.0", Confidence: CONFIDENCE_MEDIUM},
+		detect: detectPromizer,
+		unpack: unpackPromizer,
+	},
+}
+
+func init() {
+	for i := range modPackers {
+		packer := &modPackers[i]
+		Register(Format{
+			Name: packer.name,
+			Extensions: packer.extensions,
+			Detect: packer.detect,
+			New: func() Module { return &ProTracker{packer: packer} },
+		})
+	}
+}
+
+// packedMOD is a packed module broken down into the parts of a MOD
+type packedMOD struct {
+	// samples holds the 31 sample headers, each laid out as in a MOD after
+	// the name: length, finetune, volume, loop start and loop length
+	samples []byte
+	songLength int
+	restartPos byte
+	// tracks gives the track played on each channel at each position
+	tracks [4][128]int
+	// note returns the 4 byte MOD note for a row of a track
+	note func(track int, row int) []byte
+	sampleData []byte
+	// end is the offset just past the sample data
+	end int
+}
+
+// rebuildMOD writes a packed module out as a 4 channel MOD, with a pattern
+// for each distinct combination of tracks
+func rebuildMOD(p packedMOD) []byte {
+	out := make([]byte, 20)
+	for i := 0; i < 31; i++ {
+		out = append(out, make([]byte, 22)...)
+		out = append(out, p.samples[i*8:i*8+8]...)
+	}
+	out = append(out, byte(p.songLength), p.restartPos)
+
+	patternNumbers := make(map[[4]int]int)
+	patterns := make([][4]int, 0)
+	orders := make([]byte, 128)
+	for pos := 0; pos < p.songLength; pos++ {
+		key := [4]int{p.tracks[0][pos], p.tracks[1][pos], p.tracks[2][pos], p.tracks[3][pos]}
+		number, ok := patternNumbers[key]
+		if !ok {
+			number = len(patterns)
+			patternNumbers[key] = number
+			patterns = append(patterns, key)
+		}
+		orders[pos] = byte(number)
+	}
+	out = append(out, orders...)
+	out = append(out, "M.K."...)
+
+	for _, tracks := range patterns {
+		for row := 0; row < 64; row++ {
+			for _, track := range tracks {
+				out = append(out, p.note(track, row)...)
+			}
+		}
+	}
+	return append(out, p.sampleData...)
+}
+
+// packedPeriod gives the ProTracker period for the note numbers many packers
+// store, from 1 for C-1 up to 36 for B-3, with 0 for no note
+func packedPeriod(number int) int {
+	if (number == 0) {
+		return 0
+	}
+	return periodLookup[11+number]
+}
+
+// modNote returns a 4 byte MOD note
+func modNote(period int, sample int, effect byte, parameter byte) []byte {
+	return []byte{byte(sample & 0xF0 | period >> 8), byte(period), byte(sample << 4) | effect & 0x0F, parameter}
+}
+
+// checkPackedSamples checks the 31 sample headers at offset, laid out as in
+// a MOD less the name, and returns the size of their data
+func checkPackedSamples(r reader, offset int) (int, error) {
+	if err := r.check("samples", offset, 31*8); err != nil {
+		return 0, err
+	}
+	sampleSize := 0
+	for i := 0; i < 31; i++ {
+		header := r.data[offset+i*8:offset+i*8+8]
+		length := int(binary.BigEndian.Uint16(header[0:2]))
+		if (header[2] > 0x0F) {
+			return 0, r.invalid(fmt.Sprintf("sample[%d].finetune", i), offset + i*8 + 2, header[2])
+		}
+		if (header[3] > 0x40) {
+			return 0, r.invalid(fmt.Sprintf("sample[%d].volume", i), offset + i*8 + 3, header[3])
+		}
+		if (int(binary.BigEndian.Uint16(header[4:6])) > length) {
+			return 0, r.invalid(fmt.Sprintf("sample[%d].loopStart", i), offset + i*8 + 4, binary.BigEndian.Uint16(header[4:6]))
+		}
+		sampleSize += length * 2
+	}
+	if (sampleSize == 0) {
+		return 0, r.invalid("samples", offset, "no sample data")
+	}
+	return sampleSize, nil
+}
+
+// checkPackedNote makes sure a packed note holds a MOD note, with a sample
+// number of 31 or less and a period in ProTracker's range
+func checkPackedNote(r reader, field string, offset int) error {
+	note := r.data[offset:offset+4]
+	period := int(note[0] & 0x0F) << 8 | int(note[1])
+	if (note[0] & 0xE0 != 0 || (period != 0 && (period < 108 || period > 1016))) {
+		return r.invalid(field, offset, fmt.Sprintf("%x", note))
+	}
+	return nil
+}
+
+// scorePacked scores a packed module that parsed cleanly by how well its
+// size matches the data. Rips often carry a few bytes of junk at the end.
+func scorePacked(p packedMOD, err error, data []byte) int {
+	if err != nil {
+		return SCORE_NONE
+	}
+	if (p.end == len(data)) {
+		return SCORE_LIKELY
+	}
+	return SCORE_WEAK
+}
+
+// ProPacker modules start with the MOD sample headers less their names,
+// then the song length and restart byte, then a table of the track played
+// on each channel at each position, for all 128 positions of the first
+// channel, then of the second and so on. Version 1.0 follows this with the
+// tracks themselves, 64 MOD notes each. Versions 2.1 and 3.0 instead store
+// each distinct note once in a reference table, with the tracks holding
+// 16 bit references to it: an index in 2.1, and a byte offset in 3.0.
+const proPackerHeaderSize = 31*8 + 2 + 4*128
+
+func parseProPacker(data []byte, version int) (packedMOD, error) {
+	r := reader{format: fmt.Sprintf("propacker%d", version), data: data}
+	if err := r.check("header", 0, proPackerHeaderSize); err != nil {
+		return packedMOD{}, err
+	}
+	p := packedMOD{samples: data[0:248], songLength: int(data[248]), restartPos: data[249]}
+
+	sampleSize, err := checkPackedSamples(r, 0)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	if (p.songLength == 0 || p.songLength > 128) {
+		return packedMOD{}, r.invalid("songLength", 248, p.songLength)
+	}
+
+	numTracks := 0
+	for channel := 0; channel < 4; channel++ {
+		for pos := 0; pos < 128; pos++ {
+			p.tracks[channel][pos] = int(data[250 + channel*128 + pos])
+			if (p.tracks[channel][pos] >= numTracks) {
+				numTracks = p.tracks[channel][pos] + 1
+			}
+		}
+	}
+
+	offset := proPackerHeaderSize
+	if (version == 10) {
+		if err := r.check("tracks", offset, numTracks*256); err != nil {
+			return packedMOD{}, err
+		}
+		for i := 0; i < numTracks*64; i++ {
+			if err := checkPackedNote(r, fmt.Sprintf("track[%d].row[%d]", i/64, i%64), offset + i*4); err != nil {
+				return packedMOD{}, err
+			}
+		}
+		tracks := data[offset:offset+numTracks*256]
+		p.note = func(track int, row int) []byte {
+			return tracks[track*256+row*4:track*256+row*4+4]
+		}
+		offset += numTracks*256
+	} else {
+		if err := r.check("tracks", offset, numTracks*128 + 4); err != nil {
+			return packedMOD{}, err
+		}
+		tracks := data[offset:offset+numTracks*128]
+		offset += numTracks*128
+		tableSize := int(binary.BigEndian.Uint32(data[offset:offset+4]))
+		offset += 4
+		if (tableSize % 4 != 0) {
+			return packedMOD{}, r.invalid("referenceTable.size", offset - 4, tableSize)
+		}
+		table, err := r.slice("referenceTable", offset, tableSize)
+		if err != nil {
+			return packedMOD{}, err
+		}
+		for i := 0; i < tableSize; i += 4 {
+			if err := checkPackedNote(r, fmt.Sprintf("referenceTable[%d]", i/4), offset + i); err != nil {
+				return packedMOD{}, err
+			}
+		}
+		for i := 0; i < numTracks*64; i++ {
+			ref := int(binary.BigEndian.Uint16(tracks[i*2:i*2+2]))
+			if (version == 21) {
+				ref *= 4
+			}
+			if (ref % 4 != 0 || ref + 4 > tableSize) {
+				return packedMOD{}, r.invalid(fmt.Sprintf("track[%d].row[%d]", i/64, i%64), proPackerHeaderSize + i*2, ref)
+			}
+		}
+		p.note = func(track int, row int) []byte {
+			ref := int(binary.BigEndian.Uint16(tracks[track*128+row*2:track*128+row*2+2]))
+			if (version == 21) {
+				ref *= 4
+			}
+			return table[ref:ref+4]
+		}
+		offset += tableSize
+	}
+
+	p.sampleData, err = r.slice("sampleData", offset, sampleSize)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	p.end = offset + sampleSize
+	return p, nil
+}
+
+func detectProPacker(data []byte, version int) int {
+	p, err := parseProPacker(data, version)
+	if (err == nil && version == 21 && proPackerRefsAligned(data, p)) {
+		// reads as 3.0 too, which is far more likely than a 2.1 module that
+		// only ever refers to every fourth note
+		return SCORE_NONE
+	}
+	return scorePacked(p, err, data)
+}
+
+// proPackerRefsAligned reports whether every note reference in a ProPacker
+// 2.1 or 3.0 module is a multiple of 4
+func proPackerRefsAligned(data []byte, p packedMOD) bool {
+	numTracks := 0
+	for _, channel := range p.tracks {
+		for _, track := range channel {
+			if (track >= numTracks) {
+				numTracks = track + 1
+			}
+		}
+	}
+	for i := 0; i < numTracks*64; i++ {
+		offset := proPackerHeaderSize + i*2
+		if (binary.BigEndian.Uint16(data[offset:offset+2]) % 4 != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func unpackProPacker(data []byte, version int) ([]byte, error) {
+	p, err := parseProPacker(data, version)
+	if err != nil {
+		return nil, err
+	}
+	return rebuildMOD(p), nil
+}
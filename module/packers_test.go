@@ -0,0 +1,183 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildPackableMOD returns a MOD as a packer would leave it, with no title or
+// sample names, holding a few notes and a looped sample
+func buildPackableMOD() []byte {
+	mod := buildTestMOD("M.K.", 4, []byte{0, 1, 0, 2})
+	copy(mod[0:20], make([]byte, 20))
+	sample := mod[20:50]
+	binary.BigEndian.PutUint16(sample[22:24], 16)
+	sample[25] = 64
+	binary.BigEndian.PutUint16(sample[26:28], 4)
+	binary.BigEndian.PutUint16(sample[28:30], 8)
+
+	periods := []int{428, 214, 856}
+	for p := 0; p < 3; p++ {
+		for row := 0; row < 64; row += 4 {
+			offset := 1084 + p*1024 + row*16 + (row/4 + p) % 4 * 4
+			copy(mod[offset:offset+4], []byte{byte(periods[p] >> 8), byte(periods[p]), 0x1C, byte(row)})
+		}
+	}
+	for i := 0; i < 32; i++ {
+		mod = append(mod, byte(i*8))
+	}
+	return mod
+}
+
+// packedNumber returns the note number many packers store for a period,
+// from 1 for C-1 up to 36 for B-3
+func packedNumber(period int) int {
+	for number := 1; number <= 36; number++ {
+		if (packedPeriod(number) == period) {
+			return number
+		}
+	}
+	return 0
+}
+
+// modCounts returns the number of samples in a MOD, up to the last one with
+// data, and the number of patterns its song plays
+func modCounts(mod []byte) (int, int) {
+	numSamples := 0
+	for i := 0; i < 31; i++ {
+		if (binary.BigEndian.Uint16(mod[20+i*30+22:]) > 0) {
+			numSamples = i + 1
+		}
+	}
+	numPatterns := 0
+	for pos := 0; pos < int(mod[950]); pos++ {
+		if (int(mod[952+pos]) + 1 > numPatterns) {
+			numPatterns = int(mod[952+pos]) + 1
+		}
+	}
+	return numSamples, numPatterns
+}
+
+// modPatternNote returns the 4 byte note on a channel and row of a MOD pattern
+func modPatternNote(mod []byte, pattern int, row int, channel int) []byte {
+	offset := 1084 + pattern*1024 + row*16 + channel*4
+	return mod[offset:offset+4]
+}
+
+// packProPacker packs a 4 channel MOD as the given version of ProPacker does
+func packProPacker(mod []byte, version int) []byte {
+	out := make([]byte, 0)
+	for i := 0; i < 31; i++ {
+		out = append(out, mod[20+i*30+22:20+i*30+30]...)
+	}
+	_, numPatterns := modCounts(mod)
+	songLength := int(mod[950])
+	out = append(out, mod[950], mod[951])
+
+	trackNumbers := make(map[string]int)
+	tracks := make([][]byte, 0)
+	var table [4][128]byte
+	for pos := 0; pos < songLength; pos++ {
+		pattern := int(mod[952+pos])
+		for channel := 0; channel < 4; channel++ {
+			track := make([]byte, 0)
+			for row := 0; row < 64; row++ {
+				track = append(track, modPatternNote(mod, pattern, row, channel)...)
+			}
+			number, ok := trackNumbers[string(track)]
+			if !ok {
+				number = len(tracks)
+				trackNumbers[string(track)] = number
+				tracks = append(tracks, track)
+			}
+			table[channel][pos] = byte(number)
+		}
+	}
+	for _, channel := range table {
+		out = append(out, channel[:]...)
+	}
+
+	if (version == 10) {
+		for _, track := range tracks {
+			out = append(out, track...)
+		}
+	} else {
+		refs := make(map[string]int)
+		notes := make([]byte, 0)
+		for _, track := range tracks {
+			for row := 0; row < 64; row++ {
+				note := string(track[row*4:row*4+4])
+				ref, ok := refs[note]
+				if !ok {
+					ref = len(notes) / 4
+					refs[note] = ref
+					notes = append(notes, note...)
+				}
+				if (version == 30) {
+					ref *= 4
+				}
+				out = binary.BigEndian.AppendUint16(out, uint16(ref))
+			}
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(notes)))
+		out = append(out, notes...)
+	}
+	return append(out, mod[1084+numPatterns*1024:]...)
+}
+
+func TestProPacker(t *testing.T) {
+	mod := buildPackableMOD()
+	for _, tt := range []struct {
+		version int
+		format string
+		tracker string
+	}{
+		{10, "propacker10", "ProPacker 1.0"},
+		{21, "propacker21", "ProPacker 2.1"},
+		{30, "propacker30", "ProPacker 3.0"},
+	} {
+		packed := packProPacker(mod, tt.version)
+		format, err := DetectFormat(packed, "")
+		if (err != nil || format.Name != tt.format) {
+			t.Errorf("%s: detected as %q, %v", tt.format, format.Name, err)
+		}
+
+		unpacked, err := unpackProPacker(packed, tt.version)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+		} else if !bytes.Equal(unpacked, mod) {
+			t.Errorf("%s: unpacked module doesn't match the original", tt.format)
+		}
+
+		m, err := LoadBytes(packed, "song")
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+			continue
+		}
+		pt := m.(*ProTracker)
+		if (pt.Type() != PROTRACKER || pt.NumPatterns() != 3 || pt.Tracker().String() != tt.tracker) {
+			t.Errorf("%s: got type %d with %d patterns from %s", tt.format, pt.Type(), pt.NumPatterns(), pt.Tracker())
+		}
+		sample, err := pt.GetSample(0)
+		if (err != nil || len(sample.Data()) != 32 || sample.Data()[31] != 248) {
+			t.Errorf("%s: got sample %+v, %v", tt.format, sample, err)
+		}
+		row, _ := pt.patterns[1].GetRow(4)
+		note := row.Notes()[2]
+		if (note.Period() != 214 || note.Instrument() != 1 || note.Effect() != 0xC || note.Parameter() != 4) {
+			t.Errorf("%s: got note %+v", tt.format, note)
+		}
+	}
+
+	// the original doesn't pass as packed
+	if format, err := DetectFormat(mod, ""); (err != nil || format.Name != "protracker") {
+		t.Errorf("MOD detected as %q, %v", format.Name, err)
+	}
+	// nor does a packed module with a note reference past the table
+	packed := packProPacker(mod, 21)
+	binary.BigEndian.PutUint16(packed[proPackerHeaderSize:], 0x100)
+	if _, err := unpackProPacker(packed, 21); err == nil {
+		t.Errorf("Expected an error for a bad note reference")
+	}
+}
@@ -8,6 +8,18 @@ type Pattern struct {
 	numChannels int8
 	rows        []Row
 	data        []byte
+	// XM pattern header length and any bytes padding it out past 9
+	headerLength uint32
+	headerExtra  []byte
+}
+
+// NewPattern creates a pattern of empty rows
+func NewPattern(numChannels int, numRows int) Pattern {
+	p := Pattern{rows:make([]Row, numRows), numChannels:int8(numChannels)}
+	for i := range p.rows {
+		p.rows[i] = Row{notes:make([]Note, numChannels)}
+	}
+	return p
 }
 
 func (p *Pattern) NumChannels() int  {
@@ -18,11 +30,30 @@ func (p *Pattern) NumRows() int  {
 	return len(p.rows)
 }
 
+// HeaderLength returns the length of the XM pattern header as stored, or 0
+// for patterns not loaded from an XM
+func (p *Pattern) HeaderLength() uint32 {
+	return p.headerLength
+}
+
+// HeaderExtra returns any bytes padding the XM pattern header out past 9 bytes
+func (p *Pattern) HeaderExtra() []byte {
+	return p.headerExtra
+}
+
+func (p *Pattern) SetHeaderLength(length uint32) {
+	p.headerLength = length
+}
+
+func (p *Pattern) SetHeaderExtra(extra []byte) {
+	p.headerExtra = extra
+}
+
 func (p *Pattern) GetNote(row int, channel int) (Note,error) {
 	if (channel < 0 || channel > p.NumChannels()) {
 		return Note{},errors.New("Invalid channel")
 	}
-	if (row < 0 || row > p.NumRows()) {
+	if (row < 0 || row >= p.NumRows()) {
 		return Note{},errors.New("Invalid row")
 	}
 
@@ -43,14 +74,14 @@ func (p *Pattern) GetNote(row int, channel int) (Note,error) {
 }
 
 func (p *Pattern) GetRow(row int) (*Row,error) {
-	if (row < 0 || row > p.NumRows()) {
+	if (row < 0 || row >= p.NumRows()) {
 		return nil,errors.New("Invalid row index.")
 	}
 	return &p.rows[row],nil
 }
 
 func (p *Pattern) SetRow(idx int, r Row) error {
-	if (idx < 0 || idx > len(p.rows)) {
+	if (idx < 0 || idx >= len(p.rows)) {
 		return errors.New("Invalid row index.")
 	} else {
 		p.rows[idx] = r
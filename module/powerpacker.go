@@ -0,0 +1,154 @@
+package module
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PowerPacker crunched files, common on Amiga disks. The file is "PP20", a
+// table of four offset bit lengths (the efficiency), the crunched data, then
+// the decrunched length in 24 bits and the number of padding bits at the
+// start of the stream. The stream is read backwards from the end, a byte at
+// a time with the lowest bit first, and the output is written backwards too.
+
+// ErrNotPowerPacked is returned when decrunching data that doesn't start with "PP20"
+var ErrNotPowerPacked = errors.New("not PowerPacker crunched")
+
+// IsPowerPacked reports whether data is a PowerPacker crunched file
+func IsPowerPacked(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "PP20"
+}
+
+// DecrunchPowerPacker unpacks a PowerPacker crunched file, using the default
+// MaxSize limit for the unpacked data
+func DecrunchPowerPacker(data []byte) ([]byte, error) {
+	return decrunchPowerPacker(data, DefaultLoadOptions().MaxSize)
+}
+
+// ppBitReader reads bits from the end of the crunched data towards the start
+type ppBitReader struct {
+	data []byte
+	pos int
+	buffer uint32
+	bitsLeft uint
+}
+
+func (r *ppBitReader) readBits(n uint) (int, error) {
+	for r.bitsLeft < n {
+		if (r.pos == 0) {
+			return 0, &FormatError{Format: "powerpacker", Field: "data", Offset: 8, Err: ErrTruncated}
+		}
+		r.pos--
+		r.buffer |= uint32(r.data[r.pos]) << r.bitsLeft
+		r.bitsLeft += 8
+	}
+	// the first bit read is the top bit of the value
+	value := 0
+	for i := uint(0); i < n; i++ {
+		value = value << 1 | int(r.buffer & 1)
+		r.buffer >>= 1
+	}
+	r.bitsLeft -= n
+	return value, nil
+}
+
+func decrunchPowerPacker(data []byte, maxSize int64) ([]byte, error) {
+	if !IsPowerPacked(data) {
+		return nil, ErrNotPowerPacked
+	}
+	r := reader{format: "powerpacker", data: data}
+	offsetBits := data[4:8]
+	for i, bits := range offsetBits {
+		if (bits == 0 || bits > 15) {
+			return nil, r.invalid(fmt.Sprintf("efficiency[%d]", i), 4 + i, bits)
+		}
+	}
+	trailer := binary.BigEndian.Uint32(data[len(data)-4:])
+	length := int(trailer >> 8)
+	if err := checkLimit("MaxSize", int64(length), maxSize); err != nil {
+		return nil, err
+	}
+
+	in := &ppBitReader{data: data[8:len(data)-4], pos: len(data) - 12}
+	if _, err := in.readBits(uint(trailer & 0xFF)); err != nil {
+		return nil, err
+	}
+	out := make([]byte, length)
+	pos := length
+	for pos > 0 {
+		bit, err := in.readBits(1)
+		if err != nil {
+			return nil, err
+		}
+		if (bit == 0) {
+			// a run of literals, whose length is sent in 2 bit groups
+			count := 1
+			for {
+				x, err := in.readBits(2)
+				if err != nil {
+					return nil, err
+				}
+				count += x
+				if (x != 3) {
+					break
+				}
+			}
+			if (count > pos) {
+				return nil, r.invalid("data", 8, fmt.Sprintf("literal run of %d with %d bytes left", count, pos))
+			}
+			for ; count > 0; count-- {
+				literal, err := in.readBits(8)
+				if err != nil {
+					return nil, err
+				}
+				pos--
+				out[pos] = byte(literal)
+			}
+			if (pos == 0) {
+				break
+			}
+		}
+
+		// a match, always following a run of literals
+		x, err := in.readBits(2)
+		if err != nil {
+			return nil, err
+		}
+		bits := uint(offsetBits[x])
+		count := x + 2
+		if (x == 3) {
+			long, err := in.readBits(1)
+			if err != nil {
+				return nil, err
+			}
+			if (long == 0) {
+				bits = 7
+			}
+		}
+		offset, err := in.readBits(bits)
+		if err != nil {
+			return nil, err
+		}
+		if (x == 3) {
+			for {
+				x, err := in.readBits(3)
+				if err != nil {
+					return nil, err
+				}
+				count += x
+				if (x != 7) {
+					break
+				}
+			}
+		}
+		if (pos + offset >= length || count > pos) {
+			return nil, r.invalid("data", 8, fmt.Sprintf("match of %d at offset %d with %d bytes left", count, offset, pos))
+		}
+		for ; count > 0; count-- {
+			out[pos-1] = out[pos+offset]
+			pos--
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,187 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crunchPowerPacker crunches data with greedy matching, working from the end
+// of the data as the decruncher does
+func crunchPowerPacker(data []byte, efficiency [4]byte) []byte {
+	// the bits in the order the decruncher reads them
+	stream := make([]int, 0)
+	write := func(value int, n uint) {
+		for i := int(n) - 1; i >= 0; i-- {
+			stream = append(stream, value >> i & 1)
+		}
+	}
+	// findMatch gives the longest match ending at i, and its class: the
+	// shorter matches can only reach as far as their offset bits allow
+	findMatch := func(i int) (int, int) {
+		bestLength, bestOffset := 0, 0
+		for offset := 0; offset < 1 << efficiency[3] && i + offset + 1 < len(data); offset++ {
+			length := 0
+			for length < 40 && i - length >= 0 && data[i-length] == data[i-length+offset+1] {
+				length++
+			}
+			if (length > 4) {
+				// long enough for any offset
+			} else if (length >= 2 && offset >= 1 << efficiency[length-2]) {
+				continue
+			} else if (length < 2) {
+				continue
+			}
+			if (length > bestLength) {
+				bestLength, bestOffset = length, offset
+			}
+		}
+		return bestLength, bestOffset
+	}
+	writeMatch := func(length int, offset int) {
+		if (length < 5) {
+			write(length - 2, 2)
+			write(offset, uint(efficiency[length-2]))
+			return
+		}
+		write(3, 2)
+		if (offset < 128) {
+			write(0, 1)
+			write(offset, 7)
+		} else {
+			write(1, 1)
+			write(offset, uint(efficiency[3]))
+		}
+		for extra := length - 5; ; extra -= 7 {
+			if (extra < 7) {
+				write(extra, 3)
+				break
+			}
+			write(7, 3)
+		}
+	}
+
+	for i := len(data) - 1; i >= 0; {
+		literals := make([]byte, 0)
+		length, offset := findMatch(i)
+		for i >= 0 && length == 0 {
+			literals = append(literals, data[i])
+			i--
+			if (i >= 0) {
+				length, offset = findMatch(i)
+			}
+		}
+		if (len(literals) > 0) {
+			write(0, 1)
+			for extra := len(literals) - 1; ; extra -= 3 {
+				if (extra < 3) {
+					write(extra, 2)
+					break
+				}
+				write(3, 2)
+			}
+			for _, literal := range literals {
+				write(int(literal), 8)
+			}
+			if (i < 0) {
+				break
+			}
+		} else {
+			write(1, 1)
+		}
+		writeMatch(length, offset)
+		i -= length
+	}
+
+	// pad to whole longwords with bits that are skipped before decrunching
+	skip := (32 - len(stream) % 32) % 32
+	stream = append(make([]int, skip), stream...)
+	crunched := make([]byte, len(stream) / 8)
+	for i, bit := range stream {
+		crunched[len(crunched) - 1 - i / 8] |= byte(bit << (i % 8))
+	}
+
+	out := append([]byte("PP20"), efficiency[:]...)
+	out = append(out, crunched...)
+	return binary.BigEndian.AppendUint32(out, uint32(len(data)) << 8 | uint32(skip))
+}
+
+func TestDecrunchPowerPacker(t *testing.T) {
+	data := make([]byte, 0)
+	for i := 0; i < 200; i++ {
+		data = append(data, []byte("M.K. ")...)
+		data = append(data, byte(i), byte(i*3), byte(i*i))
+	}
+	data = append(data, make([]byte, 600)...)
+	crunched := crunchPowerPacker(data, [4]byte{9, 10, 12, 13})
+	if (len(crunched) >= len(data)) {
+		t.Errorf("crunched %d bytes to %d", len(data), len(crunched))
+	}
+	out, err := DecrunchPowerPacker(crunched)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("decrunched data doesn't match")
+	}
+
+	if _, err := DecrunchPowerPacker(data); !errors.Is(err, ErrNotPowerPacked) {
+		t.Errorf("Expected ErrNotPowerPacked, got %v", err)
+	}
+	// dropping the start of the stream leaves the decruncher short of bits
+	truncated := append(append([]byte(nil), crunched[:8]...), crunched[len(crunched)/2:]...)
+	if _, err := DecrunchPowerPacker(truncated); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	// claiming less output than the stream holds, so a match runs past the start
+	corrupt := append([]byte(nil), crunched...)
+	binary.BigEndian.PutUint32(corrupt[len(corrupt)-4:], binary.BigEndian.Uint32(corrupt[len(corrupt)-4:]) - 100 << 8)
+	if _, err := DecrunchPowerPacker(corrupt); err == nil {
+		t.Errorf("Expected an error for a corrupt stream")
+	}
+	if _, err := decrunchPowerPacker(crunched, 1000); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestLoadPowerPacked(t *testing.T) {
+	dir := t.TempDir()
+	crunched := crunchPowerPacker(buildTestMOD("M.K.", 4, []byte{0, 1}), [4]byte{9, 10, 10, 10})
+	file := filepath.Join(dir, "song.mod")
+	if err := os.WriteFile(file, crunched, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("mods/song.mod")
+	w.Write(crunched)
+	zw.Close()
+	zipFile := filepath.Join(dir, "disk.zip")
+	if err := os.WriteFile(zipFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{file, zipFile, zipFile + "#mods/song.mod"} {
+		m, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", path, err)
+			continue
+		}
+		if (m.Type() != PROTRACKER || m.NumPatterns() != 2) {
+			t.Errorf("%s: got type %d with %d patterns", path, m.Type(), m.NumPatterns())
+		}
+	}
+
+	// ReadFile gives the member as it's stored, still crunched
+	data, err := ReadFile(zipFile + "#mods/song.mod")
+	if (err != nil || !bytes.Equal(data, crunched)) {
+		t.Errorf("ReadFile got %d bytes, %v", len(data), err)
+	}
+	if _, err := ReadFile(zipFile); err == nil {
+		t.Errorf("Expected an error reading an archive without a member")
+	}
+}
@@ -0,0 +1,103 @@
+package module
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Promizer 2.0 modules carry their own replayer, 0x1164 bytes of 68000 code
+// that start by branching over the rest of it. The module follows, starting
+// with the offsets of the note table and of the sample data from the end of
+// the replayer, then the MOD sample headers less their names, the song
+// length times 2 and a position list of 128 words, each the offset of a
+// pattern in the pattern data. Patterns are 64 rows of 4 words, each the
+// offset of a 4 byte MOD note in the note table.
+const promizerCodeSize = 0x1164
+const promizerHeaderSize = promizerCodeSize + 8 + 31*8 + 2 + 128*2
+
+func parsePromizer(data []byte) (packedMOD, error) {
+	r := reader{format: "promizer20", data: data}
+	if err := r.check("header", 0, promizerHeaderSize); err != nil {
+		return packedMOD{}, err
+	}
+	// a BRA.W over the replayer
+	if (data[0] != 0x60 || data[1] != 0x00) {
+		return packedMOD{}, r.invalid("replayer", 0, fmt.Sprintf("%x", data[0:2]))
+	}
+	noteTable := promizerCodeSize + int(binary.BigEndian.Uint32(data[promizerCodeSize:promizerCodeSize+4]))
+	sampleDataOffset := promizerCodeSize + int(binary.BigEndian.Uint32(data[promizerCodeSize+4:promizerCodeSize+8]))
+	offset := promizerCodeSize + 8
+
+	sampleSize, err := checkPackedSamples(r, offset)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	p := packedMOD{samples: data[offset:offset+31*8], restartPos: 0x7F}
+	offset += 31*8
+
+	songLength := int(binary.BigEndian.Uint16(data[offset:offset+2]))
+	if (songLength == 0 || songLength % 2 != 0 || songLength > 256) {
+		return packedMOD{}, r.invalid("songLength", offset, songLength)
+	}
+	p.songLength = songLength / 2
+	positions := offset + 2
+	patternData := promizerHeaderSize
+	if (noteTable < patternData || (noteTable - patternData) % 512 != 0) {
+		return packedMOD{}, r.invalid("noteTable", promizerCodeSize, noteTable - promizerCodeSize)
+	}
+	if (sampleDataOffset < noteTable || (sampleDataOffset - noteTable) % 4 != 0) {
+		return packedMOD{}, r.invalid("sampleData", promizerCodeSize + 4, sampleDataOffset - promizerCodeSize)
+	}
+	table, err := r.slice("noteTable", noteTable, sampleDataOffset - noteTable)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	for i := 0; i < len(table); i += 4 {
+		if err := checkPackedNote(r, fmt.Sprintf("noteTable[%d]", i/4), noteTable + i); err != nil {
+			return packedMOD{}, err
+		}
+	}
+
+	for pos := 0; pos < p.songLength; pos++ {
+		pattern := int(binary.BigEndian.Uint16(data[positions+pos*2:positions+pos*2+2]))
+		if (pattern % 512 != 0 || patternData + pattern >= noteTable) {
+			return packedMOD{}, r.invalid(fmt.Sprintf("position[%d]", pos), positions + pos*2, pattern)
+		}
+		for channel := 0; channel < 4; channel++ {
+			// the track is the offset of the channel's first row
+			p.tracks[channel][pos] = pattern + channel*2
+			for row := 0; row < 64; row++ {
+				refOffset := patternData + pattern + row*8 + channel*2
+				ref := int(binary.BigEndian.Uint16(data[refOffset:refOffset+2]))
+				if (ref % 4 != 0 || ref + 4 > len(table)) {
+					return packedMOD{}, r.invalid(fmt.Sprintf("pattern[%d].row[%d]", pattern/512, row), refOffset, ref)
+				}
+			}
+		}
+	}
+	p.note = func(track int, row int) []byte {
+		refOffset := patternData + track + row*8
+		ref := int(binary.BigEndian.Uint16(data[refOffset:refOffset+2]))
+		return table[ref:ref+4]
+	}
+
+	p.sampleData, err = r.slice("sampleData", sampleDataOffset, sampleSize)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	p.end = sampleDataOffset + sampleSize
+	return p, nil
+}
+
+func detectPromizer(data []byte) int {
+	p, err := parsePromizer(data)
+	return scorePacked(p, err, data)
+}
+
+func unpackPromizer(data []byte) ([]byte, error) {
+	p, err := parsePromizer(data)
+	if err != nil {
+		return nil, err
+	}
+	return rebuildMOD(p), nil
+}
@@ -0,0 +1,98 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// packPromizer packs a 4 channel MOD as Promizer 2.0 does, behind a
+// stand-in for the replayer
+func packPromizer(mod []byte) []byte {
+	_, numPatterns := modCounts(mod)
+	songLength := int(mod[950])
+
+	out := make([]byte, promizerCodeSize)
+	copy(out, []byte{0x60, 0x00, 0x11, 0x62})
+	for i := 4; i < promizerCodeSize; i += 2 {
+		// NOPs
+		out[i], out[i+1] = 0x4E, 0x71
+	}
+	out = append(out, make([]byte, 8)...)
+	for i := 0; i < 31; i++ {
+		out = append(out, mod[20+i*30+22:20+i*30+30]...)
+	}
+	out = binary.BigEndian.AppendUint16(out, uint16(songLength*2))
+	positions := make([]byte, 256)
+	for pos := 0; pos < songLength; pos++ {
+		binary.BigEndian.PutUint16(positions[pos*2:], uint16(mod[952+pos]) * 512)
+	}
+	out = append(out, positions...)
+
+	refs := make(map[string]int)
+	notes := make([]byte, 0)
+	for pattern := 0; pattern < numPatterns; pattern++ {
+		for row := 0; row < 64; row++ {
+			for channel := 0; channel < 4; channel++ {
+				note := string(modPatternNote(mod, pattern, row, channel))
+				ref, ok := refs[note]
+				if !ok {
+					ref = len(notes)
+					refs[note] = ref
+					notes = append(notes, note...)
+				}
+				out = binary.BigEndian.AppendUint16(out, uint16(ref))
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(out[promizerCodeSize:], uint32(len(out) - promizerCodeSize))
+	out = append(out, notes...)
+	binary.BigEndian.PutUint32(out[promizerCodeSize+4:], uint32(len(out) - promizerCodeSize))
+	return append(out, mod[1084+numPatterns*1024:]...)
+}
+
+func TestPromizer(t *testing.T) {
+	mod := buildPackableMOD()
+	packed := packPromizer(mod)
+	format, err := DetectFormat(packed, "")
+	if (err != nil || format.Name != "promizer20") {
+		t.Errorf("detected as %q, %v", format.Name, err)
+	}
+
+	unpacked, err := unpackPromizer(packed)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if !bytes.Equal(unpacked, mod) {
+		t.Errorf("unpacked module doesn't match the original")
+	}
+
+	m, err := LoadBytes(packed, "song")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pt := m.(*ProTracker)
+	if (pt.Type() != PROTRACKER || pt.NumPatterns() != 3 || pt.Tracker().String() != "Promizer 2.0") {
+		t.Errorf("got type %d with %d patterns from %s", pt.Type(), pt.NumPatterns(), pt.Tracker())
+	}
+	sample, err := pt.GetSample(0)
+	if (err != nil || len(sample.Data()) != 32 || sample.Data()[31] != 248) {
+		t.Errorf("got sample %+v, %v", sample, err)
+	}
+	row, _ := pt.patterns[1].GetRow(4)
+	note := row.Notes()[2]
+	if (note.Period() != 214 || note.Instrument() != 1 || note.Effect() != 0xC || note.Parameter() != 4) {
+		t.Errorf("got note %+v", note)
+	}
+
+	// a note reference past the table
+	binary.BigEndian.PutUint16(packed[promizerHeaderSize:], 0x7FFC)
+	if _, err := unpackPromizer(packed); err == nil {
+		t.Errorf("Expected an error for a bad note reference")
+	}
+	// without the replayer it's not Promizer
+	packed = packPromizer(mod)
+	packed[0] = 0x4E
+	if _, err := unpackPromizer(packed); err == nil {
+		t.Errorf("Expected an error without the replayer")
+	}
+}
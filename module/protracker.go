@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"log/slog"
 	"errors"
+	"strings"
 )
 
 type ProTracker struct {
 	title string
+	tag string
+	numSamples int
 	numChannels int8
 	songLength int8
 	restartPos int8
@@ -16,25 +19,69 @@ type ProTracker struct {
 	instruments []Instrument
 	samples []PTSample
 	patterns []Pattern
+	// packer is set for modules rebuilt from a packed format
+	packer *modPacker
+	loadState
 	Module
 }
 
+func init() {
+	Register(Format{
+		Name: "protracker",
+		Extensions: []string{".mod", ".nst", ".stk"},
+		Detect: detectProTracker,
+		New: func() Module { return &ProTracker{} },
+	})
+}
+
+// detectProTracker recognises MODs by their tag. Soundtracker modules don't
+// have one, so they can only be picked out by how sensible the header looks.
+func detectProTracker(data []byte) int {
+	if (len(data) >= 1084 && ModTagChannels(string(data[1080:1084])) > 0) {
+		return SCORE_LIKELY
+	}
+	if (isSoundtracker(data)) {
+		return SCORE_WEAK
+	}
+	return SCORE_NONE
+}
+
+// Type returns SOUNDTRACKER for original 15-sample modules, which share the
+// ProTracker structure
 func (m *ProTracker) Type() FileFormat {
+	if (m.numSamples == 15) {
+		return SOUNDTRACKER
+	}
 	return PROTRACKER
 }
 
 func (m *ProTracker) Load(data []byte) error {
-	name := string(data[0:20])
+	if (m.packer != nil) {
+		unpacked, err := m.packer.unpack(data)
+		if err != nil {
+			return err
+		}
+		data = unpacked
+	}
+	r := reader{format: "protracker", data: data}
+	limits := m.limits()
 	length := len(data)
-	m.title = name
 
-	// Load sample metadata
+	// Load sample metadata. Modules without a known tag after a 31-sample
+	// header may be original Soundtracker modules with only 15 samples.
+	m.numSamples = 31
+	if (length < 1084 || ModTagChannels(string(data[1080:1084])) == 0) && isSoundtracker(data) {
+		m.numSamples = 15
+	}
+	if err := r.check("header", 0, 20 + m.numSamples*30 + 130); err != nil {
+		return err
+	}
+	m.title = string(data[0:20])
 	offset := int(20)
-	// Todo: If there's no magic number we should assume only 15 samples, not 31
 
 	sampleDatas := make([][]byte, 0)
 
-	for i := 0; i < 31; i++ {
+	for i := 0; i < m.numSamples; i++ {
 		sampleMeta := data[offset:offset+30]
 		sampleDatas = append(sampleDatas, sampleMeta)
 		offset += 30
@@ -48,48 +95,60 @@ func (m *ProTracker) Load(data []byte) error {
 		m.sequenceTable[i] = int8(data[offset+i])
 	}
 	offset += 128
-	magicNumber := string(data[offset:offset+4])
-	offset += 4
-
-	// Validate our magic number against known possible values and hence number of channels
-	if (magicNumber == "M.K." || magicNumber == "FLT4" || magicNumber == "M!K!") {
+	if (m.numSamples == 15) {
+		// Soundtracker modules have no tag and are always 4 channels
 		m.numChannels = 4
-	} else if (magicNumber == "6CHN") {
-		m.numChannels = 6
-	} else if (magicNumber == "8CHN" || magicNumber == "FLT8") {
-		m.numChannels = 8
 	} else {
-		// Assume 4 channels and that the magic number starting offset is actually
-		// the start of the pattern data, so let's roll back
-		m.numChannels = 4
-		offset -= 4
+		// Validate our magic number against known possible values and hence number of channels
+		if (offset + 4 <= length) {
+			m.tag = string(data[offset:offset+4])
+		}
+		m.numChannels = int8(ModTagChannels(m.tag))
+		if (m.numChannels > 0) {
+			offset += 4
+			if (strings.HasPrefix(m.tag, "FA0")) {
+				// Digital Tracker writes four unused bytes after the tag
+				offset += 4
+			}
+		} else {
+			// Assume 4 channels and that the magic number starting offset is actually
+			// the start of the pattern data
+			m.numChannels = 4
+			m.tag = ""
+		}
+	}
+
+	// Startrekker stores 8 channel patterns as pairs of 4 channel patterns,
+	// with the order list referring to the 4 channel patterns
+	flt8 := m.tag == "FLT8"
+	if (flt8) {
+		for i := range m.sequenceTable {
+			m.sequenceTable[i] /= 2
+		}
+	}
+
+	if err := checkLimit("MaxChannels", int64(m.numChannels), int64(limits.MaxChannels)); err != nil {
+		return err
 	}
 
 	// Start reading the pattern data
 	numPatterns := m.NumPatterns()
+	if err := checkLimit("MaxPatterns", int64(numPatterns), int64(limits.MaxPatterns)); err != nil {
+		return err
+	}
 	slog.Debug("Starting to read patterns", "num-patterns", numPatterns, "offset", offset)
 	m.patterns = make([]Pattern, numPatterns, numPatterns)
 	for i := 0; i < numPatterns; i++ {
-		pattern := Pattern{rows:make([]Row,64), numChannels:m.numChannels}
-		// Sanity check for enough data remaining in the buffer
-		for j := 0; j < pattern.NumRows(); j++ {
-			row := Row{notes:make([]Note, m.numChannels)}
-			for k := 0; k < pattern.NumChannels(); k++ {
-				n := Note{}
-				n.Load(data[offset:offset+4])
-				row.notes[k] = n
-				offset += 4
-			}
-			pattern.SetRow(j,row)
+		if err := r.check(fmt.Sprintf("pattern[%d]", i), offset, 64*int(m.numChannels)*4); err != nil {
+			return err
+		}
+		pattern := NewPattern(int(m.numChannels), 64)
+		if (flt8) {
+			offset = loadPatternChannels(&pattern, data, offset, 0, 4)
+			offset = loadPatternChannels(&pattern, data, offset, 4, 4)
+		} else {
+			offset = loadPatternChannels(&pattern, data, offset, 0, int(m.numChannels))
 		}
-		/*
-			if (offset + 1024) > length {
-				errtxt := fmt.Sprintf("Exceeded remaining data length on pattern %d",i)
-				return errors.New(errtxt)
-			}*/
-		//		pattern.data = make([]byte, 1024)
-		//		copy(pattern.data, data[offset:offset+1024])
-		//offset += 1024
 		m.patterns[i] = pattern
 	}
 
@@ -103,27 +162,25 @@ func (m *ProTracker) Load(data []byte) error {
 		sample.volume = int8(sampleData[25])
 		sample.repeatOffset = binary.BigEndian.Uint16(sampleData[26:28])
 		sample.repeatLength = binary.BigEndian.Uint16(sampleData[28:30])
-		sample.data = data[offset:offset+int(sample.length)]
-		// Sanity check for enough data remaining in the buffer
-		if (offset + int(sample.length) > length) {
-			errtxt := fmt.Sprintf("Exceeded remaining data length on sample %d (%s)",i, sample.name)
-			return errors.New(errtxt)
+		if (m.numSamples == 15 && (int64(sample.repeatOffset) + int64(sample.repeatLength)) * 2 > sample.length) {
+			// Ultimate Soundtracker stored the loop start in bytes rather than words
+			sample.repeatOffset /= 2
+		}
+		var err error
+		sample.data, err = r.slice(fmt.Sprintf("sample[%d].data", i), offset, int(sample.length))
+		if err != nil {
+			return err
 		}
 		m.samples = append(m.samples, sample)
 		offset += int(sample.length)
 	}
 
-	//for i := 0; i < len(m.samples); i++ {
-	//	sample := m.samples[i]
-	//	slog.Debug("Loading sample", "index", i, "offset", offset, "length", sample.length, "name", sample.name)
-	//}
-	//
 	slog.Debug("Done loading", "offset", offset, "length", length)
 	return nil
 }
 
 func (m *ProTracker) GetSample(i int) (PTSample,error) {
-	if (i < 0 || i > len(m.samples)) {
+	if (i < 0 || i >= len(m.samples)) {
 		return PTSample{},errors.New("Invalid sample")
 	} else {
 		return m.samples[i],nil
@@ -169,12 +226,17 @@ func (m *ProTracker) Title() (string) {
 	return m.title
 }
 
+// Filename returns the name the module was loaded from, if any
+func (m *ProTracker) Filename() string {
+	return m.filename
+}
+
 func (m *ProTracker) Play() {
 	fmt.Printf("Playing PT..\n")
 }
 
 func (m *ProTracker) GetPattern(patternNumber int8) (Pattern,error) {
-	if (patternNumber < 0 || patternNumber > 127) {
+	if (patternNumber < 0 || int(patternNumber) >= len(m.patterns)) {
 		return Pattern{},errors.New("Pattern index out of range.")
 	} else {
 		return m.patterns[patternNumber], nil
@@ -187,4 +249,113 @@ func (m *ProTracker) NumChannels() int {
 
 func (m *ProTracker) RestartPos() int {
 	return int(m.restartPos)
-}
\ No newline at end of file
+}
+
+// Tag returns the four character tag after the order table, or an empty
+// string if the module doesn't have one
+func (m *ProTracker) Tag() string {
+	return m.tag
+}
+
+// Tracker guesses the program that saved the module. MOD files carry little
+// identifying information, so this is a best effort.
+func (m *ProTracker) Tracker() TrackerInfo {
+	if (m.packer != nil) {
+		return m.packer.tracker
+	}
+	if (m.numSamples == 15) {
+		return TrackerInfo{Name: "Soundtracker", Confidence: CONFIDENCE_MEDIUM}
+	}
+	return fingerprintMOD(m.tag, uint8(m.restartPos))
+}
+
+// ModTag returns the usual tag for a MOD with the given number of channels
+func ModTag(numChannels int) string {
+	switch {
+	case numChannels == 4:
+		return "M.K."
+	case numChannels < 10:
+		return fmt.Sprintf("%dCHN", numChannels)
+	}
+	return fmt.Sprintf("%dCH", numChannels)
+}
+
+// ModTagChannels returns the number of channels for a MOD tag, or 0 if the
+// tag isn't recognised
+func ModTagChannels(tag string) int {
+	switch tag {
+	case "M.K.", "M!K!", "FLT4":
+		return 4
+	case "FLT8", "CD81", "OKTA", "OCTA":
+		return 8
+	case "FA04", "FA06", "FA08":
+		return int(tag[3] - '0')
+	}
+	if (len(tag) != 4) {
+		return 0
+	}
+
+	// TakeTracker uses TDZ1 to TDZ9
+	if (tag[0:3] == "TDZ" && tag[3] >= '1' && tag[3] <= '9') {
+		return int(tag[3] - '0')
+	}
+	// FastTracker and TakeTracker use xCHN for 1 to 9 channels and xxCH above that
+	if (tag[1:4] == "CHN" && tag[0] >= '1' && tag[0] <= '9') {
+		return int(tag[0] - '0')
+	}
+	if (tag[2:4] == "CH" && tag[0] >= '1' && tag[0] <= '3' && tag[1] >= '0' && tag[1] <= '9') {
+		channels := int(tag[0] - '0') * 10 + int(tag[1] - '0')
+		if (channels <= 32) {
+			return channels
+		}
+	}
+	return 0
+}
+
+// isSoundtracker checks whether data looks like a 15-sample Soundtracker
+// module, whose header is 600 bytes with no tag
+func isSoundtracker(data []byte) bool {
+	if (len(data) < 600) {
+		return false
+	}
+
+	// sample headers: volume goes up to 64, there's no finetune and lengths
+	// are limited to 64K bytes
+	for i := 0; i < 15; i++ {
+		meta := data[20+i*30:50+i*30]
+		words := int(binary.BigEndian.Uint16(meta[22:24]))
+		if (meta[24] != 0 || meta[25] > 64 || words > 0x8000) {
+			return false
+		}
+	}
+
+	// the order list must be sensible
+	songLength := int(data[470])
+	if (songLength == 0 || songLength > 128) {
+		return false
+	}
+	numPatterns := 0
+	for _, p := range data[472:600] {
+		if (p > 63) {
+			return false
+		}
+		if (int(p) + 1 > numPatterns) {
+			numPatterns = int(p) + 1
+		}
+	}
+
+	// and the patterns must fit in the file
+	return 600 + numPatterns * 1024 <= len(data)
+}
+
+// loadPatternChannels reads 64 rows of notes for numChannels channels starting at
+// firstChannel, returning the offset after them
+func loadPatternChannels(pattern *Pattern, data []byte, offset int, firstChannel int, numChannels int) int {
+	for j := 0; j < pattern.NumRows(); j++ {
+		for k := firstChannel; k < firstChannel+numChannels; k++ {
+			pattern.rows[j].notes[k].Load(data[offset:offset+4])
+			offset += 4
+		}
+	}
+	return offset
+}
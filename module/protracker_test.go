@@ -0,0 +1,133 @@
+package module
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildTestMOD builds a module with empty samples and patterns. An empty tag
+// builds a 15-sample Soundtracker module.
+func buildTestMOD(tag string, numChannels int, orders []byte) []byte {
+	numSamples := 31
+	if (tag == "") {
+		numSamples = 15
+	}
+	data := make([]byte, 20+numSamples*30)
+	copy(data[0:20], "test song")
+	data = append(data, byte(len(orders)), 0x7f)
+	orderTable := make([]byte, 128)
+	copy(orderTable, orders)
+	data = append(data, orderTable...)
+	data = append(data, tag...)
+
+	numPatterns := 0
+	for _, p := range orders {
+		if (int(p) + 1 > numPatterns) {
+			numPatterns = int(p) + 1
+		}
+	}
+	return append(data, make([]byte, numPatterns*64*numChannels*4)...)
+}
+
+func TestLoadSoundtracker(t *testing.T) {
+	data := buildTestMOD("", 4, []byte{0, 1, 0})
+
+	// sample 1: 16 bytes with a loop starting at byte 8, as Ultimate Soundtracker stored it
+	copy(data[20:42], "sample")
+	binary.BigEndian.PutUint16(data[42:44], 8)
+	data[45] = 64
+	binary.BigEndian.PutUint16(data[46:48], 8)
+	binary.BigEndian.PutUint16(data[48:50], 4)
+	data = append(data, make([]byte, 16)...)
+
+	m := &ProTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if (m.Type() != SOUNDTRACKER) {
+		t.Errorf("Type = %d, want SOUNDTRACKER", m.Type())
+	}
+	if (len(m.samples) != 15 || m.NumPatterns() != 2 || m.NumChannels() != 4) {
+		t.Fatalf("got %d samples, %d patterns, %d channels", len(m.samples), m.NumPatterns(), m.NumChannels())
+	}
+	if (m.samples[0].Name() != "sample" || m.samples[0].Length() != 16) {
+		t.Errorf("sample 1 = %q length %d", m.samples[0].Name(), m.samples[0].Length())
+	}
+	if (m.samples[0].RepeatOffset() != 4 || m.samples[0].RepeatLength() != 4) {
+		t.Errorf("loop = %d+%d words, want 4+4", m.samples[0].RepeatOffset(), m.samples[0].RepeatLength())
+	}
+}
+
+func TestLoadProTrackerNotSoundtracker(t *testing.T) {
+	m := &ProTracker{}
+	if err := m.Load(buildTestMOD("M.K.", 4, []byte{0})); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if (m.Type() != PROTRACKER || len(m.samples) != 31 || m.Tag() != "M.K.") {
+		t.Errorf("got type %d with %d samples and tag %q", m.Type(), len(m.samples), m.Tag())
+	}
+}
+
+func TestModTagChannels(t *testing.T) {
+	tags := map[string]int{
+		"M.K.": 4, "FLT8": 8, "2CHN": 2, "6CHN": 6, "9CHN": 9, "10CH": 10, "32CH": 32,
+		"33CH": 0, "0CHN": 0, "CD81": 8, "OKTA": 8, "OCTA": 8, "TDZ1": 1, "TDZ9": 9,
+		"TDZ0": 0, "FA04": 4, "FA06": 6, "FA08": 8, "ABCD": 0,
+	}
+	for tag, want := range tags {
+		if got := ModTagChannels(tag); got != want {
+			t.Errorf("ModTagChannels(%q) = %d, want %d", tag, got, want)
+		}
+	}
+}
+
+func TestLoadMultichannelMOD(t *testing.T) {
+	data := buildTestMOD("24CH", 24, []byte{0})
+	// put a note in the last channel of the last row
+	offset := 1084 + (63*24+23)*4
+	copy(data[offset:offset+4], []byte{0x10, 0xd6, 0x0c, 0x20})
+
+	m := &ProTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if (m.NumChannels() != 24) {
+		t.Fatalf("NumChannels = %d, want 24", m.NumChannels())
+	}
+	row, err := m.patterns[0].GetRow(63)
+	if err != nil {
+		t.Fatalf("GetRow failed: %v", err)
+	}
+	note := row.Notes()[23]
+	if (note.Period() != 214 || note.Instrument() != 16 || note.Effect() != 0xc || note.Parameter() != 0x20) {
+		t.Errorf("note = %+v", note)
+	}
+}
+
+func TestLoadFLT8(t *testing.T) {
+	// orders refer to 4 channel patterns: 8 channel pattern 1 is stored as 2 and 3
+	data := buildTestMOD("FLT8", 4, []byte{0, 2, 0})
+	data = append(data, make([]byte, 1024)...)
+	// row 1 channel 0 of 4 channel pattern 3 is row 1 channel 4 of 8 channel pattern 1
+	offset := 1084 + 3*1024 + 16
+	copy(data[offset:offset+4], []byte{0x00, 0xd6, 0x1f, 0x01})
+
+	m := &ProTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if (m.NumChannels() != 8 || m.NumPatterns() != 2) {
+		t.Fatalf("got %d channels, %d patterns", m.NumChannels(), m.NumPatterns())
+	}
+	if (m.SequenceTable()[0] != 0 || m.SequenceTable()[1] != 1 || m.SequenceTable()[2] != 0) {
+		t.Errorf("orders = %v", m.sequenceTable[0:3])
+	}
+	row, err := m.patterns[1].GetRow(1)
+	if err != nil {
+		t.Fatalf("GetRow failed: %v", err)
+	}
+	note := row.Notes()[4]
+	if (note.Period() != 214 || note.Instrument() != 1 || note.Effect() != 0xf) {
+		t.Errorf("note = %+v", note)
+	}
+}
@@ -0,0 +1,88 @@
+package module
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFormat is returned when no registered format recognises a file
+var ErrUnknownFormat = errors.New("unknown module format")
+
+// Detection scores returned by a format's Detect function. Anything from 1 to
+// 100 counts as a match, with higher scores winning.
+const (
+	SCORE_NONE = 0
+	SCORE_WEAK = 25
+	SCORE_LIKELY = 50
+	SCORE_STRONG = 75
+	SCORE_CERTAIN = 100
+)
+
+// Format describes a module format that Load can detect and parse
+type Format struct {
+	// Name identifies the format, e.g. "protracker"
+	Name string
+	// Extensions lists the usual file extensions, including the dot
+	Extensions []string
+	// Detect scores how likely it is that data is in this format. It must
+	// not assume any minimum length.
+	Detect func(data []byte) int
+	// New returns an empty module ready to Load the data
+	New func() Module
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats []Format
+)
+
+// Register adds a format to those considered by Load. Formats registered
+// later win ties with earlier ones that have the same score and extension.
+func Register(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats = append(formats, f)
+}
+
+// DetectFormat returns the format that best matches data. When several
+// formats score the same, the one whose extension matches filename wins.
+func DetectFormat(data []byte, filename string) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	var best Format
+	bestScore := SCORE_NONE
+	bestExtMatch := false
+	for _, f := range formats {
+		score := f.Detect(data)
+		if (score <= SCORE_NONE) {
+			continue
+		}
+		extMatch := matchesExtension(filename, f.Extensions)
+		if (score > bestScore || (score == bestScore && (extMatch || !bestExtMatch))) {
+			best = f
+			bestScore = score
+			bestExtMatch = extMatch
+		}
+	}
+	if (bestScore == SCORE_NONE) {
+		return Format{}, ErrUnknownFormat
+	}
+	return best, nil
+}
+
+// matchesExtension checks filename against a list of extensions, also
+// accepting the Amiga convention of using the extension as a prefix, as in
+// "mod.songname"
+func matchesExtension(filename string, extensions []string) bool {
+	name := strings.ToLower(path.Base(filename))
+	for _, ext := range extensions {
+		ext = strings.ToLower(ext)
+		if (strings.HasSuffix(name, ext) || strings.HasPrefix(name, ext[1:] + ".")) {
+			return true
+		}
+	}
+	return false
+}
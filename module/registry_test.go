@@ -0,0 +1,62 @@
+package module
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		desc string
+		data []byte
+		want string
+	}{
+		{"XM", buildTestXM(4, []int{64}, [][]byte{nil}), "fasttracker"},
+		{"S3M", buildTestS3M(nil, nil), "screamtracker"},
+		{"IT", buildTestIT(nil, nil), "impulsetracker"},
+		{"MOD", buildTestMOD("M.K.", 4, []byte{0}), "protracker"},
+		{"Soundtracker", buildTestMOD("", 4, []byte{0}), "protracker"},
+	}
+	for _, tt := range tests {
+		f, err := DetectFormat(tt.data, "")
+		if err != nil {
+			t.Errorf("%s: DetectFormat failed: %v", tt.desc, err)
+		} else if (f.Name != tt.want) {
+			t.Errorf("%s: detected %s, want %s", tt.desc, f.Name, tt.want)
+		}
+	}
+
+	for _, data := range [][]byte{nil, []byte("IMPM"), make([]byte, 2048)} {
+		if _, err := DetectFormat(data, "song.mod"); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("DetectFormat(%d bytes) error = %v, want ErrUnknownFormat", len(data), err)
+		}
+	}
+}
+
+func TestRegisterExtensionTiebreak(t *testing.T) {
+	saved := formats
+	defer func() { formats = saved }()
+
+	// a format that claims M.K. modules just as strongly as ProTracker does
+	Register(Format{
+		Name: "test-mod",
+		Extensions: []string{".tmod"},
+		Detect: detectProTracker,
+		New: func() Module { return &ProTracker{} },
+	})
+
+	data := buildTestMOD("M.K.", 4, []byte{0})
+	for name, want := range map[string]string{
+		"song.mod": "protracker",
+		"MOD.song": "protracker",
+		"song.tmod": "test-mod",
+	} {
+		f, err := DetectFormat(data, name)
+		if err != nil {
+			t.Fatalf("DetectFormat(%s) failed: %v", name, err)
+		}
+		if (f.Name != want) {
+			t.Errorf("DetectFormat(%s) = %s, want %s", name, f.Name, want)
+		}
+	}
+}
@@ -4,6 +4,11 @@ type Row struct {
 	notes []Note
 }
 
+// NewRow creates a row from one note per channel
+func NewRow(notes []Note) Row {
+	return Row{notes: notes}
+}
+
 // Notes returns the notes in this row
 func (r *Row) Notes() []Note {
 	return r.notes
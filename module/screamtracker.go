@@ -38,30 +38,86 @@ type ScreamTracker struct {
 	tempo uint8
 	volume uint8
 	signature string
+	trackerVersion uint16
 	sampleType SampleType
 	samples []STSample
+	instruments []STAdlibInstrument
 	patterns []Pattern
 	orderList []uint8
+	channels [32]STChannel
+	loadState
 	Module
 }
 
+type STChannelType int
+const (
+	ST_CHANNEL_UNUSED = iota
+	ST_CHANNEL_LEFT
+	ST_CHANNEL_RIGHT
+	ST_CHANNEL_ADLIB_MELODY
+	ST_CHANNEL_ADLIB_DRUM
+)
+
+// Default pan positions, from 0 (left) to 15 (right)
+const (
+	ST_PAN_LEFT = 0x3
+	ST_PAN_CENTER = 0x8
+	ST_PAN_RIGHT = 0xC
+)
+
+// STChannel describes one of the 32 S3M channels. Index is the hardware
+// channel number within its type, e.g. 0 for L1 or A1 and 7 for R8.
+type STChannel struct {
+	Type STChannelType
+	Index int
+	Enabled bool
+	Pan uint8
+}
+
 type SampleType int
 const (
 	SIGNED = iota + 1
 	UNSIGNED
 )
 
+func init() {
+	Register(Format{
+		Name: "screamtracker",
+		Extensions: []string{".s3m"},
+		Detect: detectScreamTracker,
+		New: func() Module { return &ScreamTracker{} },
+	})
+}
+
+// detectScreamTracker looks for the "SCRM" signature, along with the sig1
+// and type bytes
+func detectScreamTracker(data []byte) int {
+	if (len(data) < 96 || string(data[44:48]) != "SCRM") {
+		return SCORE_NONE
+	}
+	if (data[28] != 0x1a || data[29] != 0x10) {
+		return SCORE_STRONG
+	}
+	return SCORE_CERTAIN
+}
+
 func (m *ScreamTracker) Type() FileFormat {
 	return SCREAMTRACKER
 }
 
 func (m *ScreamTracker) Load(data []byte) (error) {
+	r := reader{format: "screamtracker", data: data}
+	limits := m.limits()
+	if err := r.check("header", 0, 96); err != nil {
+		return err
+	}
 	m.title = filterNulls(string(data[0:28]))
 
 	orderCount := binary.LittleEndian.Uint16(data[32:34])
 	instrumentCount := binary.LittleEndian.Uint16(data[34:36])
 	patternPtrCount := binary.LittleEndian.Uint16(data[36:38])
 	//flags := binary.LittleEndian.Uint32(data[38:40])
+	m.trackerVersion = binary.LittleEndian.Uint16(data[40:42])
 	m.sampleType = SampleType(binary.LittleEndian.Uint16(data[42:44]))
 	m.signature = string(data[44:48])
 	m.volume = uint8(data[48])
@@ -69,8 +125,28 @@ func (m *ScreamTracker) Load(data []byte) (error) {
 	m.tempo = uint8(data[50])
 	m.isStereo = ((data[51] & (1 << 7)) != 0)
 	m.masterVolume = uint8((data[51] << 1) >> 1)
+	defaultPan := data[53]
+
+	// channel settings: 255 is unused, bit 7 marks a disabled channel and the
+	// lower bits say what it's mapped to
+	for i := 0; i < 32; i++ {
+		m.channels[i] = m.loadChannel(data[64+i])
+	}
+
+	if err := checkLimit("MaxChannels", int64(m.NumChannels()), int64(limits.MaxChannels)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxSamples", int64(instrumentCount), int64(limits.MaxSamples)); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxPatterns", int64(patternPtrCount), int64(limits.MaxPatterns)); err != nil {
+		return err
+	}
 
-	// order list loading time
+	// order list loading time, followed by the instrument and pattern parapointers
+	if err := r.check("orderList", 96, int(orderCount) + (int(instrumentCount) + int(patternPtrCount)) * 2); err != nil {
+		return err
+	}
 	for i := 96; i < 96+int(orderCount); i++ {
 		patternNum := uint8(data[i])
 		m.orderList = append(m.orderList, patternNum)
@@ -80,13 +156,27 @@ func (m *ScreamTracker) Load(data []byte) (error) {
 	startOffset := 96+int(orderCount)
 	for i := 0; i < int(instrumentCount); i++ {
 		// offset is parapointer, so multiply by 16
-		instrumentOffset := int(binary.LittleEndian.Uint16(data[startOffset+(i*2):startOffset+2+(i*2)]) * 16)
+		instrumentOffset := int(binary.LittleEndian.Uint16(data[startOffset+(i*2):startOffset+2+(i*2)])) * 16
+		field := fmt.Sprintf("instrument[%d]", i)
+		if err := r.check(field, instrumentOffset, 1); err != nil {
+			return err
+		}
 		instrumentType := uint8(data[instrumentOffset])
-		if instrumentType == 0 {
+		if instrumentType == ST_INSTRUMENT_EMPTY {
 			// empty sample
 			continue
-		} else if instrumentType != 1 {
-			return errors.New(fmt.Sprintf("Unsupported sample type %d at offset %d", instrumentType, instrumentOffset))
+		} else if instrumentType >= ST_INSTRUMENT_ADLIB_MELODY && instrumentType <= ST_INSTRUMENT_ADLIB_HIHAT {
+			instrument, err := loadAdlibInstrument(data[instrumentOffset:])
+			if err != nil {
+				return r.wrap(field, instrumentOffset, err)
+			}
+			m.instruments = append(m.instruments, instrument)
+			continue
+		} else if instrumentType != ST_INSTRUMENT_SAMPLE {
+			return r.invalid(field + ".type", instrumentOffset, instrumentType)
+		}
+		if err := r.check(field, instrumentOffset, 80); err != nil {
+			return err
 		}
 		instrumentOffset = instrumentOffset + 1
 
@@ -98,7 +188,7 @@ func (m *ScreamTracker) Load(data []byte) (error) {
 		sampleHighOffset := data[instrumentOffset]
 		instrumentOffset += 1
 		// another parapointer, so *16
-		sample.sampleOffset = int(uint(sampleHighOffset << 16) | uint(data[instrumentOffset+1]) << 8 | uint(data[instrumentOffset]))*16
+		sample.sampleOffset = int(uint(sampleHighOffset) << 16 | uint(data[instrumentOffset+1]) << 8 | uint(data[instrumentOffset]))*16
 		instrumentOffset += 2
 
 		//instrumentOffset := sampleHighOffset
@@ -128,22 +218,188 @@ func (m *ScreamTracker) Load(data []byte) (error) {
 
 		// validate we ended up at the right spot
 		if string(data[instrumentOffset:instrumentOffset+4]) != "SCRS" {
-			return errors.New(fmt.Sprintf("Signature missing or corrupt for sample %d", i))
+			return r.invalid(field + ".signature", instrumentOffset, fmt.Sprintf("%q", data[instrumentOffset:instrumentOffset+4]))
 		}
 
 		// lastly set the sample data
-		sample.data = data[sample.sampleOffset: sample.sampleOffset+int(sample.length)]
+		sampleData, err := r.slice(field + ".data", sample.sampleOffset, int(sample.length))
+		if err != nil {
+			return err
+		}
+		sample.data = sampleData
 
 		m.samples = append(m.samples, sample)
 	}
+
+	// pattern loading time
+	startOffset += int(instrumentCount)*2
 	for i := 0; i < int(patternPtrCount); i++ {
-		pattern := Pattern{}
+		// yet another parapointer
+		patternOffset := int(binary.LittleEndian.Uint16(data[startOffset+(i*2):startOffset+2+(i*2)])) * 16
+		if patternOffset == 0 {
+			// no data, so an empty pattern
+			m.patterns = append(m.patterns, NewPattern(32, 64))
+			continue
+		}
+		field := fmt.Sprintf("pattern[%d]", i)
+		if err := r.check(field, patternOffset, 2); err != nil {
+			return err
+		}
+		// packed length includes the two length bytes themselves
+		packedLength := int(binary.LittleEndian.Uint16(data[patternOffset:patternOffset+2]))
+		end := patternOffset + packedLength
+		if (packedLength < 2 || end > len(data)) {
+			// don't trust a length running past the end of the file, just read as far as we can
+			end = len(data)
+		}
+		pattern, err := loadS3MPattern(data[patternOffset+2:end])
+		if err != nil {
+			return r.wrap(field, patternOffset, err)
+		}
 		m.patterns = append(m.patterns, pattern)
 	}
 
+	// the panning table follows the pattern parapointers, and is only used if
+	// the header says so and the song is in stereo
+	panOffset := startOffset + int(patternPtrCount)*2
+	if (defaultPan == 252 && m.isStereo && panOffset + 32 <= len(data)) {
+		for i := 0; i < 32; i++ {
+			pan := data[panOffset+i]
+			if (pan & 0x20) != 0 {
+				m.channels[i].Pan = pan & 0x0F
+			}
+		}
+	}
+
 	return nil
 }
 
+// loadChannel decodes a channel settings byte, giving it ST3's default pan position
+func (m *ScreamTracker) loadChannel(setting uint8) STChannel {
+	channel := STChannel{Type: ST_CHANNEL_UNUSED, Pan: ST_PAN_CENTER}
+	if setting == 255 {
+		return channel
+	}
+	channel.Enabled = (setting & 0x80) == 0
+	mapping := int(setting & 0x7F)
+	switch {
+	case mapping < 8:
+		channel.Type = ST_CHANNEL_LEFT
+		channel.Index = mapping
+		channel.Pan = ST_PAN_LEFT
+	case mapping < 16:
+		channel.Type = ST_CHANNEL_RIGHT
+		channel.Index = mapping - 8
+		channel.Pan = ST_PAN_RIGHT
+	case mapping < 25:
+		channel.Type = ST_CHANNEL_ADLIB_MELODY
+		channel.Index = mapping - 16
+	case mapping < 30:
+		channel.Type = ST_CHANNEL_ADLIB_DRUM
+		channel.Index = mapping - 25
+	default:
+		channel.Enabled = false
+		return channel
+	}
+	if !m.isStereo {
+		channel.Pan = ST_PAN_CENTER
+	}
+	return channel
+}
+
+// loadAdlibInstrument reads an 80 byte AdLib instrument record
+//
+//	0	UINT8	type	2=melodic, 3-7=bass drum, snare, tom, cymbal, hihat
+//	1	char[12]	filename
+//	13	BYTE[3]	reserved
+//	16	UINT8[12]	registers	OPL2 register values
+//	28	UINT8	volume
+//	29	UINT8	disk	unused
+//	30	BYTE[2]	reserved
+//	32	UINT32LE	c2spd
+//	36	BYTE[12]	reserved
+//	48	char[28]	name
+//	76	char[4]	signature	"SCRI"
+func loadAdlibInstrument(data []byte) (STAdlibInstrument, error) {
+	instrument := STAdlibInstrument{}
+	if len(data) < 80 {
+		return instrument, errors.New("not enough data for AdLib instrument")
+	}
+	instrument.instrumentType = data[0]
+	instrument.filename = filterNulls(string(data[1:13]))
+	copy(instrument.registers[:], data[16:28])
+	instrument.volume = data[28]
+	instrument.c2spd = binary.LittleEndian.Uint32(data[32:36])
+	instrument.name = filterNulls(string(data[48:76]))
+	if string(data[76:80]) != "SCRI" {
+		return instrument, errors.New("signature missing or corrupt")
+	}
+	return instrument, nil
+}
+
+// loadS3MPattern decodes a packed S3M pattern of 64 rows. Each row is a list of
+// channel entries terminated by a zero byte, where each entry starts with a
+// byte holding the channel number in the low 5 bits and a mask in the upper 3:
+//
+//	bit 5: note and instrument follow
+//	bit 6: volume follows
+//	bit 7: command and info follow
+func loadS3MPattern(data []byte) (Pattern, error) {
+	pattern := NewPattern(32, 64)
+	pos := 0
+	for row := 0; row < 64; row++ {
+		for {
+			if (pos >= len(data)) {
+				return pattern, errors.New(fmt.Sprintf("ran out of data at row %d", row))
+			}
+			what := data[pos]
+			pos++
+			if what == 0 {
+				break
+			}
+
+			n := &pattern.rows[row].notes[what & 31]
+			if (what & 32) != 0 {
+				if (pos + 2 > len(data)) {
+					return pattern, errors.New(fmt.Sprintf("truncated note at row %d", row))
+				}
+				n.key = s3mNoteToKey(data[pos])
+				n.instrument = int(data[pos+1])
+				pos += 2
+			}
+			if (what & 64) != 0 {
+				if (pos + 1 > len(data)) {
+					return pattern, errors.New(fmt.Sprintf("truncated volume at row %d", row))
+				}
+				n.volume = int(data[pos])
+				n.hasVolume = true
+				pos++
+			}
+			if (what & 128) != 0 {
+				if (pos + 2 > len(data)) {
+					return pattern, errors.New(fmt.Sprintf("truncated command at row %d", row))
+				}
+				n.effect = int(data[pos])
+				n.parameter = int(data[pos+1])
+				pos += 2
+			}
+		}
+	}
+	return pattern, nil
+}
+
+// s3mNoteToKey converts an S3M note byte, with the octave in the upper nibble
+// and the note in the lower, to a key number
+func s3mNoteToKey(note uint8) int {
+	switch note {
+	case 255:
+		return 0
+	case 254:
+		return KeyCut
+	}
+	return int(note >> 4) * 12 + int(note & 15) + 1
+}
+
 func (m *ScreamTracker) Play() {
 }
 
@@ -151,8 +407,18 @@ func (m *ScreamTracker) Title() (string) {
 	return m.title
 }
 
+// Filename returns the name the module was loaded from, if any
+func (m *ScreamTracker) Filename() string {
+	return m.filename
+}
+
+// Instruments returns the AdLib instruments, PCM instruments are available from Samples
 func (m *ScreamTracker) Instruments() []Instrument {
-	return []Instrument{}
+	r := make([]Instrument, len(m.instruments))
+	for i := range m.instruments {
+		r[i] = m.instruments[i]
+	}
+	return r
 }
 
 func (m *ScreamTracker) Samples() []Sample {
@@ -167,3 +433,61 @@ func (m *ScreamTracker) NumPatterns() int {
 	return len(m.patterns)
 }
 
+
+func (m *ScreamTracker) Patterns() []Pattern {
+	return m.patterns
+}
+
+func (m *ScreamTracker) GetPattern(patternNumber int) (Pattern,error) {
+	if (patternNumber < 0 || patternNumber >= len(m.patterns)) {
+		return Pattern{},errors.New("Pattern index out of range.")
+	}
+	return m.patterns[patternNumber], nil
+}
+
+func (m *ScreamTracker) OrderList() []uint8 {
+	return m.orderList
+}
+
+func (m *ScreamTracker) Speed() uint8 {
+	return m.speed
+}
+
+func (m *ScreamTracker) Tempo() uint8 {
+	return m.tempo
+}
+
+// Channels returns the settings for all 32 S3M channels
+func (m *ScreamTracker) Channels() [32]STChannel {
+	return m.channels
+}
+
+// NumChannels returns the number of channels in use, counting up to the last
+// enabled channel, since unused and disabled channels can sit in between
+// enabled ones.
+func (m *ScreamTracker) NumChannels() int {
+	for i := len(m.channels) - 1; i >= 0; i-- {
+		if m.channels[i].Enabled {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (m *ScreamTracker) IsStereo() bool {
+	return m.isStereo
+}
+
+func (m *ScreamTracker) AdlibInstruments() []STAdlibInstrument {
+	return m.instruments
+}
+
+// TrackerVersion returns the raw trackerVersion header field
+func (m *ScreamTracker) TrackerVersion() uint16 {
+	return m.trackerVersion
+}
+
+// Tracker identifies the program that saved the module
+func (m *ScreamTracker) Tracker() TrackerInfo {
+	return fingerprintS3M(m.trackerVersion)
+}
@@ -0,0 +1,176 @@
+package module
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildTestS3M returns a minimal S3M file with the given instrument records
+// and packed patterns, each stored at its own parapointer. A nil pattern is
+// written with a zero parapointer.
+func buildTestS3M(instruments [][]byte, patterns [][]byte) []byte {
+	data := make([]byte, 96)
+	copy(data[0:28], "test song")
+	data[28] = 0x1a
+	data[29] = 0x10
+	binary.LittleEndian.PutUint16(data[32:34], 2)
+	binary.LittleEndian.PutUint16(data[34:36], uint16(len(instruments)))
+	binary.LittleEndian.PutUint16(data[36:38], uint16(len(patterns)))
+	binary.LittleEndian.PutUint16(data[42:44], 2)
+	copy(data[44:48], "SCRM")
+	data[49] = 6
+	data[50] = 125
+	for i := 0; i < 32; i++ {
+		data[64+i] = 0xff
+	}
+	data = append(data, 0, 0xff)
+
+	instrumentPointerOffset := len(data)
+	data = append(data, make([]byte, 2*len(instruments))...)
+	pointerOffset := len(data)
+	data = append(data, make([]byte, 2*len(patterns))...)
+	for i, instrument := range instruments {
+		for len(data) % 16 != 0 {
+			data = append(data, 0)
+		}
+		binary.LittleEndian.PutUint16(data[instrumentPointerOffset+i*2:], uint16(len(data)/16))
+		data = append(data, instrument...)
+	}
+	for i, packed := range patterns {
+		if packed == nil {
+			continue
+		}
+		for len(data) % 16 != 0 {
+			data = append(data, 0)
+		}
+		binary.LittleEndian.PutUint16(data[pointerOffset+i*2:], uint16(len(data)/16))
+		length := make([]byte, 2)
+		binary.LittleEndian.PutUint16(length, uint16(len(packed)+2))
+		data = append(data, length...)
+		data = append(data, packed...)
+	}
+	return data
+}
+
+func TestScreamTrackerLoadPatterns(t *testing.T) {
+	packed := []byte{
+		// row 0: C-5 instrument 1 volume 0 on channel 0, command A06 on channel 9
+		0x60, 0x50, 0x01, 0x00, 0x89, 0x01, 0x06, 0x00,
+		// row 1: note cut on channel 31
+		0x3f, 0xfe, 0x00, 0x00,
+	}
+	for i := 2; i < 64; i++ {
+		packed = append(packed, 0)
+	}
+
+	m := &ScreamTracker{}
+	if err := m.Load(buildTestS3M(nil, [][]byte{packed, nil})); err != nil {
+		t.Fatalf("Unexpected error loading S3M: %v", err)
+	}
+	if m.NumPatterns() != 2 {
+		t.Fatalf("Expected 2 patterns, got %d", m.NumPatterns())
+	}
+
+	pattern, _ := m.GetPattern(0)
+	if pattern.NumChannels() != 32 || pattern.NumRows() != 64 {
+		t.Fatalf("Expected 32 channels and 64 rows, got %d,%d", pattern.NumChannels(), pattern.NumRows())
+	}
+	row, _ := pattern.GetRow(0)
+	n := row.Notes()[0]
+	if s, _ := n.ToString(); s != "C-5" || n.Instrument() != 1 || !n.HasVolume() || n.Volume() != 0 {
+		t.Errorf("Expected C-5,1 with volume 0 got %s,%d,%v,%d", s, n.Instrument(), n.HasVolume(), n.Volume())
+	}
+	n = row.Notes()[9]
+	if n.Effect() != 1 || n.Parameter() != 6 || n.HasVolume() {
+		t.Errorf("Expected command 1,6 with no volume, got %d,%d,%v", n.Effect(), n.Parameter(), n.HasVolume())
+	}
+	row, _ = pattern.GetRow(1)
+	if row.Notes()[31].Key() != KeyCut {
+		t.Errorf("Expected note cut, got %d", row.Notes()[31].Key())
+	}
+
+	empty, _ := m.GetPattern(1)
+	if empty.NumRows() != 64 {
+		t.Errorf("Expected empty 64 row pattern, got %d rows", empty.NumRows())
+	}
+}
+
+func TestScreamTrackerTruncatedPattern(t *testing.T) {
+	m := &ScreamTracker{}
+	if err := m.Load(buildTestS3M(nil, [][]byte{{0x60, 0x50}})); err == nil {
+		t.Errorf("Expected error for truncated pattern data")
+	}
+}
+
+func TestScreamTrackerChannels(t *testing.T) {
+	data := buildTestS3M(nil, nil)
+	data[51] = 0x80 | 0x30	// stereo
+	data[53] = 252
+	// a disabled channel past the last enabled one isn't counted
+	copy(data[64:73], []byte{0, 8, 1, 9, 16, 0x80 | 2, 255, 25, 0x80 | 3})
+	pan := make([]byte, 32)
+	pan[1] = 0x20 | 0x5
+	pan[2] = 0x0f	// not flagged, so ignored
+	data = append(data, pan...)
+
+	m := &ScreamTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading S3M: %v", err)
+	}
+	if m.NumChannels() != 8 {
+		t.Errorf("Expected 8 channels, got %d", m.NumChannels())
+	}
+
+	expected := []STChannel{
+		{ST_CHANNEL_LEFT, 0, true, ST_PAN_LEFT},
+		{ST_CHANNEL_RIGHT, 0, true, 0x5},
+		{ST_CHANNEL_LEFT, 1, true, ST_PAN_LEFT},
+		{ST_CHANNEL_RIGHT, 1, true, ST_PAN_RIGHT},
+		{ST_CHANNEL_ADLIB_MELODY, 0, true, ST_PAN_CENTER},
+		{ST_CHANNEL_LEFT, 2, false, ST_PAN_LEFT},
+		{ST_CHANNEL_UNUSED, 0, false, ST_PAN_CENTER},
+		{ST_CHANNEL_ADLIB_DRUM, 0, true, ST_PAN_CENTER},
+	}
+	channels := m.Channels()
+	for i, channel := range expected {
+		if channels[i] != channel {
+			t.Errorf("Channel %d: expected %+v, got %+v", i, channel, channels[i])
+		}
+	}
+
+	// in mono the panning table is ignored
+	data[51] = 0x30
+	m = &ScreamTracker{}
+	if err := m.Load(data); err != nil {
+		t.Fatalf("Unexpected error loading S3M: %v", err)
+	}
+	if channel := m.Channels()[1]; channel.Pan != ST_PAN_CENTER {
+		t.Errorf("Expected a centred channel in mono, got %+v", channel)
+	}
+}
+
+func TestScreamTrackerAdlibInstruments(t *testing.T) {
+	adlib := make([]byte, 80)
+	adlib[0] = ST_INSTRUMENT_ADLIB_SNARE
+	copy(adlib[1:13], "SNARE.INS")
+	copy(adlib[16:28], []byte{0x01, 0x11, 0x4f, 0x00, 0xf1, 0xd2, 0x53, 0x74, 0x00, 0x00, 0x06, 0x00})
+	adlib[28] = 48
+	binary.LittleEndian.PutUint32(adlib[32:36], 8363)
+	copy(adlib[48:76], "Snare drum")
+	copy(adlib[76:80], "SCRI")
+
+	m := &ScreamTracker{}
+	if err := m.Load(buildTestS3M([][]byte{adlib, make([]byte, 80)}, nil)); err != nil {
+		t.Fatalf("Unexpected error loading S3M: %v", err)
+	}
+	if len(m.Instruments()) != 1 || len(m.Samples()) != 0 {
+		t.Fatalf("Expected 1 instrument and no samples, got %d,%d", len(m.Instruments()), len(m.Samples()))
+	}
+	instrument := m.AdlibInstruments()[0]
+	if instrument.Name() != "Snare drum" || instrument.Filename() != "SNARE.INS" || !instrument.IsDrum() {
+		t.Errorf("Unexpected instrument %s,%s,%v", instrument.Name(), instrument.Filename(), instrument.IsDrum())
+	}
+	if instrument.Registers()[2] != 0x4f || instrument.Registers()[10] != 0x06 || instrument.Volume() != 48 || instrument.C2Spd() != 8363 {
+		t.Errorf("Unexpected instrument settings %v,%d,%d", instrument.Registers(), instrument.Volume(), instrument.C2Spd())
+	}
+}
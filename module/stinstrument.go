@@ -0,0 +1,68 @@
+package module
+
+// S3M instrument types
+const (
+	ST_INSTRUMENT_EMPTY = iota
+	ST_INSTRUMENT_SAMPLE
+	ST_INSTRUMENT_ADLIB_MELODY
+	ST_INSTRUMENT_ADLIB_BASSDRUM
+	ST_INSTRUMENT_ADLIB_SNARE
+	ST_INSTRUMENT_ADLIB_TOM
+	ST_INSTRUMENT_ADLIB_CYMBAL
+	ST_INSTRUMENT_ADLIB_HIHAT
+)
+
+// STAdlibInstrument is an AdLib (OPL2) instrument from an S3M file. It has no
+// sample data, just the register values used to program the FM synth.
+type STAdlibInstrument struct {
+	name string
+	filename string
+	instrumentType uint8
+	registers [12]uint8
+	volume uint8
+	c2spd uint32
+	Instrument
+}
+
+func (i STAdlibInstrument) Name() string {
+	return i.name
+}
+
+func (i STAdlibInstrument) Filename() string {
+	return i.filename
+}
+
+// Type returns one of the ST_INSTRUMENT_ADLIB_* types
+func (i STAdlibInstrument) Type() uint8 {
+	return i.instrumentType
+}
+
+func (i STAdlibInstrument) IsDrum() bool {
+	return i.instrumentType > ST_INSTRUMENT_ADLIB_MELODY
+}
+
+// Registers returns the OPL2 register bytes, in order:
+//
+//	0: modulator characteristic (tremolo, vibrato, sustain, KSR, multiplier)
+//	1: carrier characteristic
+//	2: modulator key scale level / output level
+//	3: carrier key scale level / output level
+//	4: modulator attack / decay
+//	5: carrier attack / decay
+//	6: modulator sustain / release
+//	7: carrier sustain / release
+//	8: modulator wave select
+//	9: carrier wave select
+//	10: feedback / connection
+//	11: unused
+func (i STAdlibInstrument) Registers() [12]uint8 {
+	return i.registers
+}
+
+func (i STAdlibInstrument) Volume() uint8 {
+	return i.volume
+}
+
+func (i STAdlibInstrument) C2Spd() uint32 {
+	return i.c2spd
+}
@@ -35,3 +35,23 @@ func (i STSample) Data() []byte {
 	}
 	return r
 }
+
+func (i STSample) Length() uint32 {
+	return i.length
+}
+
+func (i STSample) LoopStart() uint32 {
+	return i.loopStart
+}
+
+func (i STSample) LoopEnd() uint32 {
+	return i.loopEnd
+}
+
+func (i STSample) Volume() uint8 {
+	return i.volume
+}
+
+func (i STSample) C2Spd() uint32 {
+	return i.c2spd
+}
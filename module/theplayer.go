@@ -0,0 +1,253 @@
+package module
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The Player 5.0A and 6.0A modules may start with a "P50A" or "P60A"
+// signature, though the replayer doesn't need it and it's often left out.
+// Then come the offset of the sample data, the number of patterns and the
+// number of samples, whose top two bits are flags in 6.0A: bit 6 for sample
+// data stored as deltas and bit 7 for samples packed to 4 bit deltas, which
+// aren't supported. Sample headers are 6 bytes: the length in words, the
+// finetune, the volume and the loop start in words, or 0xFFFF for none, as
+// loops always run to the end of the sample. A length above 0xFF00 instead
+// shares the data of an earlier sample, 0xFFFF for the first. The track
+// table gives the offsets of each pattern's 4 tracks from the start of the
+// pattern data, and is followed by the position list, holding pattern*2 and
+// ending with 0xFF, then the pattern data. Notes are 3 bytes: the note
+// number (1 for C-1 up to 36) and the top bit of the sample number, the rest
+// of the sample number and the effect, then the parameter. When the top bit
+// of the first byte is set a fourth byte follows, giving a number of empty
+// rows after the note. 6.0A can also repeat rows from earlier in the pattern
+// data: 0xFF, the number of rows, then the offset of the first as a word.
+
+// playerSignature returns the optional signature of a version, e.g. "P60A"
+func playerSignature(version int) string {
+	return fmt.Sprintf("P%dA", version)
+}
+
+func parsePlayer(data []byte, version int) (packedMOD, error) {
+	r := reader{format: fmt.Sprintf("theplayer%d", version), data: data}
+	base := 0
+	if (len(data) >= 4 && string(data[0:4]) == playerSignature(version)) {
+		base = 4
+	}
+	if err := r.check("header", base, 4); err != nil {
+		return packedMOD{}, err
+	}
+	sampleDataOffset := base + int(binary.BigEndian.Uint16(data[base:base+2]))
+	numPatterns := int(data[base+2])
+	numSamples := int(data[base+3])
+	delta := false
+	if (version == 60) {
+		if (numSamples & 0x80 != 0) {
+			return packedMOD{}, r.invalid("numSamples", base + 3, "4 bit packed samples")
+		}
+		delta = numSamples & 0x40 != 0
+		numSamples &= 0x3F
+	}
+	if (numPatterns == 0) {
+		return packedMOD{}, r.invalid("numPatterns", base + 2, numPatterns)
+	}
+	if (numSamples == 0 || numSamples > 31) {
+		return packedMOD{}, r.invalid("numSamples", base + 3, numSamples)
+	}
+
+	p := packedMOD{samples: make([]byte, 31*8), restartPos: 0x7F}
+	offset := base + 4
+	if err := r.check("samples", offset, numSamples*6); err != nil {
+		return packedMOD{}, err
+	}
+	starts := make([]int, numSamples)
+	lengths := make([]int, numSamples)
+	sampleSize := 0
+	for i := 0; i < numSamples; i++ {
+		header := data[offset+i*6:offset+i*6+6]
+		field := fmt.Sprintf("sample[%d]", i)
+		length := int(binary.BigEndian.Uint16(header[0:2]))
+		if (length > 0xFF00) {
+			shared := 0xFFFF - length
+			if (shared >= i) {
+				return packedMOD{}, r.invalid(field + ".length", offset + i*6, length)
+			}
+			starts[i] = starts[shared]
+			length = lengths[shared]
+		} else {
+			starts[i] = sampleSize
+			sampleSize += length * 2
+		}
+		lengths[i] = length
+		if (header[2] > 0x0F) {
+			return packedMOD{}, r.invalid(field + ".finetune", offset + i*6 + 2, header[2])
+		}
+		if (header[3] > 0x40) {
+			return packedMOD{}, r.invalid(field + ".volume", offset + i*6 + 3, header[3])
+		}
+		loopStart := int(binary.BigEndian.Uint16(header[4:6]))
+		loopLength := 1
+		if (loopStart == 0xFFFF) {
+			loopStart = 0
+		} else if (loopStart > length) {
+			return packedMOD{}, r.invalid(field + ".loopStart", offset + i*6 + 4, loopStart)
+		} else {
+			loopLength = length - loopStart
+		}
+		sample := p.samples[i*8:i*8+8]
+		binary.BigEndian.PutUint16(sample[0:2], uint16(length))
+		sample[2] = header[2]
+		sample[3] = header[3]
+		binary.BigEndian.PutUint16(sample[4:6], uint16(loopStart))
+		binary.BigEndian.PutUint16(sample[6:8], uint16(loopLength))
+	}
+	if (sampleSize == 0) {
+		return packedMOD{}, r.invalid("samples", offset, "no sample data")
+	}
+	offset += numSamples*6
+
+	if err := r.check("trackTable", offset, numPatterns*8); err != nil {
+		return packedMOD{}, err
+	}
+	trackTable := offset
+	offset += numPatterns*8
+	for {
+		field := fmt.Sprintf("position[%d]", p.songLength)
+		if err := r.check(field, offset, 1); err != nil {
+			return packedMOD{}, err
+		}
+		value := int(data[offset])
+		if (value == 0xFF) {
+			offset++
+			break
+		}
+		if (p.songLength == 128 || value % 2 != 0 || value / 2 >= numPatterns) {
+			return packedMOD{}, r.invalid(field, offset, value)
+		}
+		for channel := 0; channel < 4; channel++ {
+			tableOffset := trackTable + value/2*8 + channel*2
+			p.tracks[channel][p.songLength] = int(binary.BigEndian.Uint16(data[tableOffset:tableOffset+2]))
+		}
+		p.songLength++
+		offset++
+	}
+	if (p.songLength == 0) {
+		return packedMOD{}, r.invalid("positions", offset - 1, 0)
+	}
+
+	patternData := offset
+	if (sampleDataOffset < patternData) {
+		return packedMOD{}, r.invalid("sampleDataOffset", base, sampleDataOffset - base)
+	}
+	sampleData, err := r.slice("sampleData", sampleDataOffset, sampleSize)
+	if err != nil {
+		return packedMOD{}, err
+	}
+	// tracks can't run on into the sample data
+	patterns := reader{format: r.format, data: data[:sampleDataOffset]}
+	notes := make(map[int][]byte)
+	for _, channel := range p.tracks {
+		for _, track := range channel[:p.songLength] {
+			if _, ok := notes[track]; ok {
+				continue
+			}
+			field := fmt.Sprintf("track[%d]", track)
+			notes[track], err = readPlayerRows(patterns, field, patternData, patternData + track, 64, version == 60)
+			if err != nil {
+				return packedMOD{}, err
+			}
+		}
+	}
+	p.note = func(track int, row int) []byte {
+		return notes[track][row*4:row*4+4]
+	}
+
+	// samples sharing data each get their own copy in the MOD
+	for i := range starts {
+		stored := sampleData[starts[i]:starts[i]+lengths[i]*2]
+		if !delta {
+			p.sampleData = append(p.sampleData, stored...)
+			continue
+		}
+		value := byte(0)
+		for _, b := range stored {
+			value += b
+			p.sampleData = append(p.sampleData, value)
+		}
+	}
+	p.end = sampleDataOffset + sampleSize
+	return p, nil
+}
+
+// readPlayerRows converts count rows of a track starting at offset to MOD
+// notes. Rows repeated from earlier can't themselves repeat others.
+func readPlayerRows(r reader, field string, patternData int, offset int, count int, repeats bool) ([]byte, error) {
+	notes := make([]byte, 0, count*4)
+	for len(notes) < count*4 {
+		row := len(notes) / 4
+		rowField := fmt.Sprintf("%s.row[%d]", field, row)
+		if err := r.check(rowField, offset, 1); err != nil {
+			return nil, err
+		}
+		if (repeats && r.data[offset] == 0xFF) {
+			if err := r.check(rowField, offset, 4); err != nil {
+				return nil, err
+			}
+			n := int(r.data[offset+1])
+			from := patternData + int(binary.BigEndian.Uint16(r.data[offset+2:offset+4]))
+			if (n == 0 || row + n > count || from >= offset) {
+				return nil, r.invalid(rowField, offset, fmt.Sprintf("%x", r.data[offset:offset+4]))
+			}
+			repeated, err := readPlayerRows(r, rowField, patternData, from, n, false)
+			if err != nil {
+				return nil, err
+			}
+			notes = append(notes, repeated...)
+			offset += 4
+			continue
+		}
+		if err := r.check(rowField, offset, 3); err != nil {
+			return nil, err
+		}
+		note := r.data[offset:offset+3]
+		number := int(note[0] >> 1 & 0x3F)
+		if (number > 36) {
+			return nil, r.invalid(rowField, offset, fmt.Sprintf("%x", note))
+		}
+		notes = append(notes, modNote(packedPeriod(number), int(note[0] & 1) << 4 | int(note[1] >> 4), note[1] & 0x0F, note[2])...)
+		offset += 3
+		if (note[0] & 0x80 != 0) {
+			if err := r.check(rowField, offset, 1); err != nil {
+				return nil, err
+			}
+			empty := int(r.data[offset])
+			if (row + 1 + empty > count) {
+				return nil, r.invalid(rowField, offset, empty)
+			}
+			notes = append(notes, make([]byte, empty*4)...)
+			offset++
+		}
+	}
+	return notes, nil
+}
+
+func detectPlayer(data []byte, version int) int {
+	signed := len(data) >= 4 && string(data[0:4]) == playerSignature(version)
+	if (!signed && version == 50) {
+		// without a signature 5.0A reads the same as 6.0A, the more common
+		return SCORE_NONE
+	}
+	p, err := parsePlayer(data, version)
+	if (err == nil && signed) {
+		return SCORE_STRONG
+	}
+	return scorePacked(p, err, data)
+}
+
+func unpackPlayer(data []byte, version int) ([]byte, error) {
+	p, err := parsePlayer(data, version)
+	if err != nil {
+		return nil, err
+	}
+	return rebuildMOD(p), nil
+}
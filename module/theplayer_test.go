@@ -0,0 +1,201 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// packPlayer packs a 4 channel MOD as the given version of The Player does,
+// with or without a signature. Version 6.0A stores the samples as deltas,
+// and repeats the second half of a track when an earlier track has the same.
+// Samples with the same data share it.
+func packPlayer(mod []byte, version int, signed bool) []byte {
+	numSamples, numPatterns := modCounts(mod)
+	songLength := int(mod[950])
+
+	out := make([]byte, 0)
+	if (signed) {
+		out = append(out, playerSignature(version)...)
+	}
+	base := len(out)
+	flags := 0
+	if (version == 60) {
+		flags = 0x40
+	}
+	out = append(out, 0, 0, byte(numPatterns), byte(numSamples | flags))
+
+	sampleData := make([]byte, 0)
+	stored := make([][]byte, 0)
+	offset := 1084 + numPatterns*1024
+	for i := 0; i < numSamples; i++ {
+		header := mod[20+i*30+22:20+i*30+30]
+		length := int(binary.BigEndian.Uint16(header[0:2]))
+		data := mod[offset:offset+length*2]
+		offset += length*2
+		shared := -1
+		for j, earlier := range stored {
+			if (length > 0 && bytes.Equal(earlier, data)) {
+				shared = j
+				break
+			}
+		}
+		stored = append(stored, data)
+		if (shared >= 0) {
+			out = binary.BigEndian.AppendUint16(out, uint16(0xFFFF - shared))
+		} else {
+			out = append(out, header[0:2]...)
+			previous := byte(0)
+			for _, b := range data {
+				if (version == 60) {
+					sampleData = append(sampleData, b - previous)
+				} else {
+					sampleData = append(sampleData, b)
+				}
+				previous = b
+			}
+		}
+		out = append(out, header[2:4]...)
+		if (binary.BigEndian.Uint16(header[6:8]) > 1) {
+			out = append(out, header[4:6]...)
+		} else {
+			out = append(out, 0xFF, 0xFF)
+		}
+	}
+
+	trackTable := len(out)
+	out = append(out, make([]byte, numPatterns*8)...)
+	for pos := 0; pos < songLength; pos++ {
+		out = append(out, mod[952+pos] * 2)
+	}
+	out = append(out, 0xFF)
+
+	trackOffsets := make(map[string]int)
+	halves := make(map[string]int)
+	patternData := make([]byte, 0)
+	for pattern := 0; pattern < numPatterns; pattern++ {
+		for channel := 0; channel < 4; channel++ {
+			notes := make([]byte, 0)
+			for row := 0; row < 64; row++ {
+				notes = append(notes, modPatternNote(mod, pattern, row, channel)...)
+			}
+			offset, ok := trackOffsets[string(notes)]
+			if !ok {
+				offset = len(patternData)
+				trackOffsets[string(notes)] = offset
+				// each half is packed on its own, so the second can be repeated
+				for half := 0; half < 2; half++ {
+					rows := notes[half*128:half*128+128]
+					if earlier, ok := halves[string(rows)]; ok && half == 1 && version == 60 {
+						patternData = append(patternData, 0xFF, 32)
+						patternData = binary.BigEndian.AppendUint16(patternData, uint16(earlier))
+						continue
+					}
+					if (half == 1) {
+						halves[string(rows)] = len(patternData)
+					}
+					patternData = append(patternData, packPlayerRows(rows)...)
+				}
+			}
+			binary.BigEndian.PutUint16(out[trackTable+pattern*8+channel*2:], uint16(offset))
+		}
+	}
+	out = append(out, patternData...)
+	binary.BigEndian.PutUint16(out[base:], uint16(len(out) - base))
+	return append(out, sampleData...)
+}
+
+// packPlayerRows packs MOD notes, following each note with the number of
+// empty rows after it
+func packPlayerRows(notes []byte) []byte {
+	out := make([]byte, 0)
+	for row := 0; row < len(notes)/4; {
+		note := notes[row*4:row*4+4]
+		period := int(note[0] & 0x0F) << 8 | int(note[1])
+		sample := int(note[0] & 0xF0) | int(note[2] >> 4)
+		packed := []byte{byte(packedNumber(period) << 1 | sample >> 4), byte(sample << 4) | note[2] & 0x0F, note[3]}
+		row++
+		empty := 0
+		for row < len(notes)/4 && bytes.Equal(notes[row*4:row*4+4], make([]byte, 4)) {
+			empty++
+			row++
+		}
+		if (empty > 0) {
+			packed[0] |= 0x80
+			packed = append(packed, byte(empty))
+		}
+		out = append(out, packed...)
+	}
+	return out
+}
+
+func TestThePlayer(t *testing.T) {
+	mod := buildPackableMOD()
+	// The Player loops always run to the end of the sample
+	binary.BigEndian.PutUint16(mod[20+28:], 12)
+	// a second sample with the same data as the first
+	copy(mod[20+30+22:20+30+30], mod[20+22:20+30])
+	mod[20+30+24] = 3
+	mod = append(mod, mod[len(mod)-32:]...)
+	// a track whose second half is the same as an earlier track's
+	for row := 32; row < 64; row++ {
+		copy(modPatternNote(mod, 2, row, 0), modPatternNote(mod, 0, row, 0))
+	}
+
+	for _, tt := range []struct {
+		version int
+		signed bool
+		format string
+		tracker string
+	}{
+		{50, true, "theplayer50", "The Player 5.0A"},
+		{60, true, "theplayer60", "The Player 6.0A"},
+		{60, false, "theplayer60", "The Player 6.0A"},
+	} {
+		packed := packPlayer(mod, tt.version, tt.signed)
+		format, err := DetectFormat(packed, "")
+		if (err != nil || format.Name != tt.format) {
+			t.Errorf("%s: detected as %q, %v", tt.format, format.Name, err)
+		}
+
+		unpacked, err := unpackPlayer(packed, tt.version)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+		} else if !bytes.Equal(unpacked, mod) {
+			t.Errorf("%s: unpacked module doesn't match the original", tt.format)
+		}
+
+		m, err := LoadBytes(packed, "song")
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.format, err)
+			continue
+		}
+		pt := m.(*ProTracker)
+		if (pt.Type() != PROTRACKER || pt.NumPatterns() != 3 || pt.Tracker().String() != tt.tracker) {
+			t.Errorf("%s: got type %d with %d patterns from %s", tt.format, pt.Type(), pt.NumPatterns(), pt.Tracker())
+		}
+		for i := 0; i < 2; i++ {
+			sample, err := pt.GetSample(i)
+			if (err != nil || len(sample.Data()) != 32 || sample.Data()[31] != 248) {
+				t.Errorf("%s: got sample %d %+v, %v", tt.format, i, sample, err)
+			}
+		}
+		row, _ := pt.patterns[2].GetRow(48)
+		note := row.Notes()[0]
+		if (note.Period() != 428 || note.Instrument() != 1 || note.Effect() != 0xC || note.Parameter() != 48) {
+			t.Errorf("%s: got note %+v", tt.format, note)
+		}
+	}
+
+	// only 6.0A repeats rows
+	if (len(packPlayer(mod, 60, true)) >= len(packPlayer(mod, 50, true))) {
+		t.Errorf("Expected The Player 6.0A to be smaller than 5.0A")
+	}
+	// a repeat of rows that haven't been read yet
+	packed := packPlayer(mod, 60, false)
+	at := bytes.LastIndex(packed, []byte{0xFF, 32})
+	binary.BigEndian.PutUint16(packed[at+2:], 0x7FFF)
+	if _, err := unpackPlayer(packed, 60); err == nil {
+		t.Errorf("Expected an error for a repeat of later rows")
+	}
+}
@@ -0,0 +1,191 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Confidence says how sure a fingerprint is about the tracker that saved a module
+type Confidence int
+const (
+	CONFIDENCE_NONE = iota
+	CONFIDENCE_LOW
+	CONFIDENCE_MEDIUM
+	CONFIDENCE_HIGH
+)
+
+// TrackerInfo identifies the program that most likely created a module.
+// Version is empty when it can't be determined.
+type TrackerInfo struct {
+	Name string
+	Version string
+	Confidence Confidence
+}
+
+func (c Confidence) String() string {
+	switch c {
+	case CONFIDENCE_LOW:
+		return "low"
+	case CONFIDENCE_MEDIUM:
+		return "medium"
+	case CONFIDENCE_HIGH:
+		return "high"
+	}
+	return "none"
+}
+
+func (t TrackerInfo) String() string {
+	if (t.Version == "") {
+		return t.Name
+	}
+	return t.Name + " " + t.Version
+}
+
+// unknownTracker is returned when nothing in the file gives the tracker away
+var unknownTracker = TrackerInfo{Name: "Unknown", Confidence: CONFIDENCE_NONE}
+
+// bcdVersion formats the common major.minor layout used by ST3 and IT, where
+// the minor version is stored as two BCD digits in the low byte
+func bcdVersion(v uint16) string {
+	return fmt.Sprintf("%d.%02x", (v >> 8) & 0xf, v & 0xff)
+}
+
+// xmTrackers maps the tracker name prefixes seen in XM headers to the tracker
+// that writes them. Entries are checked in order.
+var xmTrackers = []struct {
+	prefix string
+	name string
+}{
+	{"FastTracker v 2.00", "ModPlug Tracker"},
+	{"OpenMPT ", "OpenMPT"},
+	{"MilkyTracker ", "MilkyTracker"},
+	{"MadTracker 2.0", "MadTracker"},
+	{"Fasttracker II clone", "Fasttracker II clone"},
+	{"Velvet Studio", "Velvet Studio"},
+	{"DigiBooster Pro", "DigiBooster Pro"},
+	{"BeRoTracker", "BeRoTracker"},
+	{"Skale Tracker", "Skale Tracker"},
+	{"*Converted ", "Converter"},
+}
+
+// fingerprintXM identifies the tracker from the XM tracker name and version fields
+func fingerprintXM(trackerName string, version uint16) TrackerInfo {
+	name := strings.TrimRight(trackerName, " ")
+	if (strings.HasPrefix(name, "FastTracker v2.00")) {
+		// FT2 writes version 0x0104; earlier versions were FT2 betas. Several
+		// other trackers write the same string, so this is only a guess.
+		if (version == 0x0104) {
+			return TrackerInfo{Name: "FastTracker", Version: "2", Confidence: CONFIDENCE_MEDIUM}
+		}
+		return TrackerInfo{Name: "FastTracker", Version: "2 (beta)", Confidence: CONFIDENCE_LOW}
+	}
+	for _, t := range xmTrackers {
+		if (strings.HasPrefix(name, t.prefix)) {
+			version := strings.TrimSpace(strings.TrimPrefix(name, t.prefix))
+			if (t.name == "ModPlug Tracker") {
+				version = ""
+			}
+			return TrackerInfo{Name: t.name, Version: version, Confidence: CONFIDENCE_HIGH}
+		}
+	}
+	if (name != "") {
+		return TrackerInfo{Name: name, Confidence: CONFIDENCE_LOW}
+	}
+	return unknownTracker
+}
+
+// fingerprintS3M identifies the tracker from the S3M trackerVersion field,
+// whose upper four bits are a tracker ID and lower 12 bits a version
+func fingerprintS3M(trackerVersion uint16) TrackerInfo {
+	id := trackerVersion >> 12
+	v := trackerVersion & 0xfff
+	switch id {
+	case 1:
+		// ModPlug Tracker and friends also claim to be ST3.20
+		return TrackerInfo{Name: "Scream Tracker", Version: bcdVersion(v), Confidence: CONFIDENCE_MEDIUM}
+	case 2:
+		return TrackerInfo{Name: "Imago Orpheus", Version: bcdVersion(v), Confidence: CONFIDENCE_HIGH}
+	case 3:
+		return TrackerInfo{Name: "Impulse Tracker", Version: bcdVersion(v), Confidence: CONFIDENCE_HIGH}
+	case 4:
+		return TrackerInfo{Name: "Schism Tracker", Version: schismVersion(v), Confidence: CONFIDENCE_HIGH}
+	case 5:
+		return TrackerInfo{Name: "OpenMPT", Version: fmt.Sprintf("%d.%02x", v >> 8, v & 0xff), Confidence: CONFIDENCE_HIGH}
+	case 6:
+		return TrackerInfo{Name: "BeRoTracker", Confidence: CONFIDENCE_HIGH}
+	case 7:
+		return TrackerInfo{Name: "CreamTracker", Version: bcdVersion(v), Confidence: CONFIDENCE_HIGH}
+	}
+	return unknownTracker
+}
+
+// schismEpoch is day zero for Schism Tracker's date-based versions
+var schismEpoch = time.Date(2009, 10, 31, 0, 0, 0, 0, time.UTC)
+
+// schismVersion decodes Schism Tracker's 12-bit version: early builds used
+// 0.xx numbering, later builds count days since 2009-10-31
+func schismVersion(v uint16) string {
+	if (v == 0xfff) {
+		// the real version is stored elsewhere in the file
+		return ""
+	}
+	if (v < 0x050) {
+		return fmt.Sprintf("0.%x", v)
+	}
+	return schismEpoch.AddDate(0, 0, int(v - 0x050)).Format("2006-01-02")
+}
+
+// fingerprintIT identifies the tracker from the IT Cwt/v (created with
+// tracker) and Cmwt (compatible with tracker) fields
+func fingerprintIT(cwtv uint16, cmwt uint16) TrackerInfo {
+	switch {
+	case cwtv == 0x0888 || cmwt == 0x0888:
+		return TrackerInfo{Name: "OpenMPT", Version: "1.17+", Confidence: CONFIDENCE_HIGH}
+	case cwtv >> 12 == 0:
+		// ModPlug Tracker also writes 2.14 with a compatible version of 2.00
+		if (cwtv == 0x0214 && cmwt == 0x0200) {
+			return TrackerInfo{Name: "ModPlug Tracker", Confidence: CONFIDENCE_LOW}
+		}
+		return TrackerInfo{Name: "Impulse Tracker", Version: bcdVersion(cwtv), Confidence: CONFIDENCE_MEDIUM}
+	case cwtv >> 12 == 1:
+		return TrackerInfo{Name: "Schism Tracker", Version: schismVersion(cwtv & 0xfff), Confidence: CONFIDENCE_HIGH}
+	case cwtv >> 12 == 5:
+		return TrackerInfo{Name: "OpenMPT", Version: fmt.Sprintf("%d.%02x", (cwtv >> 8) & 0xf, cwtv & 0xff), Confidence: CONFIDENCE_HIGH}
+	case cwtv >> 12 == 6:
+		return TrackerInfo{Name: "BeRoTracker", Confidence: CONFIDENCE_HIGH}
+	}
+	return unknownTracker
+}
+
+// fingerprintMOD guesses the tracker from a MOD file's tag and the byte after
+// the song length, which ProTracker always sets to 127
+func fingerprintMOD(tag string, restartPos uint8) TrackerInfo {
+	switch tag {
+	case "M.K.":
+		if (restartPos == 0x7f) {
+			return TrackerInfo{Name: "ProTracker", Confidence: CONFIDENCE_MEDIUM}
+		}
+		return TrackerInfo{Name: "NoiseTracker", Confidence: CONFIDENCE_LOW}
+	case "M!K!":
+		return TrackerInfo{Name: "ProTracker", Version: "2.3", Confidence: CONFIDENCE_HIGH}
+	case "FLT4", "FLT8":
+		return TrackerInfo{Name: "StarTrekker", Confidence: CONFIDENCE_HIGH}
+	case "CD81", "OKTA", "OCTA":
+		return TrackerInfo{Name: "Octalyser", Confidence: CONFIDENCE_MEDIUM}
+	case "FA04", "FA06", "FA08":
+		return TrackerInfo{Name: "Digital Tracker", Confidence: CONFIDENCE_HIGH}
+	}
+
+	channels := ModTagChannels(tag)
+	switch {
+	case channels == 0:
+		return unknownTracker
+	case strings.HasPrefix(tag, "TDZ"):
+		return TrackerInfo{Name: "TakeTracker", Confidence: CONFIDENCE_HIGH}
+	case strings.HasSuffix(tag, "CHN") && channels % 2 == 1:
+		// FastTracker only supports an even number of channels
+		return TrackerInfo{Name: "TakeTracker", Confidence: CONFIDENCE_MEDIUM}
+	}
+	return TrackerInfo{Name: "FastTracker", Confidence: CONFIDENCE_MEDIUM}
+}
@@ -0,0 +1,38 @@
+package module
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		desc string
+		got TrackerInfo
+		want TrackerInfo
+	}{
+		{"FT2 XM", fingerprintXM("FastTracker v2.00   ", 0x0104), TrackerInfo{"FastTracker", "2", CONFIDENCE_MEDIUM}},
+		{"MilkyTracker XM", fingerprintXM("MilkyTracker 1.04.00", 0x0104), TrackerInfo{"MilkyTracker", "1.04.00", CONFIDENCE_HIGH}},
+		{"ModPlug XM", fingerprintXM("FastTracker v 2.00  ", 0x0104), TrackerInfo{"ModPlug Tracker", "", CONFIDENCE_HIGH}},
+		{"empty XM", fingerprintXM("", 0x0104), unknownTracker},
+		{"ST3 S3M", fingerprintS3M(0x1320), TrackerInfo{"Scream Tracker", "3.20", CONFIDENCE_MEDIUM}},
+		{"IT S3M", fingerprintS3M(0x3214), TrackerInfo{"Impulse Tracker", "2.14", CONFIDENCE_HIGH}},
+		{"Schism S3M", fingerprintS3M(0x4051), TrackerInfo{"Schism Tracker", "2009-11-01", CONFIDENCE_HIGH}},
+		{"OpenMPT S3M", fingerprintS3M(0x5129), TrackerInfo{"OpenMPT", "1.29", CONFIDENCE_HIGH}},
+		{"IT 2.15", fingerprintIT(0x0215, 0x0214), TrackerInfo{"Impulse Tracker", "2.15", CONFIDENCE_MEDIUM}},
+		{"ModPlug IT", fingerprintIT(0x0214, 0x0200), TrackerInfo{"ModPlug Tracker", "", CONFIDENCE_LOW}},
+		{"OpenMPT 1.17 IT", fingerprintIT(0x0888, 0x0888), TrackerInfo{"OpenMPT", "1.17+", CONFIDENCE_HIGH}},
+		{"Schism IT", fingerprintIT(0x1020, 0x0214), TrackerInfo{"Schism Tracker", "0.20", CONFIDENCE_HIGH}},
+		{"ProTracker MOD", fingerprintMOD("M.K.", 0x7f), TrackerInfo{"ProTracker", "", CONFIDENCE_MEDIUM}},
+		{"NoiseTracker MOD", fingerprintMOD("M.K.", 0), TrackerInfo{"NoiseTracker", "", CONFIDENCE_LOW}},
+		{"StarTrekker MOD", fingerprintMOD("FLT4", 0), TrackerInfo{"StarTrekker", "", CONFIDENCE_HIGH}},
+		{"FastTracker MOD", fingerprintMOD("12CH", 0x7f), TrackerInfo{"FastTracker", "", CONFIDENCE_MEDIUM}},
+		{"TakeTracker MOD", fingerprintMOD("TDZ3", 0x7f), TrackerInfo{"TakeTracker", "", CONFIDENCE_HIGH}},
+		{"unknown MOD", fingerprintMOD("", 0x7f), unknownTracker},
+	}
+
+	for _, tt := range tests {
+		if (tt.got != tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.desc, tt.got, tt.want)
+		}
+	}
+}
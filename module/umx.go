@@ -0,0 +1,238 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Unreal packages (.umx), holding the music for Unreal, Unreal Tournament,
+// Deus Ex and other games on the engine. The header points to a table of
+// names, a table of objects imported from other packages and a table of the
+// objects exported by this one. Music is an export whose class is the
+// imported "Music" class, and its serialized data ends with the module file
+// itself. Most numbers in the tables are compact indexes: a sign bit and 6
+// bits in the first byte, then 7 bits in each following byte, while the top
+// bit of each byte says whether another follows.
+
+const umxSignature = 0x9E2A83C1
+const umxHeaderSize = 36
+
+// ErrNotUMX is returned when extracting from data that isn't an Unreal package
+var ErrNotUMX = errors.New("not an Unreal package")
+
+// ErrNoMusic is returned when an Unreal package holds no Music object
+var ErrNoMusic = errors.New("no music in Unreal package")
+
+// IsUMX reports whether data is an Unreal package
+func IsUMX(data []byte) bool {
+	return len(data) >= umxHeaderSize && binary.LittleEndian.Uint32(data[0:4]) == umxSignature
+}
+
+// readUMXIndex reads the compact index at offset, returning it and the
+// offset just past it
+func readUMXIndex(r reader, field string, offset int) (int, int, error) {
+	if err := r.check(field, offset, 1); err != nil {
+		return 0, offset, err
+	}
+	b := r.data[offset]
+	negative := b & 0x80 != 0
+	value := int(b & 0x3F)
+	more := b & 0x40 != 0
+	pos := offset + 1
+	for shift := 6; more && shift < 34; shift += 7 {
+		if err := r.check(field, pos, 1); err != nil {
+			return 0, offset, err
+		}
+		b = r.data[pos]
+		value |= int(b & 0x7F) << shift
+		more = b & 0x80 != 0
+		pos++
+	}
+	if (negative) {
+		value = -value
+	}
+	return value, pos, nil
+}
+
+// umxExport is an entry in the export table
+type umxExport struct {
+	class int
+	name int
+	serialSize int
+	serialOffset int
+}
+
+// ExtractUMX returns the module file held by the first Music object in an
+// Unreal package
+func ExtractUMX(data []byte) ([]byte, error) {
+	if !IsUMX(data) {
+		return nil, ErrNotUMX
+	}
+	r := reader{format: "umx", data: data}
+	version := int(binary.LittleEndian.Uint16(data[4:6]))
+	nameCount := int(binary.LittleEndian.Uint32(data[12:16]))
+	nameOffset := int(binary.LittleEndian.Uint32(data[16:20]))
+	exportCount := int(binary.LittleEndian.Uint32(data[20:24]))
+	exportOffset := int(binary.LittleEndian.Uint32(data[24:28]))
+	importCount := int(binary.LittleEndian.Uint32(data[28:32]))
+	importOffset := int(binary.LittleEndian.Uint32(data[32:36]))
+	// every entry takes at least a byte, so larger counts can't be right
+	for _, count := range []struct {
+		field string
+		offset int
+		value int
+	}{{"nameCount", 12, nameCount}, {"exportCount", 20, exportCount}, {"importCount", 28, importCount}} {
+		if (count.value > len(data)) {
+			return nil, r.invalid(count.field, count.offset, count.value)
+		}
+	}
+
+	names := make([]string, nameCount)
+	offset := nameOffset
+	for i := range names {
+		field := fmt.Sprintf("name[%d]", i)
+		if (version < 64) {
+			// older packages end names with a zero byte
+			if err := r.check(field, offset, 1); err != nil {
+				return nil, err
+			}
+			end := bytes.IndexByte(data[offset:], 0)
+			if (end < 0) {
+				return nil, r.wrap(field, offset, ErrTruncated)
+			}
+			names[i] = string(data[offset:offset+end])
+			offset += end + 1
+		} else {
+			length, next, err := readUMXIndex(r, field, offset)
+			if err != nil {
+				return nil, err
+			}
+			name, err := r.slice(field, next, length)
+			if err != nil {
+				return nil, err
+			}
+			names[i] = strings.TrimRight(string(name), "\x00")
+			offset = next + length
+		}
+		// the name's object flags
+		if err := r.check(field + ".flags", offset, 4); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+	name := func(i int) string {
+		if (i < 0 || i >= len(names)) {
+			return ""
+		}
+		return names[i]
+	}
+
+	// only the object name of an import is needed, to find the Music class
+	imports := make([]int, importCount)
+	offset = importOffset
+	for i := range imports {
+		field := fmt.Sprintf("import[%d]", i)
+		var err error
+		for _, part := range []string{".classPackage", ".className"} {
+			if _, offset, err = readUMXIndex(r, field + part, offset); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.check(field + ".package", offset, 4); err != nil {
+			return nil, err
+		}
+		if imports[i], offset, err = readUMXIndex(r, field + ".objectName", offset + 4); err != nil {
+			return nil, err
+		}
+	}
+
+	offset = exportOffset
+	for i := 0; i < exportCount; i++ {
+		field := fmt.Sprintf("export[%d]", i)
+		var e umxExport
+		var err error
+		if e.class, offset, err = readUMXIndex(r, field + ".class", offset); err != nil {
+			return nil, err
+		}
+		if _, offset, err = readUMXIndex(r, field + ".super", offset); err != nil {
+			return nil, err
+		}
+		if err := r.check(field + ".package", offset, 4); err != nil {
+			return nil, err
+		}
+		if e.name, offset, err = readUMXIndex(r, field + ".objectName", offset + 4); err != nil {
+			return nil, err
+		}
+		if err := r.check(field + ".flags", offset, 4); err != nil {
+			return nil, err
+		}
+		if e.serialSize, offset, err = readUMXIndex(r, field + ".serialSize", offset + 4); err != nil {
+			return nil, err
+		}
+		if (e.serialSize > 0) {
+			if e.serialOffset, offset, err = readUMXIndex(r, field + ".serialOffset", offset); err != nil {
+				return nil, err
+			}
+		}
+
+		// a negative class refers to an import
+		if (e.class >= 0 || -e.class > len(imports) || !strings.EqualFold(name(imports[-e.class-1]), "Music")) {
+			continue
+		}
+		return readUMXMusic(r, field, version, e)
+	}
+	return nil, ErrNoMusic
+}
+
+// readUMXMusic returns the module file from the serialized data of a Music
+// object, which is laid out differently by each generation of the engine
+func readUMXMusic(r reader, field string, version int, e umxExport) ([]byte, error) {
+	if err := r.check(field + ".serialData", e.serialOffset, e.serialSize); err != nil {
+		return nil, err
+	}
+	// reads stop at the end of the object
+	object := reader{format: r.format, data: r.data[:e.serialOffset+e.serialSize]}
+	offset := e.serialOffset
+	if (version < 40) {
+		offset += 8
+	}
+	if (version < 60) {
+		offset += 16
+	}
+
+	var err error
+	skipIndex := func(part string) {
+		if (err == nil) {
+			_, offset, err = readUMXIndex(object, field + part, offset)
+		}
+	}
+	// the property list, which is empty apart from the "None" ending it
+	skipIndex(".properties")
+	switch {
+	case version >= 120:
+		// Unreal Tournament 2003 and later
+		skipIndex(".format")
+		offset += 8
+	case version >= 100:
+		offset += 4
+		skipIndex(".format")
+		offset += 4
+	case version >= 62:
+		// Unreal Tournament
+		skipIndex(".format")
+		offset += 4
+	default:
+		skipIndex(".format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	size, offset, err := readUMXIndex(object, field + ".size", offset)
+	if err != nil {
+		return nil, err
+	}
+	return object.slice(field + ".music", offset, size)
+}
@@ -0,0 +1,157 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// appendUMXIndex appends value as a compact index
+func appendUMXIndex(out []byte, value int) []byte {
+	first := byte(0)
+	if (value < 0) {
+		first = 0x80
+		value = -value
+	}
+	first |= byte(value & 0x3F)
+	value >>= 6
+	if (value > 0) {
+		first |= 0x40
+	}
+	out = append(out, first)
+	for value > 0 {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if (value > 0) {
+			b |= 0x80
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// buildTestUMX returns an Unreal package of the given version exporting a
+// Sound object, then an object of the given class holding music
+func buildTestUMX(version int, class string, music []byte) []byte {
+	names := []string{"None", "Core", "Class", "Sound", class, "it", "Intro", "Song"}
+
+	// the serialized objects go straight after the header, so their offsets
+	// are known before the tables are written
+	sound := []byte{0, 0, 0, 0}
+	object := make([]byte, 0)
+	if (version < 40) {
+		object = append(object, make([]byte, 8)...)
+	}
+	if (version < 60) {
+		object = append(object, make([]byte, 16)...)
+	}
+	object = appendUMXIndex(object, 0)
+	switch {
+	case version >= 120:
+		object = appendUMXIndex(object, 5)
+		object = append(object, make([]byte, 8)...)
+	case version >= 100:
+		object = append(object, make([]byte, 4)...)
+		object = appendUMXIndex(object, 5)
+		object = append(object, make([]byte, 4)...)
+	case version >= 62:
+		object = appendUMXIndex(object, 5)
+		object = binary.LittleEndian.AppendUint32(object, uint32(len(music)))
+	default:
+		object = appendUMXIndex(object, 5)
+	}
+	object = appendUMXIndex(object, len(music))
+	object = append(object, music...)
+
+	out := make([]byte, umxHeaderSize)
+	out = append(out, sound...)
+	out = append(out, object...)
+
+	nameOffset := len(out)
+	for _, name := range names {
+		if (version < 64) {
+			out = append(out, name...)
+			out = append(out, 0)
+		} else {
+			out = appendUMXIndex(out, len(name) + 1)
+			out = append(out, name...)
+			out = append(out, 0)
+		}
+		out = binary.LittleEndian.AppendUint32(out, 0x04070010)
+	}
+
+	importOffset := len(out)
+	for _, class := range []int{3, 4} {
+		out = appendUMXIndex(out, 1)
+		out = appendUMXIndex(out, 2)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = appendUMXIndex(out, class)
+	}
+
+	exportOffset := len(out)
+	for i, export := range []struct {
+		name int
+		offset int
+		size int
+	}{
+		{6, umxHeaderSize, len(sound)},
+		{7, umxHeaderSize + len(sound), len(object)},
+	} {
+		out = appendUMXIndex(out, -i-1)
+		out = appendUMXIndex(out, 0)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = appendUMXIndex(out, export.name)
+		out = binary.LittleEndian.AppendUint32(out, 0x00070004)
+		out = appendUMXIndex(out, export.size)
+		out = appendUMXIndex(out, export.offset)
+	}
+
+	binary.LittleEndian.PutUint32(out[0:4], umxSignature)
+	binary.LittleEndian.PutUint16(out[4:6], uint16(version))
+	binary.LittleEndian.PutUint32(out[12:16], uint32(len(names)))
+	binary.LittleEndian.PutUint32(out[16:20], uint32(nameOffset))
+	binary.LittleEndian.PutUint32(out[20:24], 2)
+	binary.LittleEndian.PutUint32(out[24:28], uint32(exportOffset))
+	binary.LittleEndian.PutUint32(out[28:32], 2)
+	binary.LittleEndian.PutUint32(out[32:36], uint32(importOffset))
+	return out
+}
+
+func TestExtractUMX(t *testing.T) {
+	// long enough that the music's size takes more than one byte
+	music := buildTestXM(4, []int{64}, [][]byte{bytes.Repeat([]byte{0x80}, 4*64)})
+
+	for _, version := range []int{35, 61, 69, 100, 128} {
+		umx := buildTestUMX(version, "Music", music)
+		out, err := ExtractUMX(umx)
+		if err != nil {
+			t.Errorf("version %d: Unexpected error: %v", version, err)
+		} else if !bytes.Equal(out, music) {
+			t.Errorf("version %d: extracted music doesn't match", version)
+		}
+
+		m, err := LoadBytes(umx, "song.umx")
+		if err != nil {
+			t.Errorf("version %d: Unexpected error: %v", version, err)
+			continue
+		}
+		if (m.Type() != FASTTRACKER || m.NumPatterns() != 1) {
+			t.Errorf("version %d: got type %d with %d patterns", version, m.Type(), m.NumPatterns())
+		}
+	}
+
+	if _, err := ExtractUMX(music); !errors.Is(err, ErrNotUMX) {
+		t.Errorf("Expected ErrNotUMX, got %v", err)
+	}
+	if _, err := ExtractUMX(buildTestUMX(69, "Texture", music)); !errors.Is(err, ErrNoMusic) {
+		t.Errorf("Expected ErrNoMusic, got %v", err)
+	}
+	// music running past the end of its object
+	umx := buildTestUMX(69, "Music", music)
+	binary.LittleEndian.PutUint16(umx[umxHeaderSize+4+6:], 0x7F7F)
+	var formatError *FormatError
+	if _, err := ExtractUMX(umx); !errors.As(err, &formatError) || !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected a truncated FormatError, got %v", err)
+	}
+}
@@ -5,3 +5,17 @@ import "strings"
 func filterNulls(s string) string {
 	return strings.Replace(s, "\x00", "", -1)
 }
+
+// padString returns s as a fixed length, null padded byte field
+func padString(s string, length int) []byte {
+	r := make([]byte, length)
+	copy(r, s)
+	return r
+}
+
+// padBytes cuts or zero pads b to length bytes
+func padBytes(b []byte, length int) []byte {
+	r := make([]byte, length)
+	copy(r, b)
+	return r
+}
@@ -0,0 +1,25 @@
+-- MySQL schema for the create-sample-db command. Modules inside archives are
+-- recorded with a filename of archive.zip#path/in/archive.
+
+CREATE TABLE IF NOT EXISTS modfile (
+	filename VARCHAR(512) NOT NULL PRIMARY KEY,
+	title VARCHAR(255) NOT NULL,
+	tracker VARCHAR(64) NOT NULL DEFAULT '',
+	tracker_version VARCHAR(32) NOT NULL DEFAULT '',
+	tracker_confidence VARCHAR(16) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS sample (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	filename VARCHAR(255) NOT NULL,
+	sha256 CHAR(64) NOT NULL,
+	modfile VARCHAR(512) NOT NULL,
+	pos INT NOT NULL,
+	len INT NOT NULL,
+	KEY (sha256),
+	KEY (modfile)
+);
+
+-- create-sample-db adds the tracker columns to databases created before
+-- modules were fingerprinted.